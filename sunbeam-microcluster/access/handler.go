@@ -3,10 +3,12 @@ package access
 
 import (
 	"crypto/x509"
+	"fmt"
 	"net/http"
 	"strings"
 
 	"github.com/canonical/lxd/lxd/response"
+	"github.com/canonical/lxd/shared/api"
 	"github.com/canonical/lxd/shared/logger"
 
 	"github.com/canonical/microcluster/rest"
@@ -16,12 +18,38 @@ import (
 	"github.com/canonical/snap-openstack/sunbeam-microcluster/client"
 )
 
+// JujuUserAuthenticator authenticates the juju users of requests to endpoints requiring group memberships.
+type JujuUserAuthenticator interface {
+	// AuthenticateJujuUser returns an Unauthorized error if token is not a valid token of the juju user,
+	// and a Forbidden error if the groups of the juju user do not allow the request r to an endpoint
+	// requiring membership of one of the given groups.
+	AuthenticateJujuUser(state *state.State, r *http.Request, username string, token string, groups []string) error
+
+	// JujuGroupAccessEnabled returns whether the juju users of requests verified against the cluster CA
+	// are checked, otherwise the requests are allowed without juju user credentials.
+	JujuGroupAccessEnabled(state *state.State) (bool, error)
+}
+
 // AuthenticateClusterCAHandler authenticates the cluster CA for incoming requests.
 // It checks if the request is trusted and verifies the client certificate against the cluster CA.
 // If the request is trusted or the client certificate is successfully verified, it allows the request.
 // Otherwise, it returns a forbidden response.
 func AuthenticateClusterCAHandler(state *state.State, r *http.Request) response.Response {
+	resp := access.AllowAuthenticated(state, r)
+
+	// AllowAuthenticated returns EmptySyncResponse if the request is trusted.
+	if resp == response.EmptySyncResponse {
+		return resp
+	}
+
+	return verifyClusterCA(state, r)
+}
 
+// authenticateClusterCAWithGroups is AuthenticateClusterCAHandler for endpoints requiring membership
+// of one of the given groups. Requests verified against the cluster CA rather than trusted must carry
+// the X-Juju-Username and X-Juju-Token headers of a juju user authenticated by users, if users enable
+// juju group access.
+func authenticateClusterCAWithGroups(state *state.State, r *http.Request, users JujuUserAuthenticator, groups []string) response.Response {
 	resp := access.AllowAuthenticated(state, r)
 
 	// AllowAuthenticated returns EmptySyncResponse if the request is trusted.
@@ -29,6 +57,32 @@ func AuthenticateClusterCAHandler(state *state.State, r *http.Request) response.
 		return resp
 	}
 
+	resp = verifyClusterCA(state, r)
+	if resp != response.EmptySyncResponse {
+		return resp
+	}
+
+	return checkJujuGroupAccess(state, r, users, groups)
+}
+
+// checkJujuGroupAccess checks the juju user of a request verified against the cluster CA with checkJujuUser
+// if users enable juju group access, otherwise the request is allowed as by AuthenticateClusterCAHandler.
+func checkJujuGroupAccess(state *state.State, r *http.Request, users JujuUserAuthenticator, groups []string) response.Response {
+	enabled, err := users.JujuGroupAccessEnabled(state)
+	if err != nil {
+		logger.Errorf("Failed to check juju group access: %v", err)
+		return response.InternalError(fmt.Errorf("Failed to check juju group access"))
+	}
+
+	if !enabled {
+		return response.EmptySyncResponse
+	}
+
+	return checkJujuUser(state, r, users, groups)
+}
+
+// verifyClusterCA allows requests with a client certificate signed by the cluster CA.
+func verifyClusterCA(state *state.State, r *http.Request) response.Response {
 	leader, err := state.Leader()
 
 	if err != nil {
@@ -82,6 +136,31 @@ func AuthenticateClusterCAHandler(state *state.State, r *http.Request) response.
 	return response.Forbidden(nil)
 }
 
+// checkJujuUser rejects requests without the X-Juju-Username header, and requests whose X-Juju-Username
+// and X-Juju-Token headers are not authenticated by users for an endpoint requiring one of the given groups.
+func checkJujuUser(state *state.State, r *http.Request, users JujuUserAuthenticator, groups []string) response.Response {
+	username := r.Header.Get("X-Juju-Username")
+	if username == "" {
+		return response.Unauthorized(fmt.Errorf("Juju user credentials are required"))
+	}
+
+	err := users.AuthenticateJujuUser(state, r, username, r.Header.Get("X-Juju-Token"), groups)
+	if err != nil {
+		if api.StatusErrorCheck(err, http.StatusUnauthorized) {
+			return response.Unauthorized(err)
+		}
+
+		if api.StatusErrorCheck(err, http.StatusForbidden) {
+			return response.Forbidden(err)
+		}
+
+		logger.Errorf("Failed to authenticate juju user %q: %v", username, err)
+		return response.InternalError(fmt.Errorf("Failed to authenticate juju user %q", username))
+	}
+
+	return response.EmptySyncResponse
+}
+
 // AuthenticateUnixHandler only allow requests coming from the unix socket.
 func AuthenticateUnixHandler(_ *state.State, r *http.Request) response.Response {
 	if r.RemoteAddr == "@" {
@@ -99,3 +178,16 @@ func ClusterCATrustedEndpoint(handler func(state *state.State, r *http.Request)
 		ProxyTarget:    proxyTarget,
 	}
 }
+
+// ClusterCATrustedGroupEndpoint is ClusterCATrustedEndpoint for endpoints requiring membership of one
+// of the given groups from the juju user of requests verified against the cluster CA.
+func ClusterCATrustedGroupEndpoint(handler func(state *state.State, r *http.Request) response.Response, proxyTarget bool, users JujuUserAuthenticator, groups ...string) rest.EndpointAction {
+	return rest.EndpointAction{
+		Handler: handler,
+		AccessHandler: func(state *state.State, r *http.Request) response.Response {
+			return authenticateClusterCAWithGroups(state, r, users, groups)
+		},
+		AllowUntrusted: true,
+		ProxyTarget:    proxyTarget,
+	}
+}
@@ -0,0 +1,113 @@
+package access
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/canonical/lxd/lxd/response"
+	"github.com/canonical/lxd/shared/api"
+	"github.com/canonical/microcluster/state"
+)
+
+// fakeJujuUsers authenticates juju users with the error of their name in errs, and enables juju group
+// access with enabled and err.
+type fakeJujuUsers struct {
+	errs    map[string]error
+	enabled bool
+	err     error
+}
+
+// AuthenticateJujuUser implements JujuUserAuthenticator.
+func (f fakeJujuUsers) AuthenticateJujuUser(_ *state.State, _ *http.Request, username string, _ string, _ []string) error {
+	return f.errs[username]
+}
+
+// JujuGroupAccessEnabled implements JujuUserAuthenticator.
+func (f fakeJujuUsers) JujuGroupAccessEnabled(_ *state.State) (bool, error) {
+	return f.enabled, f.err
+}
+
+func TestCheckJujuUser(t *testing.T) {
+	users := fakeJujuUsers{errs: map[string]error{
+		"expired":  api.StatusErrorf(http.StatusUnauthorized, "Token of juju user %q expired", "expired"),
+		"readonly": api.StatusErrorf(http.StatusForbidden, "Juju user %q is not allowed to POST this endpoint", "readonly"),
+		"broken":   errors.New("database is locked"),
+	}}
+
+	tests := []struct {
+		name     string
+		username string
+		want     int
+	}{
+		{name: "missing username", username: "", want: http.StatusUnauthorized},
+		{name: "authenticated", username: "admin", want: http.StatusOK},
+		{name: "unauthorized", username: "expired", want: http.StatusUnauthorized},
+		{name: "forbidden", username: "readonly", want: http.StatusForbidden},
+		{name: "failure", username: "broken", want: http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/1.0/jujuusers", nil)
+			if tt.username != "" {
+				r.Header.Set("X-Juju-Username", tt.username)
+				r.Header.Set("X-Juju-Token", "token")
+			}
+
+			resp := checkJujuUser(nil, r, users, []string{"admin"})
+			if tt.want == http.StatusOK {
+				if resp != response.EmptySyncResponse {
+					t.Errorf("Request of %q was rejected", tt.username)
+				}
+
+				return
+			}
+
+			w := httptest.NewRecorder()
+			err := resp.Render(w)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if w.Code != tt.want {
+				t.Errorf("Request of %q returned %d, want %d", tt.username, w.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckJujuGroupAccess(t *testing.T) {
+	tests := []struct {
+		name     string
+		users    fakeJujuUsers
+		username string
+		want     int
+	}{
+		{name: "disabled without juju user", users: fakeJujuUsers{}, want: http.StatusOK},
+		{name: "enabled without juju user", users: fakeJujuUsers{enabled: true}, want: http.StatusUnauthorized},
+		{name: "enabled with juju user", users: fakeJujuUsers{enabled: true}, username: "admin", want: http.StatusOK},
+		{name: "failure", users: fakeJujuUsers{err: errors.New("database is locked")}, want: http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/1.0/jujuusers", nil)
+			if tt.username != "" {
+				r.Header.Set("X-Juju-Username", tt.username)
+				r.Header.Set("X-Juju-Token", "token")
+			}
+
+			w := httptest.NewRecorder()
+			err := checkJujuGroupAccess(nil, r, tt.users, []string{"admin"}).Render(w)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if w.Code != tt.want {
+				t.Errorf("Request of %q returned %d, want %d", tt.username, w.Code, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,151 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/canonical/lxd/lxd/response"
+	"github.com/canonical/microcluster/rest"
+	"github.com/canonical/microcluster/state"
+	"github.com/gorilla/mux"
+
+	"github.com/canonical/snap-openstack/sunbeam-microcluster/access"
+	"github.com/canonical/snap-openstack/sunbeam-microcluster/api/types"
+	"github.com/canonical/snap-openstack/sunbeam-microcluster/sunbeam"
+)
+
+// /1.0/admin/db/table-sizes endpoint.
+// Only allowed over the Unix socket.
+var adminDBTableSizesCmd = rest.Endpoint{
+	Path: "admin/db/table-sizes",
+
+	Get: rest.EndpointAction{
+		Handler:       cmdAdminDBTableSizesGet,
+		AccessHandler: access.AuthenticateUnixHandler,
+	},
+}
+
+// /1.0/admin/config/namespace-policies endpoint.
+// Only allowed over the Unix socket.
+var adminConfigNamespacePoliciesCmd = rest.Endpoint{
+	Path: "admin/config/namespace-policies",
+
+	Get: rest.EndpointAction{
+		Handler:       cmdAdminConfigNamespacePoliciesGetAll,
+		AccessHandler: access.AuthenticateUnixHandler,
+	},
+	Post: rest.EndpointAction{
+		Handler:       cmdAdminConfigNamespacePoliciesPost,
+		AccessHandler: access.AuthenticateUnixHandler,
+	},
+}
+
+// /1.0/admin/config/namespace-policies/<namespace> endpoint.
+// Only allowed over the Unix socket.
+var adminConfigNamespacePolicyCmd = rest.Endpoint{
+	Path: "admin/config/namespace-policies/{namespace}",
+
+	Get: rest.EndpointAction{
+		Handler:       cmdAdminConfigNamespacePolicyGet,
+		AccessHandler: access.AuthenticateUnixHandler,
+	},
+	Put: rest.EndpointAction{
+		Handler:       cmdAdminConfigNamespacePolicyPut,
+		AccessHandler: access.AuthenticateUnixHandler,
+	},
+	Delete: rest.EndpointAction{
+		Handler:       cmdAdminConfigNamespacePolicyDelete,
+		AccessHandler: access.AuthenticateUnixHandler,
+	},
+}
+
+func cmdAdminDBTableSizesGet(s *state.State, _ *http.Request) response.Response {
+	sizes, err := sunbeam.GetDatabaseTableSizes(s)
+	if err != nil {
+		return response.InternalError(err)
+	}
+
+	return response.SyncResponse(true, sizes)
+}
+
+func cmdAdminConfigNamespacePoliciesGetAll(s *state.State, _ *http.Request) response.Response {
+	policies, err := sunbeam.ListConfigNamespacePolicies(s)
+	if err != nil {
+		return response.InternalError(err)
+	}
+
+	return response.SyncResponse(true, policies)
+}
+
+func cmdAdminConfigNamespacePoliciesPost(s *state.State, r *http.Request) response.Response {
+	var req types.ConfigNamespacePolicy
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		return response.BadRequest(err)
+	}
+
+	err = sunbeam.CreateConfigNamespacePolicy(s, req)
+	if err != nil {
+		return response.SmartError(err)
+	}
+
+	return response.EmptySyncResponse
+}
+
+func cmdAdminConfigNamespacePolicyGet(s *state.State, r *http.Request) response.Response {
+	namespace, err := url.PathUnescape(mux.Vars(r)["namespace"])
+	if err != nil {
+		return response.InternalError(err)
+	}
+
+	policy, err := sunbeam.GetConfigNamespacePolicy(s, namespace)
+	if err != nil {
+		return response.SmartError(err)
+	}
+
+	return response.SyncResponse(true, policy)
+}
+
+func cmdAdminConfigNamespacePolicyPut(s *state.State, r *http.Request) response.Response {
+	var req types.ConfigNamespacePolicy
+
+	namespace, err := url.PathUnescape(mux.Vars(r)["namespace"])
+	if err != nil {
+		return response.InternalError(err)
+	}
+
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		return response.BadRequest(err)
+	}
+
+	if req.Namespace != "" && req.Namespace != namespace {
+		return response.BadRequest(fmt.Errorf("Namespace %q in body does not match %q", req.Namespace, namespace))
+	}
+
+	req.Namespace = namespace
+
+	err = sunbeam.UpdateConfigNamespacePolicy(s, req)
+	if err != nil {
+		return response.SmartError(err)
+	}
+
+	return response.EmptySyncResponse
+}
+
+func cmdAdminConfigNamespacePolicyDelete(s *state.State, r *http.Request) response.Response {
+	namespace, err := url.PathUnescape(mux.Vars(r)["namespace"])
+	if err != nil {
+		return response.InternalError(err)
+	}
+
+	err = sunbeam.DeleteConfigNamespacePolicy(s, namespace)
+	if err != nil {
+		return response.SmartError(err)
+	}
+
+	return response.EmptySyncResponse
+}
@@ -2,43 +2,303 @@ package api
 
 import (
 	"bytes"
+	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/url"
+	"time"
 
 	"github.com/canonical/lxd/lxd/response"
+	"github.com/canonical/lxd/lxd/util"
 	"github.com/canonical/lxd/shared/api"
 	"github.com/canonical/microcluster/rest"
 	"github.com/canonical/microcluster/state"
 	"github.com/gorilla/mux"
 
 	"github.com/canonical/snap-openstack/sunbeam-microcluster/access"
+	"github.com/canonical/snap-openstack/sunbeam-microcluster/api/types"
 	"github.com/canonical/snap-openstack/sunbeam-microcluster/sunbeam"
 )
 
+// /1.0/configs endpoint.
+// GET lists the config keys with their value and description, except the terraform states and locks.
+var configsCmd = rest.Endpoint{
+	Path: "configs",
+
+	Get: access.ClusterCATrustedEndpoint(cmdConfigsGetAll, true),
+}
+
+// /1.0/config endpoint.
+// GET lists the config keys, optionally starting with ?prefix=, without their
+// values unless ?include-values=true is given.
+var configKeysCmd = rest.Endpoint{
+	Path: "config",
+
+	Get: access.ClusterCATrustedEndpoint(cmdConfigKeysGet, true),
+}
+
+// /1.0/config/bulk endpoint.
+// PUT writes all the keys of a JSON object atomically, it is registered
+// before /1.0/config/<name> so "bulk" is a reserved config key name.
+var configBulkCmd = rest.Endpoint{
+	Path: "config/bulk",
+
+	Put: access.ClusterCATrustedEndpoint(cmdConfigBulkPut, true),
+}
+
+// /1.0/config/history endpoint.
+// GET returns the changes of all config keys, most recent first, it is registered
+// before /1.0/config/<name> so "history" is a reserved config key name.
+var configHistoryCmd = rest.Endpoint{
+	Path: "config/history",
+
+	Get: access.ClusterCATrustedEndpoint(cmdConfigHistoryGet, true),
+}
+
+// /1.0/config/watch endpoint.
+// GET streams the changes of the config keys made on this cluster member
+// as Server-Sent Events, filtered by the ?prefix= of their key, it is registered
+// before /1.0/config/<name> so "watch" is a reserved config key name.
+var configWatchCmd = rest.Endpoint{
+	Path: "config/watch",
+
+	Get: access.ClusterCATrustedEndpoint(cmdConfigWatchGet, true),
+}
+
 // /1.0/config/<name> endpoint.
 var configCmd = rest.Endpoint{
 	Path: "config/{key}",
 
 	Get:    access.ClusterCATrustedEndpoint(cmdConfigGet, true),
 	Put:    access.ClusterCATrustedEndpoint(cmdConfigPut, true),
+	Patch:  access.ClusterCATrustedEndpoint(cmdConfigPatch, true),
 	Delete: access.ClusterCATrustedEndpoint(cmdConfigDelete, true),
 }
 
-func cmdConfigGet(s *state.State, r *http.Request) response.Response {
-	var key string
+// /1.0/config/<name>/description endpoint.
+var configDescriptionCmd = rest.Endpoint{
+	Path: "config/{key}/description",
+
+	Put: access.ClusterCATrustedEndpoint(cmdConfigDescriptionPut, true),
+}
+
+// /1.0/config/<name>/history endpoint.
+var configKeyHistoryCmd = rest.Endpoint{
+	Path: "config/{key}/history",
+
+	Get: access.ClusterCATrustedEndpoint(cmdConfigKeyHistoryGet, true),
+}
+
+// /1.0/config/<name>/cas endpoint.
+var configCompareAndSwapCmd = rest.Endpoint{
+	Path: "config/{key}/cas",
+
+	Post: access.ClusterCATrustedEndpoint(cmdConfigCompareAndSwapPost, true),
+}
+
+// /1.0/config/<name>/revert endpoint.
+// POST sets the key back to its value before the config history entry ?to_id=.
+var configRevertCmd = rest.Endpoint{
+	Path: "config/{key}/revert",
+
+	Post: access.ClusterCATrustedEndpoint(cmdConfigRevertPost, true),
+}
+
+// /1.0/config-schema endpoint.
+var configSchemaCmd = rest.Endpoint{
+	Path: "config-schema",
+
+	Get: access.ClusterCATrustedEndpoint(cmdConfigSchemaGetAll, true),
+}
+
+// /1.0/config-schema/<name> endpoint.
+// PUT declares the type of the values of a config key, values that do
+// not match it are rejected with 400 when the key is written.
+var configSchemaEntryCmd = rest.Endpoint{
+	Path: "config-schema/{key}",
+
+	Put: access.ClusterCATrustedEndpoint(cmdConfigSchemaEntryPut, true),
+}
+
+func cmdConfigsGetAll(s *state.State, r *http.Request) response.Response {
+	// Taken before the query so that changes made while listing are returned by the next sync.
+	serverTime := time.Now().UTC()
+
+	var modifiedSince *time.Time
+	modifiedSinceParam := r.URL.Query().Get("modified_since")
+	if modifiedSinceParam != "" {
+		t, err := time.Parse(time.RFC3339, modifiedSinceParam)
+		if err != nil {
+			return response.BadRequest(fmt.Errorf("Invalid modified_since %q, expected RFC 3339 time: %w", modifiedSinceParam, err))
+		}
+
+		modifiedSince = &t
+	}
+
+	entries, err := sunbeam.ListConfigEntries(s, modifiedSince)
+	if err != nil {
+		return response.InternalError(err)
+	}
+
+	return response.SyncResponseHeaders(true, entries, map[string]string{"X-Server-Time": serverTime.Format(time.RFC3339)})
+}
+
+func cmdConfigKeysGet(s *state.State, r *http.Request) response.Response {
+	prefix := r.URL.Query().Get("prefix")
+
+	if r.URL.Query().Get("include-values") == "true" {
+		entries, err := sunbeam.ListConfigEntriesWithPrefix(s, prefix)
+		if err != nil {
+			return response.InternalError(err)
+		}
+
+		return response.SyncResponse(true, entries)
+	}
+
+	keys, err := sunbeam.ListConfigKeys(s, prefix)
+	if err != nil {
+		return response.InternalError(err)
+	}
+
+	return response.SyncResponse(true, keys)
+}
+
+// cmdConfigBulkPut returns 207 with the result of each key, as keys
+// failing validation prevent the other keys from being written.
+func cmdConfigBulkPut(s *state.State, r *http.Request) response.Response {
+	var req map[string]string
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		return response.BadRequest(err)
+	}
+
+	results, err := sunbeam.UpdateConfigs(s, req)
+	if err != nil {
+		return response.SmartError(err)
+	}
+
+	return response.ManualResponse(func(w http.ResponseWriter) error {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusMultiStatus)
+		return util.WriteJSON(w, api.ResponseRaw{
+			Type:       api.SyncResponse,
+			Status:     http.StatusText(http.StatusMultiStatus),
+			StatusCode: http.StatusMultiStatus,
+			Metadata:   results,
+		}, nil)
+	})
+}
+
+func cmdConfigHistoryGet(s *state.State, r *http.Request) response.Response {
+	return configHistory(s, r, nil)
+}
+
+func cmdConfigKeyHistoryGet(s *state.State, r *http.Request) response.Response {
 	key, err := url.PathUnescape(mux.Vars(r)["key"])
 	if err != nil {
 		return response.InternalError(err)
 	}
-	config, err := sunbeam.GetConfig(s, key)
+
+	return configHistory(s, r, &key)
+}
+
+// configHistory returns the config history of key, or of all keys if nil,
+// filtered by the ?since= and ?limit= query parameters.
+func configHistory(s *state.State, r *http.Request, key *string) response.Response {
+	query := r.URL.Query()
+
+	var since *time.Time
+	sinceParam := query.Get("since")
+	if sinceParam != "" {
+		t, err := time.Parse(time.RFC3339, sinceParam)
+		if err != nil {
+			return response.BadRequest(fmt.Errorf("Invalid since %q, expected RFC 3339 time: %w", sinceParam, err))
+		}
+
+		since = &t
+	}
+
+	limit, err := positiveQueryInt(query, "limit", 0)
 	if err != nil {
-		if err, ok := err.(api.StatusError); ok {
-			if err.Status() == http.StatusNotFound {
-				return response.NotFound(err)
+		return response.BadRequest(err)
+	}
+
+	history, err := sunbeam.GetConfigHistory(s, key, since, limit)
+	if err != nil {
+		return response.InternalError(err)
+	}
+
+	return response.SyncResponse(true, history)
+}
+
+// configWatchKeepalive is the interval of the comments sent on an idle config watch
+// stream so that proxies and clients do not time it out.
+const configWatchKeepalive = 30 * time.Second
+
+func cmdConfigWatchGet(s *state.State, r *http.Request) response.Response {
+	prefix := r.URL.Query().Get("prefix")
+
+	return response.ManualResponse(func(w http.ResponseWriter) error {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			return fmt.Errorf("Streaming is not supported")
+		}
+
+		events, unsubscribe := sunbeam.WatchConfig(prefix)
+		defer unsubscribe()
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+
+		keepalive := time.NewTicker(configWatchKeepalive)
+		defer keepalive.Stop()
+
+		for {
+			select {
+			case <-r.Context().Done():
+				return nil
+			case <-s.Context.Done():
+				return nil
+			case <-keepalive.C:
+				_, err := fmt.Fprint(w, ": keepalive\n\n")
+				if err != nil {
+					return err
+				}
+			case event := <-events:
+				data, err := json.Marshal(event)
+				if err != nil {
+					return err
+				}
+
+				_, err = fmt.Fprintf(w, "data: %s\n\n", data)
+				if err != nil {
+					return err
+				}
 			}
+
+			flusher.Flush()
 		}
+	})
+}
+
+func cmdConfigGet(s *state.State, r *http.Request) response.Response {
+	var key string
+	key, err := url.PathUnescape(mux.Vars(r)["key"])
+	if err != nil {
 		return response.InternalError(err)
 	}
+	config, expiresAt, err := sunbeam.GetConfigWithExpiry(s, key)
+	if err != nil {
+		return response.SmartError(err)
+	}
+
+	if expiresAt != nil {
+		return response.SyncResponseHeaders(true, config, map[string]string{"X-Config-Expires-At": expiresAt.Format(time.RFC3339)})
+	}
 
 	return response.SyncResponse(true, config)
 }
@@ -55,14 +315,46 @@ func cmdConfigPut(s *state.State, r *http.Request) response.Response {
 		return response.InternalError(err)
 	}
 
-	err = sunbeam.UpdateConfig(s, key, body.String())
+	// The expiry is only given by the X-Config-Expires-At header, which GET returns
+	var expiresAt *time.Time
+	expiresAtHeader := r.Header.Get("X-Config-Expires-At")
+	if expiresAtHeader != "" {
+		t, err := time.Parse(time.RFC3339, expiresAtHeader)
+		if err != nil {
+			return response.BadRequest(fmt.Errorf("Invalid X-Config-Expires-At %q, expected RFC 3339 time: %w", expiresAtHeader, err))
+		}
+
+		expiresAt = &t
+	}
+
+	err = sunbeam.UpdateConfigWithExpiry(s, key, body.String(), expiresAt)
 	if err != nil {
-		return response.InternalError(err)
+		return response.SmartError(err)
 	}
 
 	return response.EmptySyncResponse
 }
 
+func cmdConfigPatch(s *state.State, r *http.Request) response.Response {
+	key, err := url.PathUnescape(mux.Vars(r)["key"])
+	if err != nil {
+		return response.InternalError(err)
+	}
+
+	var body bytes.Buffer
+	_, err = body.ReadFrom(r.Body)
+	if err != nil {
+		return response.InternalError(err)
+	}
+
+	merged, err := sunbeam.PatchConfig(s, key, body.String())
+	if err != nil {
+		return response.SmartError(err)
+	}
+
+	return response.SyncResponse(true, merged)
+}
+
 func cmdConfigDelete(s *state.State, r *http.Request) response.Response {
 	key, err := url.PathUnescape(mux.Vars(r)["key"])
 	if err != nil {
@@ -70,6 +362,27 @@ func cmdConfigDelete(s *state.State, r *http.Request) response.Response {
 	}
 
 	err = sunbeam.DeleteConfig(s, key)
+	if err != nil {
+		return response.SmartError(err)
+	}
+
+	return response.EmptySyncResponse
+}
+
+func cmdConfigDescriptionPut(s *state.State, r *http.Request) response.Response {
+	var req types.ConfigDescription
+
+	key, err := url.PathUnescape(mux.Vars(r)["key"])
+	if err != nil {
+		return response.InternalError(err)
+	}
+
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		return response.BadRequest(err)
+	}
+
+	err = sunbeam.SetConfigDescription(s, key, req.Description)
 	if err != nil {
 		if err, ok := err.(api.StatusError); ok {
 			if err.Status() == http.StatusNotFound {
@@ -81,3 +394,83 @@ func cmdConfigDelete(s *state.State, r *http.Request) response.Response {
 
 	return response.EmptySyncResponse
 }
+
+func cmdConfigCompareAndSwapPost(s *state.State, r *http.Request) response.Response {
+	var req types.ConfigCompareAndSwap
+
+	key, err := url.PathUnescape(mux.Vars(r)["key"])
+	if err != nil {
+		return response.InternalError(err)
+	}
+
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		return response.BadRequest(err)
+	}
+
+	swapped, err := sunbeam.CompareAndSwapConfig(s, key, req.Expected, req.New)
+	if err != nil {
+		return response.SmartError(err)
+	}
+
+	return response.SyncResponse(true, types.ConfigCompareAndSwapResult{Swapped: swapped})
+}
+
+func cmdConfigRevertPost(s *state.State, r *http.Request) response.Response {
+	key, err := url.PathUnescape(mux.Vars(r)["key"])
+	if err != nil {
+		return response.InternalError(err)
+	}
+
+	historyID, err := positiveQueryInt(r.URL.Query(), "to_id", 0)
+	if err != nil {
+		return response.BadRequest(err)
+	}
+
+	if historyID == 0 {
+		return response.BadRequest(fmt.Errorf("Missing to_id, the config history entry to revert to"))
+	}
+
+	value, err := sunbeam.RevertConfig(s, key, int64(historyID))
+	if err != nil {
+		return response.SmartError(err)
+	}
+
+	return response.SyncResponse(true, value)
+}
+
+func cmdConfigSchemaGetAll(s *state.State, _ *http.Request) response.Response {
+	schema, err := sunbeam.ListConfigSchema(s)
+	if err != nil {
+		return response.InternalError(err)
+	}
+
+	return response.SyncResponse(true, schema)
+}
+
+func cmdConfigSchemaEntryPut(s *state.State, r *http.Request) response.Response {
+	var req types.ConfigSchemaEntry
+
+	key, err := url.PathUnescape(mux.Vars(r)["key"])
+	if err != nil {
+		return response.InternalError(err)
+	}
+
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		return response.BadRequest(err)
+	}
+
+	if req.Key != "" && req.Key != key {
+		return response.BadRequest(fmt.Errorf("Key %q in body does not match %q", req.Key, key))
+	}
+
+	req.Key = key
+
+	err = sunbeam.SetConfigSchemaEntry(s, req)
+	if err != nil {
+		return response.SmartError(err)
+	}
+
+	return response.EmptySyncResponse
+}
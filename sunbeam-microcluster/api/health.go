@@ -0,0 +1,28 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/canonical/lxd/lxd/response"
+	"github.com/canonical/microcluster/rest"
+	"github.com/canonical/microcluster/state"
+
+	"github.com/canonical/snap-openstack/sunbeam-microcluster/access"
+	"github.com/canonical/snap-openstack/sunbeam-microcluster/sunbeam"
+)
+
+// /1.0/health endpoint.
+var healthCmd = rest.Endpoint{
+	Path: "health",
+
+	Get: access.ClusterCATrustedEndpoint(cmdHealthGet, true),
+}
+
+func cmdHealthGet(s *state.State, _ *http.Request) response.Response {
+	health, err := sunbeam.CollectHealth(s)
+	if err != nil {
+		return response.InternalError(err)
+	}
+
+	return response.SyncResponse(true, health)
+}
@@ -2,8 +2,10 @@ package api
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/url"
+	"time"
 
 	"github.com/canonical/lxd/lxd/response"
 	"github.com/canonical/lxd/shared/api"
@@ -16,20 +18,68 @@ import (
 	"github.com/canonical/snap-openstack/sunbeam-microcluster/sunbeam"
 )
 
+// jujuUserAuthenticator authenticates the juju users of requests to endpoints requiring group memberships.
+type jujuUserAuthenticator struct{}
+
+// AuthenticateJujuUser implements access.JujuUserAuthenticator.
+func (jujuUserAuthenticator) AuthenticateJujuUser(s *state.State, r *http.Request, username string, token string, groups []string) error {
+	return sunbeam.AuthenticateJujuUser(s, r, username, token, groups)
+}
+
+// JujuGroupAccessEnabled implements access.JujuUserAuthenticator.
+func (jujuUserAuthenticator) JujuGroupAccessEnabled(s *state.State) (bool, error) {
+	return sunbeam.JujuGroupAccessEnabled(s)
+}
+
 // /1.0/jujuusers endpoint.
 var jujuusersCmd = rest.Endpoint{
 	Path: "jujuusers",
 
-	Get:  access.ClusterCATrustedEndpoint(cmdJujuUsersGetAll, true),
-	Post: access.ClusterCATrustedEndpoint(cmdJujuUsersPost, true),
+	Get:  access.ClusterCATrustedGroupEndpoint(cmdJujuUsersGetAll, true, jujuUserAuthenticator{}, sunbeam.JujuGroupAdmin, sunbeam.JujuGroupReadonly),
+	Post: access.ClusterCATrustedGroupEndpoint(cmdJujuUsersPost, true, jujuUserAuthenticator{}, sunbeam.JujuGroupAdmin),
+}
+
+// /1.0/jujuusers/audit endpoint.
+var jujuusersAuditCmd = rest.Endpoint{
+	Path: "jujuusers/audit",
+
+	Get: access.ClusterCATrustedGroupEndpoint(cmdJujuUsersAuditGet, true, jujuUserAuthenticator{}, sunbeam.JujuGroupAdmin, sunbeam.JujuGroupReadonly),
 }
 
 // /1.0/jujuusers/<name> endpoint.
 var jujuuserCmd = rest.Endpoint{
 	Path: "jujuusers/{name}",
 
-	Get:    access.ClusterCATrustedEndpoint(cmdJujuUsersGet, true),
-	Delete: access.ClusterCATrustedEndpoint(cmdJujuUsersDelete, true),
+	Get:    access.ClusterCATrustedGroupEndpoint(cmdJujuUsersGet, true, jujuUserAuthenticator{}, sunbeam.JujuGroupAdmin, sunbeam.JujuGroupReadonly),
+	Delete: access.ClusterCATrustedGroupEndpoint(cmdJujuUsersDelete, true, jujuUserAuthenticator{}, sunbeam.JujuGroupAdmin),
+}
+
+// /1.0/jujuusers/<name>/rotate-token endpoint.
+var jujuuserRotateTokenCmd = rest.Endpoint{
+	Path: "jujuusers/{name}/rotate-token",
+
+	Post: access.ClusterCATrustedGroupEndpoint(cmdJujuUserRotateTokenPost, true, jujuUserAuthenticator{}, sunbeam.JujuGroupAdmin),
+}
+
+// /1.0/jujuusers/<name>/audit endpoint.
+var jujuuserAuditCmd = rest.Endpoint{
+	Path: "jujuusers/{name}/audit",
+
+	Get: access.ClusterCATrustedGroupEndpoint(cmdJujuUserAuditGet, true, jujuUserAuthenticator{}, sunbeam.JujuGroupAdmin, sunbeam.JujuGroupReadonly),
+}
+
+// /1.0/jujuusers/<name>/groups endpoint.
+var jujuuserGroupsCmd = rest.Endpoint{
+	Path: "jujuusers/{name}/groups",
+
+	Post: access.ClusterCATrustedGroupEndpoint(cmdJujuUserGroupsPost, true, jujuUserAuthenticator{}, sunbeam.JujuGroupAdmin),
+}
+
+// /1.0/jujuusers/<name>/groups/<group> endpoint.
+var jujuuserGroupCmd = rest.Endpoint{
+	Path: "jujuusers/{name}/groups/{group}",
+
+	Delete: access.ClusterCATrustedGroupEndpoint(cmdJujuUserGroupDelete, true, jujuUserAuthenticator{}, sunbeam.JujuGroupAdmin),
 }
 
 func cmdJujuUsersGetAll(s *state.State, _ *http.Request) response.Response {
@@ -73,6 +123,8 @@ func cmdJujuUsersPost(s *state.State, r *http.Request) response.Response {
 		return response.InternalError(err)
 	}
 
+	sunbeam.RecordJujuAudit(s, r, req.Username, sunbeam.JujuAuditCreate)
+
 	return response.EmptySyncResponse
 }
 
@@ -86,5 +138,110 @@ func cmdJujuUsersDelete(s *state.State, r *http.Request) response.Response {
 		return response.InternalError(err)
 	}
 
+	sunbeam.RecordJujuAudit(s, r, name, sunbeam.JujuAuditDelete)
+
+	return response.EmptySyncResponse
+}
+
+func cmdJujuUserRotateTokenPost(s *state.State, r *http.Request) response.Response {
+	name, err := url.PathUnescape(mux.Vars(r)["name"])
+	if err != nil {
+		return response.InternalError(err)
+	}
+
+	jujuUser, err := sunbeam.RotateJujuUserToken(s, name)
+	if err != nil {
+		return response.SmartError(err)
+	}
+
+	sunbeam.RecordJujuAudit(s, r, name, sunbeam.JujuAuditRotate)
+
+	return response.SyncResponse(true, jujuUser)
+}
+
+func cmdJujuUserGroupsPost(s *state.State, r *http.Request) response.Response {
+	name, err := url.PathUnescape(mux.Vars(r)["name"])
+	if err != nil {
+		return response.InternalError(err)
+	}
+
+	var req types.JujuUserGroup
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		return response.BadRequest(err)
+	}
+
+	err = sunbeam.AddJujuUserGroup(s, name, req.Group)
+	if err != nil {
+		return response.SmartError(err)
+	}
+
+	return response.EmptySyncResponse
+}
+
+func cmdJujuUserGroupDelete(s *state.State, r *http.Request) response.Response {
+	name, err := url.PathUnescape(mux.Vars(r)["name"])
+	if err != nil {
+		return response.InternalError(err)
+	}
+
+	group, err := url.PathUnescape(mux.Vars(r)["group"])
+	if err != nil {
+		return response.InternalError(err)
+	}
+
+	err = sunbeam.RemoveJujuUserGroup(s, name, group)
+	if err != nil {
+		return response.SmartError(err)
+	}
+
 	return response.EmptySyncResponse
 }
+
+func cmdJujuUsersAuditGet(s *state.State, r *http.Request) response.Response {
+	return jujuAudit(s, r, nil)
+}
+
+func cmdJujuUserAuditGet(s *state.State, r *http.Request) response.Response {
+	name, err := url.PathUnescape(mux.Vars(r)["name"])
+	if err != nil {
+		return response.InternalError(err)
+	}
+
+	return jujuAudit(s, r, &name)
+}
+
+// jujuAudit returns the juju audit log of username, or of all users if nil,
+// filtered by the ?since=, ?action= and ?limit= query parameters.
+func jujuAudit(s *state.State, r *http.Request, username *string) response.Response {
+	query := r.URL.Query()
+
+	var since *time.Time
+	sinceParam := query.Get("since")
+	if sinceParam != "" {
+		t, err := time.Parse(time.RFC3339, sinceParam)
+		if err != nil {
+			return response.BadRequest(fmt.Errorf("Invalid since %q, expected RFC 3339 time: %w", sinceParam, err))
+		}
+
+		since = &t
+	}
+
+	var action *string
+	if query.Has("action") {
+		value := query.Get("action")
+		action = &value
+	}
+
+	limit, err := positiveQueryInt(query, "limit", 0)
+	if err != nil {
+		return response.BadRequest(err)
+	}
+
+	audit, err := sunbeam.GetJujuAudit(s, username, action, since, limit)
+	if err != nil {
+		return response.SmartError(err)
+	}
+
+	return response.SyncResponse(true, audit)
+}
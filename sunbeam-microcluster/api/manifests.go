@@ -2,14 +2,21 @@ package api
 
 import (
 	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
 	"net/http"
 	"net/url"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/canonical/lxd/lxd/response"
 	"github.com/canonical/lxd/shared/api"
 	"github.com/canonical/microcluster/rest"
 	"github.com/canonical/microcluster/state"
 	"github.com/gorilla/mux"
+	"gopkg.in/yaml.v3"
 
 	"github.com/canonical/snap-openstack/sunbeam-microcluster/access"
 	"github.com/canonical/snap-openstack/sunbeam-microcluster/api/types"
@@ -24,6 +31,13 @@ var manifestsCmd = rest.Endpoint{
 	Post: access.ClusterCATrustedEndpoint(cmdManifestsPost, true),
 }
 
+// /1.0/manifests/diff endpoint.
+var manifestDiffCmd = rest.Endpoint{
+	Path: "manifests/diff",
+
+	Get: access.ClusterCATrustedEndpoint(cmdManifestDiffGet, true),
+}
+
 // /1.0/manifests/<manifestid> endpoint.
 // /1.0/manifests/latest will give the latest inserted manifest record
 var manifestCmd = rest.Endpoint{
@@ -33,14 +47,114 @@ var manifestCmd = rest.Endpoint{
 	Delete: access.ClusterCATrustedEndpoint(cmdManifestDelete, true),
 }
 
-func cmdManifestsGetAll(s *state.State, _ *http.Request) response.Response {
+// /1.0/manifests/<manifestid>/status endpoint.
+var manifestStatusCmd = rest.Endpoint{
+	Path: "manifests/{manifestid}/status",
+
+	Put: access.ClusterCATrustedEndpoint(cmdManifestStatusPut, true),
+}
+
+// /1.0/manifests/<manifestid>/rollback endpoint.
+var manifestRollbackCmd = rest.Endpoint{
+	Path: "manifests/{manifestid}/rollback",
+
+	Post: access.ClusterCATrustedEndpoint(cmdManifestRollbackPost, true),
+}
+
+// /1.0/manifests/<manifestid>/retry endpoint.
+var manifestRetryCmd = rest.Endpoint{
+	Path: "manifests/{manifestid}/retry",
 
-	manifests, err := sunbeam.ListManifests(s)
+	Post: access.ClusterCATrustedEndpoint(cmdManifestRetryPost, true),
+}
+
+// cmdManifestsGetAll lists the manifests. If page or page-size is given, a page of
+// manifests is returned along with Link headers to the previous and next pages.
+func cmdManifestsGetAll(s *state.State, r *http.Request) response.Response {
+	query := r.URL.Query()
+
+	filter := sunbeam.ManifestFilter{
+		Status: query.Get("status"),
+		Search: query.Get("search"),
+	}
+
+	var err error
+	filter.Since, err = queryTime(query, "since")
 	if err != nil {
-		return response.InternalError(err)
+		return response.BadRequest(err)
+	}
+
+	filter.Until, err = queryTime(query, "until")
+	if err != nil {
+		return response.BadRequest(err)
+	}
+
+	if !query.Has("page") && !query.Has("page-size") {
+		manifests, _, err := sunbeam.ListManifests(s.Context, s, filter)
+		if err != nil {
+			return response.SmartError(err)
+		}
+
+		return response.SyncResponse(true, manifests)
+	}
+
+	filter.Page, err = positiveQueryInt(query, "page", 1)
+	if err != nil {
+		return response.BadRequest(err)
+	}
+
+	filter.PageSize, err = positiveQueryInt(query, "page-size", defaultManifestPageSize)
+	if err != nil {
+		return response.BadRequest(err)
+	}
+
+	manifests, total, err := sunbeam.ListManifests(s.Context, s, filter)
+	if err != nil {
+		return response.SmartError(err)
+	}
+
+	var links []string
+	if filter.Page > 1 {
+		links = append(links, manifestPageLink(r, filter.Page-1, "prev"))
 	}
 
-	return response.SyncResponse(true, manifests)
+	if filter.Page*filter.PageSize < total {
+		links = append(links, manifestPageLink(r, filter.Page+1, "next"))
+	}
+
+	if len(links) == 0 {
+		return response.SyncResponse(true, manifests)
+	}
+
+	return response.SyncResponseHeaders(true, manifests, map[string]string{"Link": strings.Join(links, ", ")})
+}
+
+// defaultManifestPageSize is the number of manifests per page if page-size is not given
+const defaultManifestPageSize = 100
+
+// manifestPageLink returns an RFC 8288 link to the given page of the manifest list requested by r
+func manifestPageLink(r *http.Request, page int, rel string) string {
+	query := r.URL.Query()
+	query.Set("page", strconv.Itoa(page))
+
+	target := url.URL{Path: r.URL.Path, RawQuery: query.Encode()}
+
+	return fmt.Sprintf("<%s>; rel=%q", target.String(), rel)
+}
+
+// queryTime returns the RFC 3339 time query parameter key, or nil if it is not set
+func queryTime(query url.Values, key string) (*time.Time, error) {
+	value := query.Get(key)
+	if value == "" {
+		return nil, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid %s %q, expected RFC 3339 time: %w", key, value, err)
+	}
+
+	return &t, nil
 }
 
 func cmdManifestGet(s *state.State, r *http.Request) response.Response {
@@ -59,22 +173,97 @@ func cmdManifestGet(s *state.State, r *http.Request) response.Response {
 		return response.InternalError(err)
 	}
 
+	// Send just the manifest data as YAML if the client asks for it.
+	if acceptsYAML(r.Header.Get("Accept")) {
+		data, err := manifestDataToYAML(manifest.Data)
+		if err != nil {
+			return response.InternalError(err)
+		}
+
+		return response.ManualResponse(func(w http.ResponseWriter) error {
+			w.Header().Set("Content-Type", "application/yaml")
+			w.WriteHeader(http.StatusOK)
+			_, err := w.Write(data)
+			return err
+		})
+	}
+
 	return response.SyncResponse(true, manifest)
 }
 
+// acceptsYAML returns whether the Accept header prefers application/yaml over application/json.
+// Wildcards and other media types fall back to JSON.
+func acceptsYAML(accept string) bool {
+	quality := map[string]float64{}
+	for _, mediaRange := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(mediaRange))
+		if err != nil {
+			continue
+		}
+
+		q := 1.0
+		if value, ok := params["q"]; ok {
+			q, err = strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+		}
+
+		quality[mediaType] = q
+	}
+
+	yamlQuality, ok := quality["application/yaml"]
+	if !ok || yamlQuality <= 0 {
+		return false
+	}
+
+	return yamlQuality > quality["application/json"]
+}
+
+// manifestDataToYAML converts the JSON manifest data to YAML.
+// Data that is not JSON is assumed to be YAML already and returned as is.
+func manifestDataToYAML(data string) ([]byte, error) {
+	var content any
+	err := json.Unmarshal([]byte(data), &content)
+	if err != nil {
+		return []byte(data), nil
+	}
+
+	return yaml.Marshal(content)
+}
+
 func cmdManifestsPost(s *state.State, r *http.Request) response.Response {
 	var req types.Manifest
 
-	err := json.NewDecoder(r.Body).Decode(&req)
+	body, err := io.ReadAll(r.Body)
 	if err != nil {
 		return response.InternalError(err)
 	}
 
-	err = sunbeam.AddManifest(s, req.ManifestID, req.Data)
+	// The signature covers the raw request body, so verify it before decoding.
+	signature := r.Header.Get("X-Manifest-Signature")
+	err = sunbeam.VerifyManifestSignature(s, body, signature)
+	if err != nil {
+		return response.SmartError(err)
+	}
+
+	err = json.Unmarshal(body, &req)
 	if err != nil {
 		return response.InternalError(err)
 	}
 
+	if r.URL.Query().Get("ignore-schema-version") != "true" {
+		err = sunbeam.ValidateManifest(s, req)
+		if err != nil {
+			return response.SmartError(err)
+		}
+	}
+
+	err = sunbeam.AddManifest(s, req.ManifestID, req.Data, req.Status, req.RollbackData, signature)
+	if err != nil {
+		return response.SmartError(err)
+	}
+
 	return response.EmptySyncResponse
 }
 
@@ -90,3 +279,82 @@ func cmdManifestDelete(s *state.State, r *http.Request) response.Response {
 
 	return response.EmptySyncResponse
 }
+
+func cmdManifestStatusPut(s *state.State, r *http.Request) response.Response {
+	manifestid, err := url.PathUnescape(mux.Vars(r)["manifestid"])
+	if err != nil {
+		return response.InternalError(err)
+	}
+
+	var req types.ManifestStatus
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		return response.BadRequest(err)
+	}
+
+	err = sunbeam.SetManifestStatus(s, manifestid, req.Status, req.Error)
+	if err != nil {
+		return response.SmartError(err)
+	}
+
+	return response.EmptySyncResponse
+}
+
+func cmdManifestRetryPost(s *state.State, r *http.Request) response.Response {
+	manifestid, err := url.PathUnescape(mux.Vars(r)["manifestid"])
+	if err != nil {
+		return response.InternalError(err)
+	}
+
+	manifest, err := sunbeam.RetryManifest(s, manifestid)
+	if err != nil {
+		return response.SmartError(err)
+	}
+
+	return response.SyncResponse(true, manifest)
+}
+
+func cmdManifestRollbackPost(s *state.State, r *http.Request) response.Response {
+	manifestid, err := url.PathUnescape(mux.Vars(r)["manifestid"])
+	if err != nil {
+		return response.InternalError(err)
+	}
+
+	manifest, err := sunbeam.RollbackManifest(s.Context, s, manifestid)
+	if err != nil {
+		return response.SmartError(err)
+	}
+
+	return response.SyncResponse(true, manifest)
+}
+
+func cmdManifestDiffGet(s *state.State, r *http.Request) response.Response {
+	query := r.URL.Query()
+
+	from := query.Get("from")
+	to := query.Get("to")
+	if from == "" || to == "" {
+		return response.BadRequest(fmt.Errorf("Both from and to manifest ids are required"))
+	}
+
+	format := query.Get("format")
+	if format != "" && format != "json" && format != "text" {
+		return response.BadRequest(fmt.Errorf("Invalid format %q, expected json or text", format))
+	}
+
+	diff, err := sunbeam.DiffManifests(s, from, to)
+	if err != nil {
+		return response.SmartError(err)
+	}
+
+	if format == "text" {
+		return response.ManualResponse(func(w http.ResponseWriter) error {
+			w.Header().Set("Content-Type", "text/plain")
+			w.WriteHeader(http.StatusOK)
+			_, err := w.Write([]byte(sunbeam.FormatManifestDiff(diff)))
+			return err
+		})
+	}
+
+	return response.SyncResponse(true, diff)
+}
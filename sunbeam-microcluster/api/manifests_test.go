@@ -0,0 +1,63 @@
+package api
+
+import (
+	"testing"
+)
+
+func TestAcceptsYAML(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   bool
+	}{
+		{"", false},
+		{"*/*", false},
+		{"application/json", false},
+		{"application/yaml", true},
+		{"application/yaml; charset=utf-8", true},
+		{"text/html, application/yaml", true},
+		{"application/json, application/yaml", false},
+		{"application/json;q=0.5, application/yaml", true},
+		{"application/json, application/yaml;q=0.5", false},
+		{"application/yaml;q=0", false},
+		{"application/yaml;q=invalid", false},
+	}
+
+	for _, tt := range tests {
+		got := acceptsYAML(tt.accept)
+		if got != tt.want {
+			t.Errorf("acceptsYAML(%q) = %v, want %v", tt.accept, got, tt.want)
+		}
+	}
+}
+
+func TestManifestDataToYAML(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want string
+	}{
+		{
+			name: "json",
+			data: `{"core": {"config": {"proxy": {"proxy_required": false}}}}`,
+			want: "core:\n    config:\n        proxy:\n            proxy_required: false\n",
+		},
+		{
+			name: "yaml passed through",
+			data: "core:\n  config: {}\n",
+			want: "core:\n  config: {}\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := manifestDataToYAML(tt.data)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if string(got) != tt.want {
+				t.Errorf("manifestDataToYAML(%q) = %q, want %q", tt.data, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,33 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/canonical/lxd/lxd/response"
+	"github.com/canonical/microcluster/rest"
+	"github.com/canonical/microcluster/state"
+
+	"github.com/canonical/snap-openstack/sunbeam-microcluster/access"
+	"github.com/canonical/snap-openstack/sunbeam-microcluster/sunbeam"
+)
+
+// /metrics endpoint.
+var metricsCmd = rest.Endpoint{
+	Path: "",
+
+	Get: access.ClusterCATrustedEndpoint(cmdMetricsGet, true),
+}
+
+// cmdMetricsGet returns the cluster operational statistics in the Prometheus text format
+func cmdMetricsGet(s *state.State, _ *http.Request) response.Response {
+	metrics, err := sunbeam.CollectMetrics(s.Context, s)
+	if err != nil {
+		return response.SmartError(err)
+	}
+
+	return response.ManualResponse(func(w http.ResponseWriter) error {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		w.WriteHeader(http.StatusOK)
+		return sunbeam.WriteMetrics(w, metrics)
+	})
+}
@@ -0,0 +1,35 @@
+package api
+
+import (
+	"net/http"
+	"net/url"
+
+	"github.com/canonical/lxd/lxd/response"
+	"github.com/canonical/microcluster/rest"
+	"github.com/canonical/microcluster/state"
+	"github.com/gorilla/mux"
+
+	"github.com/canonical/snap-openstack/sunbeam-microcluster/access"
+	"github.com/canonical/snap-openstack/sunbeam-microcluster/sunbeam"
+)
+
+// /1.0/nodes/<name>/health endpoint.
+var nodeHealthCmd = rest.Endpoint{
+	Path: "nodes/{name}/health",
+
+	Get: access.ClusterCATrustedEndpoint(cmdNodeHealthGet, true),
+}
+
+func cmdNodeHealthGet(s *state.State, r *http.Request) response.Response {
+	name, err := url.PathUnescape(mux.Vars(r)["name"])
+	if err != nil {
+		return response.InternalError(err)
+	}
+
+	health, err := sunbeam.CheckNodeHealth(s, name)
+	if err != nil {
+		return response.SmartError(err)
+	}
+
+	return response.SyncResponse(true, health)
+}
@@ -2,10 +2,14 @@ package api
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/url"
+	"strings"
+	"time"
 
 	"github.com/canonical/lxd/lxd/response"
+	"github.com/canonical/lxd/lxd/util"
 	"github.com/canonical/lxd/shared/api"
 	"github.com/canonical/microcluster/rest"
 	"github.com/canonical/microcluster/state"
@@ -24,6 +28,13 @@ var nodesCmd = rest.Endpoint{
 	Post: access.ClusterCATrustedEndpoint(cmdNodesPost, true),
 }
 
+// /1.0/nodes/system-ids endpoint.
+var nodeSystemIDsCmd = rest.Endpoint{
+	Path: "nodes/system-ids",
+
+	Put: access.ClusterCATrustedEndpoint(cmdNodeSystemIDsPut, true),
+}
+
 // /1.0/nodes/<name> endpoint.
 var nodeCmd = rest.Endpoint{
 	Path: "nodes/{name}",
@@ -33,10 +44,70 @@ var nodeCmd = rest.Endpoint{
 	Delete: access.ClusterCATrustedEndpoint(cmdNodesDelete, true),
 }
 
+// /1.0/nodes/<name>/labels endpoint.
+var nodeLabelsCmd = rest.Endpoint{
+	Path: "nodes/{name}/labels",
+
+	Get:    access.ClusterCATrustedEndpoint(cmdNodeLabelsGet, true),
+	Put:    access.ClusterCATrustedEndpoint(cmdNodeLabelsPut, true),
+	Delete: access.ClusterCATrustedEndpoint(cmdNodeLabelsDelete, true),
+}
+
+// /1.0/nodes/<name>/capacity endpoint.
+var nodeCapacityCmd = rest.Endpoint{
+	Path: "nodes/{name}/capacity",
+
+	Get: access.ClusterCATrustedEndpoint(cmdNodeCapacityGet, true),
+	Put: access.ClusterCATrustedEndpoint(cmdNodeCapacityPut, true),
+}
+
+// /1.0/nodes/<name>/maintenance endpoint.
+var nodeMaintenanceCmd = rest.Endpoint{
+	Path: "nodes/{name}/maintenance",
+
+	Put: access.ClusterCATrustedEndpoint(cmdNodeMaintenancePut, true),
+}
+
 func cmdNodesGetAll(s *state.State, r *http.Request) response.Response {
 	roles := r.URL.Query()["role"]
 
-	nodes, err := sunbeam.ListNodes(s, roles)
+	labels, err := parseLabelFilters(r.URL.Query()["label"])
+	if err != nil {
+		return response.BadRequest(err)
+	}
+
+	if r.URL.Query().Has("system_id_prefix") {
+		if len(roles) > 0 || len(labels) > 0 {
+			return response.BadRequest(fmt.Errorf("Filtering by role or label and system_id_prefix at the same time is not supported"))
+		}
+
+		nodes, err := sunbeam.GetNodesBySystemIDPrefix(s, r.URL.Query().Get("system_id_prefix"))
+		if err != nil {
+			return response.SmartError(err)
+		}
+
+		return response.SyncResponse(true, nodes)
+	}
+
+	if r.URL.Query().Has("stale_since") {
+		if len(roles) > 0 || len(labels) > 0 {
+			return response.BadRequest(fmt.Errorf("Filtering by role or label and stale_since at the same time is not supported"))
+		}
+
+		since, err := time.Parse(time.RFC3339, r.URL.Query().Get("stale_since"))
+		if err != nil {
+			return response.BadRequest(fmt.Errorf("Invalid stale_since, expected ISO8601 time: %w", err))
+		}
+
+		nodes, err := sunbeam.ListStaleNodes(s, since)
+		if err != nil {
+			return response.InternalError(err)
+		}
+
+		return response.SyncResponse(true, nodes)
+	}
+
+	nodes, err := sunbeam.ListNodes(s, roles, labels)
 	if err != nil {
 		return response.InternalError(err)
 	}
@@ -44,6 +115,21 @@ func cmdNodesGetAll(s *state.State, r *http.Request) response.Response {
 	return response.SyncResponse(true, nodes)
 }
 
+// parseLabelFilters parses label filters of the form key=value
+func parseLabelFilters(filters []string) (map[string]string, error) {
+	labels := map[string]string{}
+	for _, filter := range filters {
+		key, value, found := strings.Cut(filter, "=")
+		if !found || key == "" {
+			return nil, fmt.Errorf("Invalid label filter %q, expected key=value", filter)
+		}
+
+		labels[key] = value
+	}
+
+	return labels, nil
+}
+
 func cmdNodesGet(s *state.State, r *http.Request) response.Response {
 	var name string
 	name, err := url.PathUnescape(mux.Vars(r)["name"])
@@ -73,6 +159,9 @@ func cmdNodesPost(s *state.State, r *http.Request) response.Response {
 
 	err = sunbeam.AddNode(s, req.Name, req.Role, req.MachineID, req.SystemID)
 	if err != nil {
+		if api.StatusErrorCheck(err, http.StatusConflict) {
+			return response.Conflict(err)
+		}
 		return response.InternalError(err)
 	}
 
@@ -94,6 +183,9 @@ func cmdNodesPut(s *state.State, r *http.Request) response.Response {
 
 	err = sunbeam.UpdateNode(s, name, req.Role, req.MachineID, req.SystemID)
 	if err != nil {
+		if api.StatusErrorCheck(err, http.StatusConflict) {
+			return response.Conflict(err)
+		}
 		return response.InternalError(err)
 	}
 
@@ -112,3 +204,135 @@ func cmdNodesDelete(s *state.State, r *http.Request) response.Response {
 
 	return response.EmptySyncResponse
 }
+
+func cmdNodeLabelsGet(s *state.State, r *http.Request) response.Response {
+	name, err := url.PathUnescape(mux.Vars(r)["name"])
+	if err != nil {
+		return response.InternalError(err)
+	}
+
+	labels, err := sunbeam.GetNodeLabels(s, name)
+	if err != nil {
+		return response.SmartError(err)
+	}
+
+	return response.SyncResponse(true, labels)
+}
+
+func cmdNodeLabelsPut(s *state.State, r *http.Request) response.Response {
+	name, err := url.PathUnescape(mux.Vars(r)["name"])
+	if err != nil {
+		return response.InternalError(err)
+	}
+
+	labels := map[string]string{}
+	err = json.NewDecoder(r.Body).Decode(&labels)
+	if err != nil {
+		return response.BadRequest(err)
+	}
+
+	err = sunbeam.SetNodeLabels(s, name, labels)
+	if err != nil {
+		return response.SmartError(err)
+	}
+
+	return response.EmptySyncResponse
+}
+
+func cmdNodeLabelsDelete(s *state.State, r *http.Request) response.Response {
+	name, err := url.PathUnescape(mux.Vars(r)["name"])
+	if err != nil {
+		return response.InternalError(err)
+	}
+
+	key := r.URL.Query().Get("key")
+	if key == "" {
+		return response.BadRequest(fmt.Errorf("Missing label key"))
+	}
+
+	err = sunbeam.DeleteNodeLabel(s, name, key)
+	if err != nil {
+		return response.SmartError(err)
+	}
+
+	return response.EmptySyncResponse
+}
+
+func cmdNodeMaintenancePut(s *state.State, r *http.Request) response.Response {
+	name, err := url.PathUnescape(mux.Vars(r)["name"])
+	if err != nil {
+		return response.InternalError(err)
+	}
+
+	var req types.NodeMaintenance
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		return response.BadRequest(err)
+	}
+
+	err = sunbeam.SetNodeMaintenanceMode(s, name, req.Enabled)
+	if err != nil {
+		return response.SmartError(err)
+	}
+
+	return response.EmptySyncResponse
+}
+
+func cmdNodeCapacityGet(s *state.State, r *http.Request) response.Response {
+	name, err := url.PathUnescape(mux.Vars(r)["name"])
+	if err != nil {
+		return response.InternalError(err)
+	}
+
+	capacity, err := sunbeam.GetNodeCapacity(s, name)
+	if err != nil {
+		return response.SmartError(err)
+	}
+
+	return response.SyncResponse(true, capacity)
+}
+
+func cmdNodeCapacityPut(s *state.State, r *http.Request) response.Response {
+	name, err := url.PathUnescape(mux.Vars(r)["name"])
+	if err != nil {
+		return response.InternalError(err)
+	}
+
+	capacity := map[string]string{}
+	err = json.NewDecoder(r.Body).Decode(&capacity)
+	if err != nil {
+		return response.BadRequest(err)
+	}
+
+	err = sunbeam.UpdateNodeCapacity(s, name, capacity)
+	if err != nil {
+		return response.SmartError(err)
+	}
+
+	return response.EmptySyncResponse
+}
+
+func cmdNodeSystemIDsPut(s *state.State, r *http.Request) response.Response {
+	var req []types.NodeSystemIDUpdate
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		return response.BadRequest(err)
+	}
+
+	results, err := sunbeam.BulkUpdateNodeSystemIDs(s.Context, s, req)
+	if err != nil {
+		return response.SmartError(err)
+	}
+
+	return response.ManualResponse(func(w http.ResponseWriter) error {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusMultiStatus)
+		return util.WriteJSON(w, api.ResponseRaw{
+			Type:       api.SyncResponse,
+			Status:     http.StatusText(http.StatusMultiStatus),
+			StatusCode: http.StatusMultiStatus,
+			Metadata:   results,
+		}, nil)
+	})
+}
@@ -0,0 +1,62 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/canonical/lxd/lxd/response"
+	"github.com/canonical/microcluster/rest"
+	"github.com/canonical/microcluster/state"
+)
+
+// ReadOnlyMode makes every mutating endpoint return 503, set by sunbeamd --read-only.
+var ReadOnlyMode bool
+
+// ExtensionServers returns the API servers to run, with the mutating endpoints
+// guarded against writes if ReadOnlyMode is set.
+func ExtensionServers() []rest.Server {
+	if !ReadOnlyMode {
+		return Servers
+	}
+
+	servers := make([]rest.Server, len(Servers))
+	for i, server := range Servers {
+		resources := make([]rest.Resources, len(server.Resources))
+		for j, resource := range server.Resources {
+			endpoints := make([]rest.Endpoint, len(resource.Endpoints))
+			for k, endpoint := range resource.Endpoints {
+				endpoint.Put = readOnlyGuard(endpoint.Put)
+				endpoint.Post = readOnlyGuard(endpoint.Post)
+				endpoint.Patch = readOnlyGuard(endpoint.Patch)
+				endpoint.Delete = readOnlyGuard(endpoint.Delete)
+				endpoints[k] = endpoint
+			}
+
+			resource.Endpoints = endpoints
+			resources[j] = resource
+		}
+
+		server.Resources = resources
+		servers[i] = server
+	}
+
+	return servers
+}
+
+// readOnlyGuard wraps the handler of action so that it returns 503 in read-only mode.
+func readOnlyGuard(action rest.EndpointAction) rest.EndpointAction {
+	if action.Handler == nil {
+		return action
+	}
+
+	handler := action.Handler
+	action.Handler = func(s *state.State, r *http.Request) response.Response {
+		if ReadOnlyMode {
+			return response.Unavailable(fmt.Errorf("daemon is in read-only mode"))
+		}
+
+		return handler(s, r)
+	}
+
+	return action
+}
@@ -0,0 +1,112 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/canonical/lxd/lxd/response"
+	"github.com/canonical/microcluster/rest"
+	"github.com/canonical/microcluster/state"
+
+	"github.com/canonical/snap-openstack/sunbeam-microcluster/api/types"
+)
+
+// testHandler accepts every request.
+func testHandler(_ *state.State, _ *http.Request) response.Response {
+	return response.EmptySyncResponse
+}
+
+// renderStatus returns the status code action answers a request with.
+func renderStatus(t *testing.T, action rest.EndpointAction, method string) int {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	err := action.Handler(nil, httptest.NewRequest(method, "/1.0/test", nil)).Render(w)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return w.Code
+}
+
+func TestReadOnlyGuard(t *testing.T) {
+	t.Cleanup(func() { ReadOnlyMode = false })
+	action := readOnlyGuard(rest.EndpointAction{Handler: testHandler})
+
+	for _, readOnly := range []bool{false, true} {
+		ReadOnlyMode = readOnly
+
+		want := http.StatusOK
+		if readOnly {
+			want = http.StatusServiceUnavailable
+		}
+
+		for _, method := range []string{http.MethodPut, http.MethodPost} {
+			code := renderStatus(t, action, method)
+			if code != want {
+				t.Errorf("%s with read-only mode %v returned %d, want %d", method, readOnly, code, want)
+			}
+		}
+	}
+
+	if readOnlyGuard(rest.EndpointAction{}).Handler != nil {
+		t.Error("Guarding an action without handler added a handler")
+	}
+}
+
+func TestExtensionServersReadOnly(t *testing.T) {
+	servers := Servers
+	t.Cleanup(func() {
+		Servers = servers
+		ReadOnlyMode = false
+	})
+
+	action := rest.EndpointAction{Handler: testHandler}
+	Servers = []rest.Server{{
+		CoreAPI: true,
+		Resources: []rest.Resources{{
+			PathPrefix: types.ExtendedPathPrefix,
+			Endpoints: []rest.Endpoint{{
+				Path:   "test",
+				Get:    action,
+				Put:    action,
+				Post:   action,
+				Patch:  action,
+				Delete: action,
+			}},
+		}},
+	}}
+
+	ReadOnlyMode = true
+	endpoint := ExtensionServers()[0].Resources[0].Endpoints[0]
+
+	tests := []struct {
+		method string
+		action rest.EndpointAction
+		want   int
+	}{
+		{method: http.MethodGet, action: endpoint.Get, want: http.StatusOK},
+		{method: http.MethodPut, action: endpoint.Put, want: http.StatusServiceUnavailable},
+		{method: http.MethodPost, action: endpoint.Post, want: http.StatusServiceUnavailable},
+		{method: http.MethodPatch, action: endpoint.Patch, want: http.StatusServiceUnavailable},
+		{method: http.MethodDelete, action: endpoint.Delete, want: http.StatusServiceUnavailable},
+	}
+
+	for _, tt := range tests {
+		code := renderStatus(t, tt.action, tt.method)
+		if code != tt.want {
+			t.Errorf("%s in read-only mode returned %d, want %d", tt.method, code, tt.want)
+		}
+	}
+
+	if endpoint.Path != "test" {
+		t.Errorf("Rewritten endpoint has path %q, want test", endpoint.Path)
+	}
+
+	// The endpoints are guarded in a copy of Servers.
+	code := renderStatus(t, Servers[0].Resources[0].Endpoints[0].Put, http.MethodPut)
+	if code != http.StatusOK {
+		t.Errorf("PUT of Servers returned %d after the rewrite, want %d", code, http.StatusOK)
+	}
+}
@@ -1,6 +1,8 @@
 // Package api provides the REST API endpoints.
 package api
 
+//go:generate go run -tags generate ../cmd/sunbeamd -o ../docs/openapi.yaml
+
 import (
 	"github.com/canonical/microcluster/rest"
 	"github.com/canonical/snap-openstack/sunbeam-microcluster/api/types"
@@ -16,17 +18,53 @@ var Servers = []rest.Server{
 				PathPrefix: types.ExtendedPathPrefix,
 				Endpoints: []rest.Endpoint{
 					nodesCmd,
+					nodeSystemIDsCmd,
 					nodeCmd,
+					nodeHealthCmd,
+					nodeLabelsCmd,
+					nodeDecommissionCmd,
+					nodeCapacityCmd,
+					nodeMaintenanceCmd,
 					terraformStateListCmd,
 					terraformStateCmd,
+					terraformStateCopyCmd,
+					terraformStateVersionsCmd,
+					terraformStateRollbackCmd,
+					terraformWorkspacesCmd,
 					terraformLockListCmd,
 					terraformLockCmd,
+					terraformLockHeartbeatCmd,
 					terraformUnlockCmd,
 					jujuusersCmd,
+					jujuusersAuditCmd,
 					jujuuserCmd,
+					jujuuserRotateTokenCmd,
+					jujuuserAuditCmd,
+					jujuuserGroupsCmd,
+					jujuuserGroupCmd,
+					configsCmd,
+					configKeysCmd,
+					configBulkCmd,
+					configHistoryCmd,
+					configWatchCmd,
 					configCmd,
+					configDescriptionCmd,
+					configCompareAndSwapCmd,
+					configRevertCmd,
+					configKeyHistoryCmd,
+					configSchemaCmd,
+					configSchemaEntryCmd,
 					manifestsCmd,
+					manifestDiffCmd,
 					manifestCmd,
+					manifestStatusCmd,
+					manifestRetryCmd,
+					manifestRollbackCmd,
+					adminDBTableSizesCmd,
+					adminConfigNamespacePoliciesCmd,
+					adminConfigNamespacePolicyCmd,
+					statusCmd,
+					healthCmd,
 				},
 			},
 			{
@@ -35,6 +73,12 @@ var Servers = []rest.Server{
 					certPair,
 				},
 			},
+			{
+				PathPrefix: types.MetricsPathPrefix,
+				Endpoints: []rest.Endpoint{
+					metricsCmd,
+				},
+			},
 		},
 	},
 }
@@ -0,0 +1,25 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/canonical/lxd/lxd/response"
+	"github.com/canonical/microcluster/rest"
+	"github.com/canonical/microcluster/state"
+
+	"github.com/canonical/snap-openstack/sunbeam-microcluster/access"
+	"github.com/canonical/snap-openstack/sunbeam-microcluster/sunbeam"
+)
+
+// /1.0/status endpoint.
+var statusCmd = rest.Endpoint{
+	Path: "status",
+
+	Get: access.ClusterCATrustedEndpoint(cmdStatusGet, true),
+}
+
+// cmdStatusGet returns the aggregate cluster status. Failing checks are reported
+// in the status, so it is always returned successfully.
+func cmdStatusGet(s *state.State, _ *http.Request) response.Response {
+	return response.SyncResponse(true, sunbeam.GetStatus(s))
+}
@@ -3,8 +3,12 @@ package api
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
+	"io"
+	"net"
 	"net/http"
 	"net/url"
+	"strconv"
 
 	"github.com/canonical/lxd/lxd/response"
 	"github.com/canonical/lxd/lxd/util"
@@ -14,6 +18,7 @@ import (
 	"github.com/gorilla/mux"
 
 	"github.com/canonical/snap-openstack/sunbeam-microcluster/access"
+	"github.com/canonical/snap-openstack/sunbeam-microcluster/api/types"
 	"github.com/canonical/snap-openstack/sunbeam-microcluster/sunbeam"
 )
 
@@ -42,6 +47,34 @@ var terraformStateCmd = rest.Endpoint{
 	Delete: access.ClusterCATrustedEndpoint(cmdStateDelete, false),
 }
 
+// /1.0/terraformstate/{name}/copy endpoint.
+var terraformStateCopyCmd = rest.Endpoint{
+	Path: "terraformstate/{name}/copy",
+
+	Post: access.ClusterCATrustedEndpoint(cmdStateCopyPost, false),
+}
+
+// /1.0/terraformstate/{name}/versions endpoint.
+var terraformStateVersionsCmd = rest.Endpoint{
+	Path: "terraformstate/{name}/versions",
+
+	Get: access.ClusterCATrustedEndpoint(cmdStateVersionsGet, false),
+}
+
+// /1.0/terraformstate/{name}/rollback endpoint.
+var terraformStateRollbackCmd = rest.Endpoint{
+	Path: "terraformstate/{name}/rollback",
+
+	Post: access.ClusterCATrustedEndpoint(cmdStateRollbackPost, false),
+}
+
+// /1.0/terraform/workspaces endpoint.
+var terraformWorkspacesCmd = rest.Endpoint{
+	Path: "terraform/workspaces",
+
+	Get: access.ClusterCATrustedEndpoint(cmdWorkspacesList, false),
+}
+
 // /1.0/terraformlock endpoint.
 var terraformLockListCmd = rest.Endpoint{
 	Path: "terraformlock",
@@ -50,11 +83,23 @@ var terraformLockListCmd = rest.Endpoint{
 }
 
 // /1.0/terraformlock/{name} endpoint.
+// DELETE unlocks the state like PUT /1.0/terraformunlock/{name}. With
+// ?force=true it removes the lock without checking the lock ID, for when
+// the lock owner is gone, and records the caller in terraform_lock_audit.
 var terraformLockCmd = rest.Endpoint{
 	Path: "terraformlock/{name}",
 
-	Get: access.ClusterCATrustedEndpoint(cmdLockGet, false),
-	Put: access.ClusterCATrustedEndpoint(cmdLockPut, false),
+	Get:    access.ClusterCATrustedEndpoint(cmdLockGet, false),
+	Put:    access.ClusterCATrustedEndpoint(cmdLockPut, false),
+	Delete: access.ClusterCATrustedEndpoint(cmdLockDelete, false),
+}
+
+// /1.0/terraformlock/{name}/heartbeat endpoint.
+// POST renews a lock acquired with a TTL so long running applies keep it.
+var terraformLockHeartbeatCmd = rest.Endpoint{
+	Path: "terraformlock/{name}/heartbeat",
+
+	Post: access.ClusterCATrustedEndpoint(cmdLockHeartbeatPost, false),
 }
 
 // /1.0/terraformunlock/{name} endpoint.
@@ -64,25 +109,101 @@ var terraformUnlockCmd = rest.Endpoint{
 	Put: access.ClusterCATrustedEndpoint(cmdUnlockPut, false),
 }
 
-func cmdStateList(s *state.State, _ *http.Request) response.Response {
-	plans, err := sunbeam.GetTerraformStates(s)
+// cmdStateList lists the terraform state names. If page or page-size is given,
+// a page of names is returned along with pagination metadata.
+func cmdStateList(s *state.State, r *http.Request) response.Response {
+	query := r.URL.Query()
 
+	workspace := query.Get("workspace")
+	err := sunbeam.ValidateTerraformWorkspace(workspace)
 	if err != nil {
-		return response.InternalError(err)
+		return response.SmartError(err)
 	}
 
-	return response.SyncResponse(true, plans)
+	filter := sunbeam.TerraformStateFilter{
+		Workspace: workspace,
+		Prefix:    query.Get("prefix"),
+		Sort:      query.Get("sort"),
+	}
+
+	if !query.Has("page") && !query.Has("page-size") {
+		plans, _, err := sunbeam.ListTerraformStates(s, filter, 0, 0)
+		if err != nil {
+			return response.SmartError(err)
+		}
+
+		return response.SyncResponse(true, plans)
+	}
+
+	page, err := positiveQueryInt(query, "page", 1)
+	if err != nil {
+		return response.BadRequest(err)
+	}
+
+	pageSize, err := positiveQueryInt(query, "page-size", defaultStatePageSize)
+	if err != nil {
+		return response.BadRequest(err)
+	}
+
+	plans, total, err := sunbeam.ListTerraformStates(s, filter, (page-1)*pageSize, pageSize)
+	if err != nil {
+		return response.SmartError(err)
+	}
+
+	list := types.StateList{States: plans, Total: total, Page: page, PageSize: pageSize}
+	if page*pageSize < total {
+		next := page + 1
+		list.Next = &next
+	}
+
+	return response.SyncResponse(true, list)
 }
 
-func cmdStateGet(s *state.State, r *http.Request) response.Response {
-	var name string
+// defaultStatePageSize is the number of state names per page if page-size is not given
+const defaultStatePageSize = 100
 
-	name, err := url.PathUnescape(mux.Vars(r)["name"])
+// positiveQueryInt returns the positive integer query parameter key, or def if it is not set
+func positiveQueryInt(query url.Values, key string, def int) (int, error) {
+	value := query.Get(key)
+	if value == "" {
+		return def, nil
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil || n <= 0 {
+		return -1, fmt.Errorf("Invalid %s %q, expected a positive integer", key, value)
+	}
+
+	return n, nil
+}
+
+func cmdWorkspacesList(s *state.State, _ *http.Request) response.Response {
+	workspaces, err := sunbeam.ListTerraformWorkspaces(s)
 	if err != nil {
 		return response.InternalError(err)
 	}
 
-	state, err := sunbeam.GetTerraformState(s, name)
+	return response.SyncResponse(true, workspaces)
+}
+
+// terraformStateName returns the name of the state in the request path,
+// within the workspace given by the workspace query parameter if any.
+func terraformStateName(r *http.Request) (string, error) {
+	name, err := url.PathUnescape(mux.Vars(r)["name"])
+	if err != nil {
+		return "", err
+	}
+
+	return sunbeam.TerraformWorkspaceStateName(r.URL.Query().Get("workspace"), name)
+}
+
+func cmdStateGet(s *state.State, r *http.Request) response.Response {
+	name, err := terraformStateName(r)
+	if err != nil {
+		return response.SmartError(err)
+	}
+
+	state, checksum, err := sunbeam.GetTerraformStateWithChecksum(s, name)
 	if err != nil {
 		if err, ok := err.(api.StatusError); ok {
 			if err.Status() == http.StatusNotFound {
@@ -101,29 +222,36 @@ func cmdStateGet(s *state.State, r *http.Request) response.Response {
 	// Just send state data instead of SyncResponse Json object as
 	// terraform expects just state data.
 	return response.ManualResponse(func(w http.ResponseWriter) error {
+		w.Header().Set("X-Terraform-State-Checksum", checksum)
 		return util.WriteJSON(w, jsonState, nil)
 	})
 }
 
 func cmdStatePut(s *state.State, r *http.Request) response.Response {
-	var name string
-
-	name, err := url.PathUnescape(mux.Vars(r)["name"])
+	name, err := terraformStateName(r)
 	if err != nil {
-		return response.InternalError(err)
+		return response.SmartError(err)
 	}
 
 	lockID := r.URL.Query().Get("ID")
 
-	var body bytes.Buffer
-	_, err = body.ReadFrom(r.Body)
+	maxBytes, err := sunbeam.TerraformStateMaxBytes(s)
 	if err != nil {
 		return response.InternalError(err)
 	}
 
-	dbLock, err := sunbeam.UpdateTerraformState(s, name, lockID, body.String())
+	body, err := readTerraformState(r, maxBytes)
+	if err != nil {
+		return response.SmartError(err)
+	}
+
+	dbLock, err := sunbeam.UpdateTerraformState(s, name, lockID, r.Header.Get("X-Expected-Checksum"), body)
 	if err != nil {
 		if err, ok := err.(api.StatusError); ok {
+			if err.Status() == http.StatusPreconditionFailed {
+				return response.PreconditionFailed(err)
+			}
+
 			if err.Status() == http.StatusConflict {
 				jsonDBLock, err := json.Marshal(dbLock)
 				if err != nil {
@@ -142,12 +270,96 @@ func cmdStatePut(s *state.State, r *http.Request) response.Response {
 	return response.EmptySyncResponse
 }
 
-func cmdStateDelete(s *state.State, r *http.Request) response.Response {
-	var name string
+// readTerraformState returns the terraform state in the body of r,
+// or a 413 error if it is larger than maxBytes.
+func readTerraformState(r *http.Request, maxBytes int64) (string, error) {
+	if r.ContentLength > maxBytes {
+		return "", api.StatusErrorf(http.StatusRequestEntityTooLarge, "Terraform state is larger than %d bytes", maxBytes)
+	}
 
-	name, err := url.PathUnescape(mux.Vars(r)["name"])
+	// Read one byte past the limit to detect bodies without Content-Length that are too large
+	var body bytes.Buffer
+	_, err := body.ReadFrom(io.LimitReader(r.Body, maxBytes+1))
 	if err != nil {
-		return response.InternalError(err)
+		return "", err
+	}
+
+	if int64(body.Len()) > maxBytes {
+		return "", api.StatusErrorf(http.StatusRequestEntityTooLarge, "Terraform state is larger than %d bytes", maxBytes)
+	}
+
+	return body.String(), nil
+}
+
+func cmdStateCopyPost(s *state.State, r *http.Request) response.Response {
+	var req types.StateCopy
+
+	name, err := terraformStateName(r)
+	if err != nil {
+		return response.SmartError(err)
+	}
+
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		return response.BadRequest(err)
+	}
+
+	if req.Target == "" {
+		return response.BadRequest(fmt.Errorf("Copy target is required"))
+	}
+
+	target, err := sunbeam.TerraformWorkspaceStateName(r.URL.Query().Get("workspace"), req.Target)
+	if err != nil {
+		return response.SmartError(err)
+	}
+
+	err = sunbeam.CopyTerraformState(s, name, target)
+	if err != nil {
+		return response.SmartError(err)
+	}
+
+	return response.EmptySyncResponse
+}
+
+func cmdStateVersionsGet(s *state.State, r *http.Request) response.Response {
+	name, err := terraformStateName(r)
+	if err != nil {
+		return response.SmartError(err)
+	}
+
+	versions, err := sunbeam.GetTerraformStateVersions(s, name)
+	if err != nil {
+		return response.SmartError(err)
+	}
+
+	return response.SyncResponse(true, versions)
+}
+
+func cmdStateRollbackPost(s *state.State, r *http.Request) response.Response {
+	var req types.StateRollback
+
+	name, err := terraformStateName(r)
+	if err != nil {
+		return response.SmartError(err)
+	}
+
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		return response.BadRequest(err)
+	}
+
+	err = sunbeam.RollbackTerraformState(s, name, req.Version)
+	if err != nil {
+		return response.SmartError(err)
+	}
+
+	return response.EmptySyncResponse
+}
+
+func cmdStateDelete(s *state.State, r *http.Request) response.Response {
+	name, err := terraformStateName(r)
+	if err != nil {
+		return response.SmartError(err)
 	}
 
 	err = sunbeam.DeleteTerraformState(s, name)
@@ -174,11 +386,9 @@ func cmdLockList(s *state.State, _ *http.Request) response.Response {
 }
 
 func cmdLockGet(s *state.State, r *http.Request) response.Response {
-	var name string
-
-	name, err := url.PathUnescape(mux.Vars(r)["name"])
+	name, err := terraformStateName(r)
 	if err != nil {
-		return response.InternalError(err)
+		return response.SmartError(err)
 	}
 
 	lock, err := sunbeam.GetTerraformLock(s, name)
@@ -199,11 +409,9 @@ func cmdLockGet(s *state.State, r *http.Request) response.Response {
 }
 
 func cmdLockPut(s *state.State, r *http.Request) response.Response {
-	var name string
-
-	name, err := url.PathUnescape(mux.Vars(r)["name"])
+	name, err := terraformStateName(r)
 	if err != nil {
-		return response.InternalError(err)
+		return response.SmartError(err)
 	}
 
 	var body bytes.Buffer
@@ -237,12 +445,77 @@ func cmdLockPut(s *state.State, r *http.Request) response.Response {
 	return response.EmptySyncResponse
 }
 
+func cmdLockHeartbeatPost(s *state.State, r *http.Request) response.Response {
+	name, err := terraformStateName(r)
+	if err != nil {
+		return response.SmartError(err)
+	}
+
+	var req types.LockHeartbeat
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		return response.BadRequest(err)
+	}
+
+	if req.ID == "" {
+		return response.BadRequest(fmt.Errorf("Lock ID is required"))
+	}
+
+	lock, err := sunbeam.HeartbeatTerraformLock(s, name, req.ID)
+	if err != nil {
+		return response.SmartError(err)
+	}
+
+	return response.SyncResponse(true, lock)
+}
+
+// cmdLockDelete unlocks the state like cmdUnlockPut, the OpenTofu http
+// backend sends DELETE to the lock address to unlock, possibly without body.
+func cmdLockDelete(s *state.State, r *http.Request) response.Response {
+	if r.URL.Query().Get("force") == "true" {
+		return forceUnlockTerraformState(s, r)
+	}
+
+	return unlockTerraformState(s, r)
+}
+
+func forceUnlockTerraformState(s *state.State, r *http.Request) response.Response {
+	name, err := terraformStateName(r)
+	if err != nil {
+		return response.SmartError(err)
+	}
+
+	_, err = sunbeam.ForceDeleteTerraformLock(s, name, requestCaller(r))
+	if err != nil {
+		return response.SmartError(err)
+	}
+
+	return response.EmptySyncResponse
+}
+
+// requestCaller identifies the caller of r, by the common name of its client
+// certificate which is the host name for cluster members, or else by its address.
+func requestCaller(r *http.Request) string {
+	if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+		return r.TLS.PeerCertificates[0].Subject.CommonName
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
 func cmdUnlockPut(s *state.State, r *http.Request) response.Response {
-	var name string
+	return unlockTerraformState(s, r)
+}
 
-	name, err := url.PathUnescape(mux.Vars(r)["name"])
+func unlockTerraformState(s *state.State, r *http.Request) response.Response {
+	name, err := terraformStateName(r)
 	if err != nil {
-		return response.InternalError(err)
+		return response.SmartError(err)
 	}
 
 	var body bytes.Buffer
@@ -0,0 +1,94 @@
+package api
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/canonical/lxd/shared/api"
+)
+
+func TestPositiveQueryInt(t *testing.T) {
+	tests := []struct {
+		query   string
+		want    int
+		wantErr bool
+	}{
+		{query: "", want: 10},
+		{query: "limit=1", want: 1},
+		{query: "limit=200", want: 200},
+		{query: "limit=", want: 10},
+		{query: "limit=0", wantErr: true},
+		{query: "limit=-1", wantErr: true},
+		{query: "limit=abc", wantErr: true},
+		{query: "limit=1.5", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			query, err := url.ParseQuery(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := positiveQueryInt(query, "limit", 10)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("positiveQueryInt returned %d, want an error", got)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got != tt.want {
+				t.Errorf("positiveQueryInt returned %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadTerraformState(t *testing.T) {
+	tests := []struct {
+		name          string
+		body          string
+		contentLength int64
+		wantErr       bool
+	}{
+		{name: "within the limit", body: "0123456789", contentLength: 10},
+		{name: "within the limit without Content-Length", body: "0123456789", contentLength: -1},
+		{name: "oversized Content-Length", body: "0", contentLength: 11, wantErr: true},
+		{name: "oversized body without Content-Length", body: "0123456789A", contentLength: -1, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Hide the length of the body from NewRequest, it sets the Content-Length of a strings.Reader.
+			r := httptest.NewRequest(http.MethodPut, "/1.0/terraformstate/plan", io.MultiReader(strings.NewReader(tt.body)))
+			r.ContentLength = tt.contentLength
+
+			body, err := readTerraformState(r, 10)
+			if tt.wantErr {
+				if !api.StatusErrorCheck(err, http.StatusRequestEntityTooLarge) {
+					t.Errorf("Reading the state returned %v, want a 413 error", err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if body != tt.body {
+				t.Errorf("Read state %q, want %q", body, tt.body)
+			}
+		})
+	}
+}
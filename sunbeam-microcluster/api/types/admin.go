@@ -0,0 +1,8 @@
+// Package types provides shared types and structs.
+package types
+
+// TableStats holds the size statistics of a database table
+type TableStats struct {
+	RowCount       int   `json:"row-count" yaml:"row-count"`
+	EstimatedBytes int64 `json:"estimated-bytes" yaml:"estimated-bytes"`
+}
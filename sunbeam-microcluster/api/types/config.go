@@ -0,0 +1,86 @@
+// Package types provides shared types and structs.
+package types
+
+import (
+	"time"
+)
+
+// ConfigEntries holds list of ConfigEntry type
+type ConfigEntries []ConfigEntry
+
+// ConfigEntry structure to hold a config key, its value and description
+type ConfigEntry struct {
+	Key         string `json:"key" yaml:"key"`
+	Value       string `json:"value" yaml:"value"`
+	Description string `json:"description" yaml:"description"`
+}
+
+// ConfigDescription structure to hold the description of a config key
+type ConfigDescription struct {
+	Description string `json:"description" yaml:"description"`
+}
+
+// ConfigCompareAndSwap structure to hold the expected and new value of a config key
+type ConfigCompareAndSwap struct {
+	Expected string `json:"expected" yaml:"expected"`
+	New      string `json:"new" yaml:"new"`
+}
+
+// ConfigCompareAndSwapResult structure to hold whether a config value was swapped
+type ConfigCompareAndSwapResult struct {
+	Swapped bool `json:"swapped" yaml:"swapped"`
+}
+
+// ConfigNamespacePolicies holds list of ConfigNamespacePolicy type
+type ConfigNamespacePolicies []ConfigNamespacePolicy
+
+// ConfigNamespacePolicy structure to hold the lifecycle policy of a config namespace.
+// The namespace of a key is the part before its first dot.
+type ConfigNamespacePolicy struct {
+	Namespace         string `json:"namespace" yaml:"namespace"`
+	DefaultTTLSeconds *int64 `json:"default_ttl_seconds" yaml:"default_ttl_seconds"`
+	AllowUserTTL      bool   `json:"allow_user_ttl" yaml:"allow_user_ttl"`
+}
+
+// ConfigBulkResults holds list of ConfigBulkResult type
+type ConfigBulkResults []ConfigBulkResult
+
+// ConfigBulkResult structure to hold the outcome of writing one key of a bulk config update
+type ConfigBulkResult struct {
+	Key     string `json:"key" yaml:"key"`
+	Written bool   `json:"written" yaml:"written"`
+	Error   string `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+// ConfigHistory holds list of ConfigHistoryEntry type
+type ConfigHistory []ConfigHistoryEntry
+
+// ConfigHistoryEntry structure to hold a change of a config value.
+// A null old_value means the key was created and a null new_value that it was deleted.
+type ConfigHistoryEntry struct {
+	ID        int64     `json:"id" yaml:"id"`
+	Key       string    `json:"key" yaml:"key"`
+	OldValue  *string   `json:"old_value" yaml:"old_value"`
+	NewValue  *string   `json:"new_value" yaml:"new_value"`
+	ChangedBy string    `json:"changed_by" yaml:"changed_by"`
+	ChangedAt time.Time `json:"changed_at" yaml:"changed_at"`
+}
+
+// ConfigSchema holds list of ConfigSchemaEntry type
+type ConfigSchema []ConfigSchemaEntry
+
+// ConfigSchemaEntry structure to hold the declared type of the value of a config key.
+// Values must also match regex_constraint if it is not empty.
+type ConfigSchemaEntry struct {
+	Key             string `json:"key" yaml:"key"`
+	Type            string `json:"type" yaml:"type"`
+	RegexConstraint string `json:"regex_constraint,omitempty" yaml:"regex_constraint,omitempty"`
+}
+
+// ConfigEvent structure to hold a change of a config key sent to its watchers.
+// The value is empty for a deleted key.
+type ConfigEvent struct {
+	Key    string `json:"key" yaml:"key"`
+	Value  string `json:"value" yaml:"value"`
+	Action string `json:"action" yaml:"action"`
+}
@@ -9,4 +9,6 @@ const (
 	ExtendedPathPrefix types.EndpointPrefix = "1.0"
 	// LocalPathPrefix is the prefix for all local API paths.
 	LocalPathPrefix types.EndpointPrefix = "local"
+	// MetricsPathPrefix is the prefix of the Prometheus metrics endpoint.
+	MetricsPathPrefix types.EndpointPrefix = "metrics"
 )
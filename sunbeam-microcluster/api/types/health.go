@@ -0,0 +1,36 @@
+// Package types provides shared types and structs.
+package types
+
+// Health holds the cluster health, rolled up from the health of each component
+type Health struct {
+	Status     string                     `json:"status" yaml:"status"`
+	Components map[string]HealthComponent `json:"components" yaml:"components"`
+}
+
+// HealthComponent holds the health of a single component
+type HealthComponent struct {
+	Status         string                `json:"status" yaml:"status"`
+	TerraformLocks []TerraformLockHealth `json:"terraform_locks,omitempty" yaml:"terraform_locks,omitempty"`
+}
+
+// TerraformLockHealth holds the ownership of a terraform lock
+type TerraformLockHealth struct {
+	Name           string `json:"name" yaml:"name"`
+	HeldForSeconds int64  `json:"held_for_seconds" yaml:"held_for_seconds"`
+	Locker         string `json:"locker" yaml:"locker"`
+}
+
+// NodeHealth holds the health of a node, rolled up from the result of each check
+type NodeHealth struct {
+	Name     string            `json:"name" yaml:"name"`
+	Status   string            `json:"status" yaml:"status"`
+	LastSeen string            `json:"last_seen" yaml:"last_seen"`
+	Checks   []NodeHealthCheck `json:"checks" yaml:"checks"`
+}
+
+// NodeHealthCheck holds the result of a single node health check
+type NodeHealthCheck struct {
+	Name    string `json:"name" yaml:"name"`
+	Passed  bool   `json:"passed" yaml:"passed"`
+	Message string `json:"message,omitempty" yaml:"message,omitempty"`
+}
@@ -1,11 +1,37 @@
 // Package types provides shared types and structs.
 package types
 
+import (
+	"time"
+)
+
 // JujuUsers is list of JujuUser struct
 type JujuUsers []JujuUser
 
-// JujuUser structure to hold juju user registration tokens
+// JujuUser structure to hold juju user registration tokens.
+// The token is omitted when listing users.
 type JujuUser struct {
-	Username string `json:"username" yaml:"username"`
-	Token    string `json:"token" yaml:"token"`
+	Username  string     `json:"username" yaml:"username"`
+	Token     string     `json:"token,omitempty" yaml:"token,omitempty"`
+	CreatedAt time.Time  `json:"created_at" yaml:"created_at"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty" yaml:"expires_at,omitempty"`
+	IsExpired bool       `json:"is_expired" yaml:"is_expired"`
+	Groups    []string   `json:"groups,omitempty" yaml:"groups,omitempty"`
+}
+
+// JujuUserGroup structure to hold the group a juju user is added to
+type JujuUserGroup struct {
+	Group string `json:"group" yaml:"group"`
+}
+
+// JujuAudit holds list of JujuAuditEntry type
+type JujuAudit []JujuAuditEntry
+
+// JujuAuditEntry structure to hold a use of a juju user token or a mutation of a juju user
+type JujuAuditEntry struct {
+	Username   string    `json:"username" yaml:"username"`
+	Action     string    `json:"action" yaml:"action"`
+	SourceIP   string    `json:"source_ip" yaml:"source_ip"`
+	UserAgent  string    `json:"user_agent" yaml:"user_agent"`
+	OccurredAt time.Time `json:"occurred_at" yaml:"occurred_at"`
 }
@@ -9,4 +9,38 @@ type Manifest struct {
 	ManifestID  string `json:"manifestid" yaml:"manifestid"`
 	AppliedDate string `json:"applieddate" yaml:"applieddate"`
 	Data        string `json:"data" yaml:"data"`
+	// AppliedAt is AppliedDate formatted as RFC 3339
+	AppliedAt string `json:"applied_at" yaml:"applied_at"`
+	// SchemaVersion is the database schema version the manifest was written against, 0 if unknown
+	SchemaVersion int `json:"schema-version,omitempty" yaml:"schema-version,omitempty"`
+	// Status is the application status of the manifest: applied, failed or pending
+	Status string `json:"status,omitempty" yaml:"status,omitempty"`
+	// Error is the error the application of the manifest failed with
+	Error string `json:"error,omitempty" yaml:"error,omitempty"`
+	// RollbackData is the manifest data reverting the changes of Data, if known
+	RollbackData string `json:"rollback_data,omitempty" yaml:"rollback_data,omitempty"`
+	// Signature is the verified base64 Ed25519 signature the manifest was added with, if any
+	Signature string `json:"signature,omitempty" yaml:"signature,omitempty"`
+}
+
+// ManifestStatus structure to hold the application status of a manifest
+type ManifestStatus struct {
+	Status string `json:"status" yaml:"status"`
+	Error  string `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+// ManifestDiff structure to hold the differences between the data of two manifests
+type ManifestDiff struct {
+	From    string           `json:"from" yaml:"from"`
+	To      string           `json:"to" yaml:"to"`
+	Changes []ManifestChange `json:"changes" yaml:"changes"`
+}
+
+// ManifestChange structure to hold a value added, removed or changed between two manifests.
+// Path locates the value in the manifest data, as dot separated keys and [index] for list items.
+type ManifestChange struct {
+	Path string `json:"path" yaml:"path"`
+	Op   string `json:"op" yaml:"op"`
+	From any    `json:"from,omitempty" yaml:"from,omitempty"`
+	To   any    `json:"to,omitempty" yaml:"to,omitempty"`
 }
@@ -12,4 +12,47 @@ type Node struct {
 	MachineID int `json:"machineid" yaml:"machineid"`
 	// SystemID is the unique identifier for the node in machine provider
 	SystemID string `json:"systemid" yaml:"systemid"`
+	// LastSeenAt is the time of the last heartbeat of the node, empty if never seen
+	LastSeenAt string `json:"lastseenat" yaml:"lastseenat"`
+	// Labels are the free form key/value labels of the node
+	Labels map[string]string `json:"labels,omitempty" yaml:"labels,omitempty"`
+	// MaintenanceMode tells the node should not receive new workloads
+	MaintenanceMode bool `json:"maintenance_mode" yaml:"maintenance_mode"`
+}
+
+// NodeCapacityLastUpdated is the key of a NodeCapacity holding the time of the latest report
+const NodeCapacityLastUpdated = "last_updated"
+
+// NodeCapacity holds the capacity values reported by a node, such as cpu_cores,
+// memory_mb or disk_gb, along with the time of the latest report under last_updated
+type NodeCapacity map[string]string
+
+// NodeSystemIDUpdate assigns a machine provider system id to a node
+type NodeSystemIDUpdate struct {
+	Name     string `json:"name" yaml:"name"`
+	SystemID string `json:"system_id" yaml:"system_id"`
+}
+
+// NodeSystemIDResults holds list of NodeSystemIDResult type
+type NodeSystemIDResults []NodeSystemIDResult
+
+// NodeSystemIDResult structure to hold the outcome of one update of a bulk system id update
+type NodeSystemIDResult struct {
+	Name   string `json:"name" yaml:"name"`
+	Status string `json:"status" yaml:"status"`
+	Error  string `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+// NodeMaintenance holds the maintenance mode of a node
+type NodeMaintenance struct {
+	Enabled bool `json:"enabled" yaml:"enabled"`
+}
+
+// NodeDecommission lists what decommissioning a node deletes, or would delete on a dry run
+type NodeDecommission struct {
+	// Node is the deleted node, including its labels
+	Node Node `json:"node" yaml:"node"`
+	// ClusterMember is the name of the removed cluster member, empty if the node is not a cluster member
+	ClusterMember string `json:"cluster_member" yaml:"cluster_member"`
+	DryRun        bool   `json:"dry_run" yaml:"dry_run"`
 }
@@ -0,0 +1,16 @@
+// Package types provides shared types and structs.
+package types
+
+// Status holds the cluster status, rolled up from the result of each check
+type Status struct {
+	Overall            string        `json:"overall" yaml:"overall"`
+	Checks             []StatusCheck `json:"checks" yaml:"checks"`
+	ClusterMemberCount int           `json:"cluster_member_count" yaml:"cluster_member_count"`
+}
+
+// StatusCheck holds the result of a single cluster status check
+type StatusCheck struct {
+	Name    string `json:"name" yaml:"name"`
+	Status  string `json:"status" yaml:"status"`
+	Message string `json:"message,omitempty" yaml:"message,omitempty"`
+}
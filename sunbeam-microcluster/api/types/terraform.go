@@ -14,4 +14,43 @@ type Lock struct {
 	Version   string    `json:"Version" yaml:"Version"`
 	Created   time.Time `json:"Created" yaml:"Created"`
 	Path      string    `json:"Path" yaml:"Path"`
+
+	// TTL is the number of seconds after which the lock is released, 0 means never
+	TTL        int        `json:"TTL,omitempty" yaml:"TTL,omitempty"`
+	AcquiredAt *time.Time `json:"acquired_at,omitempty" yaml:"acquired_at,omitempty"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty" yaml:"expires_at,omitempty"`
+
+	// LastHeartbeatAt is when the lock holder last renewed the lock
+	LastHeartbeatAt *time.Time `json:"last_heartbeat_at,omitempty" yaml:"last_heartbeat_at,omitempty"`
+	HeartbeatCount  int        `json:"heartbeat_count,omitempty" yaml:"heartbeat_count,omitempty"`
+}
+
+// LockHeartbeat structure to hold the ID of the lock to renew
+type LockHeartbeat struct {
+	ID string `json:"ID" yaml:"ID"`
+}
+
+// StateCopy structure to hold the target of a terraform state copy
+type StateCopy struct {
+	Target string `json:"target" yaml:"target"`
+}
+
+// StateVersion structure to hold a version of a terraform state
+type StateVersion struct {
+	Version   int    `json:"version" yaml:"version"`
+	AppliedAt string `json:"applied_at" yaml:"applied_at"`
+}
+
+// StateRollback structure to hold the version a terraform state is rolled back to
+type StateRollback struct {
+	Version int `json:"version" yaml:"version"`
+}
+
+// StateList structure to hold a page of terraform state names
+type StateList struct {
+	States   []string `json:"states" yaml:"states"`
+	Total    int      `json:"total" yaml:"total"`
+	Page     int      `json:"page" yaml:"page"`
+	PageSize int      `json:"page-size" yaml:"page-size"`
+	Next     *int     `json:"next" yaml:"next"`
 }
@@ -0,0 +1,134 @@
+//go:build generate
+
+// Built with the generate tag, sunbeamd writes an OpenAPI spec of the
+// extension API instead of running the daemon.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"runtime"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/canonical/snap-openstack/sunbeam-microcluster/api"
+	"github.com/canonical/snap-openstack/sunbeam-microcluster/version"
+)
+
+// pathParamRegex matches the mux variables of an endpoint path.
+var pathParamRegex = regexp.MustCompile(`{([^}]+)}`)
+
+type openAPIParameter struct {
+	Name     string            `yaml:"name"`
+	In       string            `yaml:"in"`
+	Required bool              `yaml:"required"`
+	Schema   map[string]string `yaml:"schema"`
+}
+
+type openAPIOperation struct {
+	OperationID string                       `yaml:"operationId"`
+	Parameters  []openAPIParameter           `yaml:"parameters,omitempty"`
+	Responses   map[string]map[string]string `yaml:"responses"`
+}
+
+type openAPISpec struct {
+	OpenAPI string                                 `yaml:"openapi"`
+	Info    map[string]string                      `yaml:"info"`
+	Paths   map[string]map[string]openAPIOperation `yaml:"paths"`
+}
+
+// handlerName returns the unqualified function name of a handler.
+func handlerName(handler reflect.Value) string {
+	name := runtime.FuncForPC(handler.Pointer()).Name()
+	return name[strings.LastIndex(name, ".")+1:]
+}
+
+// generateOpenAPI builds the spec by reflecting on the endpoints in api.Servers.
+func generateOpenAPI() openAPISpec {
+	spec := openAPISpec{
+		OpenAPI: "3.0.3",
+		Info: map[string]string{
+			"title":   "sunbeamd",
+			"version": version.Version,
+		},
+		Paths: map[string]map[string]openAPIOperation{},
+	}
+
+	for _, server := range api.Servers {
+		for _, resources := range server.Resources {
+			for _, endpoint := range resources.Endpoints {
+				path := "/" + string(resources.PathPrefix)
+				if endpoint.Path != "" {
+					path += "/" + endpoint.Path
+				}
+
+				var params []openAPIParameter
+				for _, match := range pathParamRegex.FindAllStringSubmatch(endpoint.Path, -1) {
+					params = append(params, openAPIParameter{
+						Name:     match[1],
+						In:       "path",
+						Required: true,
+						Schema:   map[string]string{"type": "string"},
+					})
+				}
+
+				operations := map[string]openAPIOperation{}
+				value := reflect.ValueOf(endpoint)
+				for i := 0; i < value.NumField(); i++ {
+					action := value.Field(i)
+					if action.Kind() != reflect.Struct {
+						continue
+					}
+
+					handler := action.FieldByName("Handler")
+					if !handler.IsValid() || handler.Kind() != reflect.Func || handler.IsNil() {
+						continue
+					}
+
+					operations[strings.ToLower(value.Type().Field(i).Name)] = openAPIOperation{
+						OperationID: handlerName(handler),
+						Parameters:  params,
+						Responses: map[string]map[string]string{
+							"default": {"description": "Standard LXD style response"},
+						},
+					}
+				}
+
+				if len(operations) > 0 {
+					spec.Paths[path] = operations
+				}
+			}
+		}
+	}
+
+	return spec
+}
+
+func main() {
+	output := flag.String("o", "docs/openapi.yaml", "Path to write the OpenAPI spec to")
+	flag.Parse()
+
+	data, err := yaml.Marshal(generateOpenAPI())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to marshal OpenAPI spec: %v\n", err)
+		os.Exit(1)
+	}
+
+	err = os.MkdirAll(filepath.Dir(*output), 0755)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to create output directory: %v\n", err)
+		os.Exit(1)
+	}
+
+	err = os.WriteFile(*output, data, 0644)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to write OpenAPI spec: %v\n", err)
+		os.Exit(1)
+	}
+}
@@ -1,10 +1,18 @@
+//go:build !generate
+
 // Package sunbeamd provides the cluster daemon.
 package main
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
+	"io"
 	"math/rand"
 	"os"
+	"os/user"
+	"path/filepath"
+	"runtime"
 	"time"
 
 	"github.com/canonical/lxd/shared/logger"
@@ -15,6 +23,7 @@ import (
 
 	"github.com/canonical/snap-openstack/sunbeam-microcluster/api"
 	"github.com/canonical/snap-openstack/sunbeam-microcluster/database"
+	"github.com/canonical/snap-openstack/sunbeam-microcluster/sunbeam"
 	"github.com/canonical/snap-openstack/sunbeam-microcluster/version"
 )
 
@@ -29,6 +38,7 @@ type cmdGlobal struct {
 
 	flagHelp    bool
 	flagVersion bool
+	flagJSON    bool
 
 	flagLogDebug   bool
 	flagLogVerbose bool
@@ -41,18 +51,43 @@ func (c *cmdGlobal) Run(_ *cobra.Command, _ []string) error {
 	return logger.InitLogger("", "", c.flagLogVerbose, c.flagLogDebug, nil)
 }
 
+// versionInfo is the build information printed with --version --json.
+type versionInfo struct {
+	Version   string `json:"version"`
+	GitCommit string `json:"git_commit"`
+	BuildDate string `json:"build_date"`
+	GoVersion string `json:"go_version"`
+}
+
+// printVersion prints the version, as JSON build information if --json is set.
+func (c *cmdGlobal) printVersion(w io.Writer) error {
+	if !c.flagJSON {
+		_, err := fmt.Fprintln(w, version.Version)
+		return err
+	}
+
+	return json.NewEncoder(w).Encode(versionInfo{
+		Version:   version.Version,
+		GitCommit: version.GitCommit,
+		BuildDate: version.BuildDate,
+		GoVersion: runtime.Version(),
+	})
+}
+
 type cmdDaemon struct {
 	global *cmdGlobal
 
-	flagStateDir    string
-	flagSocketGroup string
+	flagStateDir               string
+	flagSocketGroup            string
+	flagSkipPreMigrationBackup bool
+	flagReadOnly               bool
 }
 
 func (c *cmdDaemon) Command() *cobra.Command {
+	// --version is handled in Run rather than by cobra so that it can be combined with --json.
 	cmd := &cobra.Command{
-		Use:     "sunbeamd",
-		Short:   "Cluster daemon for sunbeam",
-		Version: version.Version,
+		Use:   "sunbeamd",
+		Short: "Cluster daemon for sunbeam",
 	}
 
 	cmd.RunE = c.Run
@@ -60,8 +95,85 @@ func (c *cmdDaemon) Command() *cobra.Command {
 	return cmd
 }
 
+// ValidateSocketGroup checks that the group used for the socket ownership exists.
+// An empty group is accepted and means the process GID is used.
+func ValidateSocketGroup(group string) error {
+	if group == "" {
+		return nil
+	}
+
+	_, err := user.LookupGroup(group)
+	if err != nil {
+		return fmt.Errorf("Invalid --socket-group %q, create the group or choose an existing one: %w", group, err)
+	}
+
+	return nil
+}
+
+// ValidateStateDir checks that the state directory is writable, creating it if needed.
+// An empty dir is not validated so that MicroCluster can use its default.
+func ValidateStateDir(dir string) error {
+	if dir == "" {
+		return nil
+	}
+
+	err := os.MkdirAll(dir, 0711)
+	if err != nil {
+		return fmt.Errorf("state-dir is not writable: %s: %w", dir, err)
+	}
+
+	tmp, err := os.CreateTemp(dir, ".sunbeamd-write-check-")
+	if err != nil {
+		return fmt.Errorf("state-dir is not writable: %s: %w", dir, err)
+	}
+
+	path := tmp.Name()
+	defer func() { _ = os.Remove(path) }()
+
+	content := []byte("sunbeamd")
+	_, err = tmp.Write(content)
+	closeErr := tmp.Close()
+	if err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		return fmt.Errorf("state-dir is not writable: %s: %w", dir, err)
+	}
+
+	readBack, err := os.ReadFile(filepath.Clean(path))
+	if err != nil || string(readBack) != string(content) {
+		return fmt.Errorf("state-dir is not writable: %s: failed to read back test file", dir)
+	}
+
+	err = os.Remove(path)
+	if err != nil {
+		return fmt.Errorf("state-dir is not writable: %s: %w", dir, err)
+	}
+
+	return nil
+}
+
 func (c *cmdDaemon) Run(_ *cobra.Command, _ []string) error {
-	m, err := microcluster.App(microcluster.Args{StateDir: c.flagStateDir, SocketGroup: c.flagSocketGroup, Verbose: c.global.flagLogVerbose, Debug: c.global.flagLogDebug, ExtensionServers: api.Servers})
+	if c.global.flagVersion {
+		return c.global.printVersion(os.Stdout)
+	}
+
+	err := ValidateSocketGroup(c.flagSocketGroup)
+	if err != nil {
+		return err
+	}
+
+	err = ValidateStateDir(c.flagStateDir)
+	if err != nil {
+		return err
+	}
+
+	database.StateDir = c.flagStateDir
+	database.SkipPreMigrationBackup = c.flagSkipPreMigrationBackup
+	api.ReadOnlyMode = c.flagReadOnly
+
+	m, err := microcluster.App(microcluster.Args{StateDir: c.flagStateDir, SocketGroup: c.flagSocketGroup, Verbose: c.global.flagLogVerbose, Debug: c.global.flagLogDebug, ExtensionServers: api.ExtensionServers()})
 	if err != nil {
 		return err
 	}
@@ -76,24 +188,38 @@ func (c *cmdDaemon) Run(_ *cobra.Command, _ []string) error {
 		},
 
 		// PostBootstrap is run after the daemon is initialized and bootstrapped.
-		PostBootstrap: func(_ *state.State, _ map[string]string) error {
+		// Reserved config keys in the init config are seeded with an admin override.
+		PostBootstrap: func(s *state.State, initConfig map[string]string) error {
 			logger.Info("This is a hook that runs after the daemon is initialized and bootstrapped")
 
-			return nil
+			return sunbeam.SeedConfig(s, sunbeam.ReservedConfig(initConfig))
 		},
 
 		// OnStart is run after the daemon is started.
-		OnStart: func(_ *state.State) error {
+		OnStart: func(s *state.State) error {
 			logger.Info("This is a hook that runs after the daemon first starts")
 
+			if !api.ReadOnlyMode {
+				go releaseExpiredTerraformLocks(s)
+				go pruneJujuTokenHistory(s)
+			}
+
 			return nil
 		},
 
 		// PostJoin is run after the daemon is initialized and joins a cluster.
-		PostJoin: func(_ *state.State, _ map[string]string) error {
+		// The roles of the joining node are taken from the join config. The node
+		// is already a cluster member when this runs, so missing or invalid roles
+		// leave it without a nodes row: clients validate the roles before joining.
+		PostJoin: func(s *state.State, initConfig map[string]string) error {
 			logger.Info("This is a hook that runs after the daemon is initialized and joins an existing cluster, after OnNewMember runs on all peers")
 
-			return nil
+			roles, err := sunbeam.ParseNodeRoles(initConfig[sunbeam.NodeRoleConfigKey])
+			if err != nil {
+				return fmt.Errorf("Failed to join cluster: %w", err)
+			}
+
+			return sunbeam.RegisterJoinedNode(s, roles)
 		},
 
 		// PreJoin is run after the daemon is initialized and joins a cluster.
@@ -118,9 +244,48 @@ func (c *cmdDaemon) Run(_ *cobra.Command, _ []string) error {
 		},
 
 		// OnHeartbeat is run after a successful heartbeat round.
-		OnHeartbeat: func(_ *state.State) error {
+		OnHeartbeat: func(s *state.State) error {
 			logger.Info("This is a hook that is run on the dqlite leader after a successful heartbeat")
 
+			if api.ReadOnlyMode {
+				return nil
+			}
+
+			err := sunbeam.UpdateNodesLastSeen(s)
+			if err != nil {
+				logger.Warnf("Failed to update nodes last seen time: %v", err)
+			}
+
+			deleted, err := sunbeam.DeleteExpiredConfig(s)
+			if err != nil {
+				logger.Warnf("Failed to delete expired config keys: %v", err)
+			} else if deleted > 0 {
+				logger.Debugf("Deleted %d expired config keys", deleted)
+			}
+
+			pruned, err := sunbeam.PruneConfigHistory(s)
+			if err != nil {
+				logger.Warnf("Failed to prune config history: %v", err)
+			} else if pruned > 0 {
+				logger.Infof("Pruned %d config history entries", pruned)
+			}
+
+			pruned, err = sunbeam.PruneJujuAudit(s)
+			if err != nil {
+				logger.Warnf("Failed to prune juju audit log: %v", err)
+			} else if pruned > 0 {
+				logger.Infof("Pruned %d juju audit log entries", pruned)
+			}
+
+			staleCapacity, err := sunbeam.ListNodesWithStaleCapacity(s, time.Now().Add(-nodeCapacityStaleAfter))
+			if err != nil {
+				logger.Warnf("Failed to check node capacity reports: %v", err)
+			}
+
+			for _, name := range staleCapacity {
+				logger.Warnf("Node %q has not reported its capacity for more than %s", name, nodeCapacityStaleAfter)
+			}
+
 			return nil
 		},
 
@@ -128,6 +293,16 @@ func (c *cmdDaemon) Run(_ *cobra.Command, _ []string) error {
 		OnNewMember: func(s *state.State) error {
 			logger.Infof("This is a hook that is run on peer %q when a new cluster member has joined", s.Name())
 
+			nodes, err := sunbeam.ListNodesInMaintenance(s.Context, s)
+			if err != nil {
+				logger.Warnf("Failed to list nodes in maintenance mode: %v", err)
+				return nil
+			}
+
+			for _, node := range nodes {
+				logger.Warnf("Node %q is in maintenance mode and should not receive new workloads", node.Name)
+			}
+
 			return nil
 		},
 	}
@@ -135,6 +310,59 @@ func (c *cmdDaemon) Run(_ *cobra.Command, _ []string) error {
 	return m.Start(context.Background(), database.SchemaExtensions, nil, h)
 }
 
+// jujuTokenHistoryPruneInterval is how often the juju token history is pruned.
+const jujuTokenHistoryPruneInterval = time.Hour
+
+// pruneJujuTokenHistory deletes the juju tokens rotated more than the grace period ago
+// every jujuTokenHistoryPruneInterval until the daemon context is done.
+func pruneJujuTokenHistory(s *state.State) {
+	ticker := time.NewTicker(jujuTokenHistoryPruneInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.Context.Done():
+			return
+		case <-ticker.C:
+			deleted, err := sunbeam.PruneJujuTokenHistory(s)
+			if err != nil {
+				logger.Warnf("Failed to prune juju token history: %v", err)
+			} else if deleted > 0 {
+				logger.Infof("Pruned %d rotated juju tokens", deleted)
+			}
+		}
+	}
+}
+
+// nodeCapacityStaleAfter is how long after its latest capacity report a node is warned about.
+const nodeCapacityStaleAfter = 5 * time.Minute
+
+// expiredTerraformLockInterval is how often terraform locks past their TTL are released.
+const expiredTerraformLockInterval = time.Minute
+
+// releaseExpiredTerraformLocks releases the terraform locks past their TTL
+// every expiredTerraformLockInterval until the daemon context is done.
+func releaseExpiredTerraformLocks(s *state.State) {
+	ticker := time.NewTicker(expiredTerraformLockInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.Context.Done():
+			return
+		case <-ticker.C:
+			released, err := sunbeam.ReleaseExpiredTerraformLocks(s)
+			if err != nil {
+				logger.Warnf("Failed to release expired terraform locks: %v", err)
+			}
+
+			for _, name := range released {
+				logger.Infof("Released expired terraform lock %q", name)
+			}
+		}
+	}
+}
+
 func init() {
 	rand.New(rand.NewSource(time.Now().UnixNano()))
 }
@@ -147,13 +375,14 @@ func main() {
 
 	app.PersistentFlags().BoolVarP(&daemonCmd.global.flagHelp, "help", "h", false, "Print help")
 	app.PersistentFlags().BoolVar(&daemonCmd.global.flagVersion, "version", false, "Print version number")
+	app.PersistentFlags().BoolVar(&daemonCmd.global.flagJSON, "json", false, "Print version information as JSON, used with --version")
 	app.PersistentFlags().BoolVarP(&daemonCmd.global.flagLogDebug, "debug", "d", false, "Show all debug messages")
 	app.PersistentFlags().BoolVarP(&daemonCmd.global.flagLogVerbose, "verbose", "v", false, "Show all information messages")
 
 	app.PersistentFlags().StringVar(&daemonCmd.flagStateDir, "state-dir", "", "Path to store state information"+"``")
 	app.PersistentFlags().StringVar(&daemonCmd.flagSocketGroup, "socket-group", "", "Group to set socket's group ownership to")
-
-	app.SetVersionTemplate("{{.Version}}\n")
+	app.PersistentFlags().BoolVar(&daemonCmd.flagSkipPreMigrationBackup, "skip-pre-migration-backup", false, "Do not backup the database before applying schema extensions")
+	app.PersistentFlags().BoolVar(&daemonCmd.flagReadOnly, "read-only", false, "Reject all API writes with 503, for backup nodes")
 
 	err := app.Execute()
 	if err != nil {
@@ -0,0 +1,159 @@
+//go:build !generate
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"os/user"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/canonical/snap-openstack/sunbeam-microcluster/version"
+)
+
+func TestValidateSocketGroup(t *testing.T) {
+	group, err := user.LookupGroupId(strconv.Itoa(os.Getgid()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		group   string
+		wantErr bool
+	}{
+		{name: "empty group", group: "", wantErr: false},
+		{name: "existing group", group: group.Name, wantErr: false},
+		{name: "missing group", group: "sunbeamd-missing-group", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateSocketGroup(tt.group)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateSocketGroup(%q) returned %v, want error %v", tt.group, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateStateDir(t *testing.T) {
+	t.Run("empty dir", func(t *testing.T) {
+		err := ValidateStateDir("")
+		if err != nil {
+			t.Errorf("Empty state dir returned %v", err)
+		}
+	})
+
+	t.Run("missing dir", func(t *testing.T) {
+		dir := filepath.Join(t.TempDir(), "state")
+
+		err := ValidateStateDir(dir)
+		if err != nil {
+			t.Fatalf("Missing state dir returned %v", err)
+		}
+
+		info, err := os.Stat(dir)
+		if err != nil || !info.IsDir() {
+			t.Errorf("Missing state dir was not created: %v", err)
+		}
+	})
+
+	t.Run("writable dir", func(t *testing.T) {
+		dir := t.TempDir()
+
+		err := ValidateStateDir(dir)
+		if err != nil {
+			t.Fatalf("Writable state dir returned %v", err)
+		}
+
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(entries) != 0 {
+			t.Errorf("Validation left %d files in the state dir", len(entries))
+		}
+	})
+
+	t.Run("read-only dir", func(t *testing.T) {
+		if os.Geteuid() == 0 {
+			t.Skip("Directory permissions do not apply to root")
+		}
+
+		dir := t.TempDir()
+		err := os.Chmod(dir, 0500)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		t.Cleanup(func() { _ = os.Chmod(dir, 0700) })
+
+		err = ValidateStateDir(dir)
+		if err == nil || !strings.HasPrefix(err.Error(), "state-dir is not writable: "+dir) {
+			t.Errorf("Read-only state dir returned %v, want a not writable error", err)
+		}
+	})
+
+	t.Run("uncreatable dir", func(t *testing.T) {
+		file := filepath.Join(t.TempDir(), "file")
+		err := os.WriteFile(file, nil, 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		dir := filepath.Join(file, "state")
+
+		err = ValidateStateDir(dir)
+		if err == nil || !strings.HasPrefix(err.Error(), "state-dir is not writable: "+dir) {
+			t.Errorf("State dir under a file returned %v, want a not writable error", err)
+		}
+	})
+}
+
+func TestPrintVersionJSON(t *testing.T) {
+	global := &cmdGlobal{flagVersion: true, flagJSON: true}
+
+	var out bytes.Buffer
+	err := global.printVersion(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var info versionInfo
+	err = json.Unmarshal(out.Bytes(), &info)
+	if err != nil {
+		t.Fatalf("Version output %q is not JSON: %v", out.String(), err)
+	}
+
+	want := versionInfo{
+		Version:   version.Version,
+		GitCommit: version.GitCommit,
+		BuildDate: version.BuildDate,
+		GoVersion: runtime.Version(),
+	}
+
+	if info != want {
+		t.Errorf("Version output is %+v, want %+v", info, want)
+	}
+}
+
+func TestPrintVersion(t *testing.T) {
+	global := &cmdGlobal{flagVersion: true}
+
+	var out bytes.Buffer
+	err := global.printVersion(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if out.String() != version.Version+"\n" {
+		t.Errorf("Version output is %q, want %q", out.String(), version.Version+"\n")
+	}
+}
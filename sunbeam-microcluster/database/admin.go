@@ -0,0 +1,56 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/canonical/lxd/lxd/db/query"
+)
+
+// TableSize holds the row count and the estimated size of the text columns of a table.
+type TableSize struct {
+	RowCount       int
+	EstimatedBytes int64
+}
+
+// GetTableSizes returns the size statistics of all the tables in the database.
+func GetTableSizes(ctx context.Context, tx *sql.Tx) (map[string]TableSize, error) {
+	tables, err := query.SelectStrings(ctx, tx, `SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%' ORDER BY name`)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to fetch table names: %w", err)
+	}
+
+	sizes := make(map[string]TableSize, len(tables))
+	for _, table := range tables {
+		size := TableSize{}
+
+		err = tx.QueryRowContext(ctx, fmt.Sprintf(`SELECT COUNT(*) FROM %q`, table)).Scan(&size.RowCount)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to count rows of %q table: %w", table, err)
+		}
+
+		columns, err := query.SelectStrings(ctx, tx, `SELECT name FROM pragma_table_info(?) WHERE type = 'TEXT'`, table)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to fetch columns of %q table: %w", table, err)
+		}
+
+		if len(columns) > 0 {
+			lengths := make([]string, len(columns))
+			for i, column := range columns {
+				lengths[i] = fmt.Sprintf("IFNULL(SUM(length(%q)), 0)", column)
+			}
+
+			stmt := fmt.Sprintf(`SELECT %s FROM %q`, strings.Join(lengths, " + "), table)
+			err = tx.QueryRowContext(ctx, stmt).Scan(&size.EstimatedBytes)
+			if err != nil {
+				return nil, fmt.Errorf("Failed to estimate size of %q table: %w", table, err)
+			}
+		}
+
+		sizes[table] = size
+	}
+
+	return sizes, nil
+}
@@ -4,8 +4,12 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"net/http"
+	"strings"
+	"time"
 
 	"github.com/canonical/lxd/lxd/db/query"
+	"github.com/canonical/lxd/shared/api"
 )
 
 //go:generate -command mapper lxd-generate db mapper -t config.mapper.go
@@ -39,15 +43,319 @@ type ConfigItemFilter struct {
 	Key *string
 }
 
+// ConfigEntry is a ConfigItem along with its optional description.
+type ConfigEntry struct {
+	Key         string
+	Value       string
+	Description string
+}
+
+// GetConfigEntries returns the ConfigItems with their description,
+// except the ones whose key starts with one of excludePrefixes.
+func GetConfigEntries(ctx context.Context, tx *sql.Tx, excludePrefixes ...string) ([]ConfigEntry, error) {
+	return getConfigEntries(ctx, tx, nil, nil, excludePrefixes)
+}
+
+// GetConfigEntriesModifiedSince returns the ConfigItems with their description modified at or after since,
+// except the ones whose key starts with one of excludePrefixes.
+// ConfigItems last modified before their modification time was recorded are not part of the result.
+func GetConfigEntriesModifiedSince(ctx context.Context, tx *sql.Tx, since time.Time, excludePrefixes ...string) ([]ConfigEntry, error) {
+	return getConfigEntries(ctx, tx, []string{`config.updated_at >= ?`}, []any{since.UTC().Format(time.DateTime)}, excludePrefixes)
+}
+
+// GetConfigEntriesWithPrefix returns the ConfigItems with their description whose key starts with prefix,
+// except the ones whose key starts with one of excludePrefixes.
+func GetConfigEntriesWithPrefix(ctx context.Context, tx *sql.Tx, prefix string, excludePrefixes ...string) ([]ConfigEntry, error) {
+	return getConfigEntries(ctx, tx, []string{`config.key LIKE ? ESCAPE '\'`}, []any{likeEscaper.Replace(prefix) + "%"}, excludePrefixes)
+}
+
+// getConfigEntries returns the ConfigItems with their description matching all the conditions,
+// except the ones whose key starts with one of excludePrefixes.
+func getConfigEntries(ctx context.Context, tx *sql.Tx, conditions []string, args []any, excludePrefixes []string) ([]ConfigEntry, error) {
+	for _, prefix := range excludePrefixes {
+		conditions = append(conditions, `config.key NOT LIKE ? ESCAPE '\'`)
+		args = append(args, likeEscaper.Replace(prefix)+"%")
+	}
+
+	where := ""
+	if len(conditions) > 0 {
+		where = `WHERE ` + strings.Join(conditions, ` AND `)
+	}
+
+	stmt := `SELECT config.key, config.value, IFNULL(config.description, '') FROM config ` + where + ` ORDER BY config.key`
+
+	entries := make([]ConfigEntry, 0)
+
+	dest := func(scan func(dest ...any) error) error {
+		e := ConfigEntry{}
+		err := scan(&e.Key, &e.Value, &e.Description)
+		if err != nil {
+			return err
+		}
+
+		entries = append(entries, e)
+
+		return nil
+	}
+
+	err := query.Scan(ctx, tx, stmt, dest, args...)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to fetch from \"config\" table: %w", err)
+	}
+
+	return entries, nil
+}
+
+// MarkConfigItemModified records that the value of the ConfigItem with the given key changed now.
+// The checksum of the previous value is cleared.
+func MarkConfigItemModified(ctx context.Context, tx *sql.Tx, key string) error {
+	_, err := tx.ExecContext(ctx, `UPDATE config SET updated_at = CURRENT_TIMESTAMP, checksum = NULL WHERE key = ?`, key)
+	if err != nil {
+		return fmt.Errorf("Update \"config\" updated_at failed: %w", err)
+	}
+
+	return nil
+}
+
+// SetConfigItemChecksum sets the checksum of the value of the ConfigItem with the given key.
+func SetConfigItemChecksum(ctx context.Context, tx *sql.Tx, key string, checksum string) error {
+	_, err := tx.ExecContext(ctx, `UPDATE config SET checksum = ? WHERE key = ?`, checksum, key)
+	if err != nil {
+		return fmt.Errorf("Update \"config\" checksum failed: %w", err)
+	}
+
+	return nil
+}
+
+// GetConfigItemChecksum returns the checksum of the value of the ConfigItem with the given key
+// and whether one is stored.
+func GetConfigItemChecksum(ctx context.Context, tx *sql.Tx, key string) (string, bool, error) {
+	var checksum sql.NullString
+
+	err := tx.QueryRowContext(ctx, `SELECT checksum FROM config WHERE key = ?`, key).Scan(&checksum)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", false, api.StatusErrorf(http.StatusNotFound, "ConfigItem not found")
+		}
+
+		return "", false, fmt.Errorf("Failed to fetch from \"config\" table: %w", err)
+	}
+
+	return checksum.String, checksum.Valid, nil
+}
+
+// UpdateConfigItemDescription sets the description of the ConfigItem with the given key.
+func UpdateConfigItemDescription(ctx context.Context, tx *sql.Tx, key string, description string) error {
+	result, err := tx.ExecContext(ctx, `UPDATE config SET description = ? WHERE key = ?`, description, key)
+	if err != nil {
+		return fmt.Errorf("Update \"config\" description failed: %w", err)
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("Fetch affected rows: %w", err)
+	}
+
+	if n == 0 {
+		return api.StatusErrorf(http.StatusNotFound, "ConfigItem not found")
+	}
+
+	return nil
+}
+
+// SetConfigItemExpiry sets the time after which the ConfigItem with the given key expires.
+// A nil expiresAt clears the expiry.
+func SetConfigItemExpiry(ctx context.Context, tx *sql.Tx, key string, expiresAt *time.Time) error {
+	var expiry any
+	if expiresAt != nil {
+		// Stored in the CURRENT_TIMESTAMP format so that it can be compared with it.
+		expiry = expiresAt.UTC().Format(time.DateTime)
+	}
+
+	_, err := tx.ExecContext(ctx, `UPDATE config SET expires_at = ? WHERE key = ?`, expiry, key)
+	if err != nil {
+		return fmt.Errorf("Update \"config\" expiry failed: %w", err)
+	}
+
+	return nil
+}
+
+// GetConfigItemExpiry returns the time the ConfigItem with the given key expires at, or nil if it never expires.
+func GetConfigItemExpiry(ctx context.Context, tx *sql.Tx, key string) (*time.Time, error) {
+	// The TIMESTAMP column is decoded to a time by the driver.
+	var expiry sql.NullTime
+
+	err := tx.QueryRowContext(ctx, `SELECT expires_at FROM config WHERE key = ?`, key).Scan(&expiry)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, api.StatusErrorf(http.StatusNotFound, "ConfigItem not found")
+		}
+
+		return nil, fmt.Errorf("Failed to fetch from \"config\" table: %w", err)
+	}
+
+	if !expiry.Valid {
+		return nil, nil
+	}
+
+	return &expiry.Time, nil
+}
+
+// ConfigItemExpired returns whether the ConfigItem with the given key exists and has expired.
+func ConfigItemExpired(ctx context.Context, tx *sql.Tx, key string) (bool, error) {
+	count, err := query.Count(ctx, tx, "config", "key = ? AND expires_at IS NOT NULL AND expires_at < CURRENT_TIMESTAMP", key)
+	if err != nil {
+		return false, fmt.Errorf("Failed to fetch from \"config\" table: %w", err)
+	}
+
+	return count > 0, nil
+}
+
+// DeleteExpiredConfigItems deletes all the expired ConfigItems and returns their keys.
+func DeleteExpiredConfigItems(ctx context.Context, tx *sql.Tx) ([]string, error) {
+	keys, err := query.SelectStrings(ctx, tx, `SELECT key FROM config WHERE expires_at IS NOT NULL AND expires_at < CURRENT_TIMESTAMP ORDER BY key`)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to fetch from \"config\" table: %w", err)
+	}
+
+	if len(keys) == 0 {
+		return keys, nil
+	}
+
+	args := make([]any, 0, len(keys))
+	for _, key := range keys {
+		args = append(args, key)
+	}
+
+	// The keys read are deleted rather than the rows expired now, so that all the deleted keys are returned.
+	_, err = tx.ExecContext(ctx, `DELETE FROM config WHERE key IN `+query.Params(len(keys)), args...)
+	if err != nil {
+		return nil, fmt.Errorf("Delete expired \"config\" entries failed: %w", err)
+	}
+
+	return keys, nil
+}
+
+// GetConfigItemsWithPrefix returns the ConfigItems whose key starts with prefix.
+func GetConfigItemsWithPrefix(ctx context.Context, tx *sql.Tx, prefix string) ([]ConfigItem, error) {
+	stmt := `SELECT config.id, config.key, config.value FROM config WHERE config.key LIKE ? ESCAPE '\' ORDER BY config.key`
+
+	items := make([]ConfigItem, 0)
+
+	dest := func(scan func(dest ...any) error) error {
+		i := ConfigItem{}
+		err := scan(&i.ID, &i.Key, &i.Value)
+		if err != nil {
+			return err
+		}
+
+		items = append(items, i)
+
+		return nil
+	}
+
+	err := query.Scan(ctx, tx, stmt, dest, likeEscaper.Replace(prefix)+"%")
+	if err != nil {
+		return nil, fmt.Errorf("Failed to fetch from \"config\" table: %w", err)
+	}
+
+	return items, nil
+}
+
+// CountConfigItems returns the number of ConfigItems whose key starts with prefix, matched literally.
+func CountConfigItems(ctx context.Context, tx *sql.Tx, prefix string) (int, error) {
+	count, err := query.Count(ctx, tx, "config", `key LIKE ? ESCAPE '\'`, likeEscaper.Replace(prefix)+"%")
+	if err != nil {
+		return -1, fmt.Errorf("Failed to count \"config\" entries: %w", err)
+	}
+
+	return count, nil
+}
+
+// ConfigKeyFilter selects the ConfigItem keys returned by GetConfigItemKeysPage.
+type ConfigKeyFilter struct {
+	// Prefix the keys start with, matched literally.
+	Prefix string
+	// ExcludeAfter excludes the keys containing it after the first ExcludeFrom characters, if not empty.
+	ExcludeAfter string
+	ExcludeFrom  int
+}
+
+// likeEscaper escapes the LIKE wildcards, used with ESCAPE '\'.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// GetConfigItemKeysPage returns the ConfigItem keys matching filter sorted by the given ORDER BY expression,
+// skipping the first offset keys and returning at most limit keys if limit is positive.
+// The total number of keys matching filter is returned along with them.
+func GetConfigItemKeysPage(ctx context.Context, tx *sql.Tx, filter ConfigKeyFilter, orderBy string, offset int, limit int) ([]string, int, error) {
+	where := `config.key LIKE ? ESCAPE '\'`
+	args := []any{likeEscaper.Replace(filter.Prefix) + "%"}
+
+	if filter.ExcludeAfter != "" {
+		where += ` AND instr(substr(config.key, ?), ?) = 0`
+		args = append(args, filter.ExcludeFrom+1, filter.ExcludeAfter)
+	}
+
+	total, err := query.Count(ctx, tx, "config", where, args...)
+	if err != nil {
+		return nil, -1, fmt.Errorf("Failed to count \"config\" entries: %w", err)
+	}
+
+	stmt := `SELECT config.key FROM config WHERE ` + where
+	if orderBy != "" {
+		stmt += ` ORDER BY ` + orderBy
+	}
+
+	if limit > 0 {
+		stmt += ` LIMIT ? OFFSET ?`
+		args = append(args, limit, offset)
+	}
+
+	keys, err := query.SelectStrings(ctx, tx, stmt, args...)
+	if err != nil {
+		return nil, -1, fmt.Errorf("Failed to fetch from \"config\" table: %w", err)
+	}
+
+	return keys, total, nil
+}
+
+// GetConfigKeyPrefixes returns the distinct parts of the ConfigItem keys starting with prefix
+// that are between prefix and the first separator after it. Keys without separator are ignored.
+func GetConfigKeyPrefixes(ctx context.Context, tx *sql.Tx, prefix string, separator string) ([]string, error) {
+	stmt := `
+SELECT DISTINCT substr(rest, 1, instr(rest, ?) - 1) AS segment
+  FROM (SELECT substr(config.key, ?) AS rest FROM config WHERE config.key LIKE ? ESCAPE '\')
+  WHERE instr(rest, ?) > 0
+  ORDER BY segment
+`
+
+	prefixes, err := query.SelectStrings(ctx, tx, stmt, separator, len(prefix)+1, likeEscaper.Replace(prefix)+"%", separator)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to fetch from \"config\" table: %w", err)
+	}
+
+	return prefixes, nil
+}
+
 // GetConfigItemKeys returns the list of ConfigItem keys from the database, filtered by prefix if provided.
 func GetConfigItemKeys(ctx context.Context, tx *sql.Tx, prefix *string) ([]string, error) {
+	return GetConfigItemKeysOrdered(ctx, tx, prefix, "")
+}
+
+// GetConfigItemKeysOrdered returns the list of ConfigItem keys from the database, filtered by prefix if provided
+// and sorted by the given ORDER BY expression if not empty.
+func GetConfigItemKeysOrdered(ctx context.Context, tx *sql.Tx, prefix *string, orderBy string) ([]string, error) {
 	stmt := `SELECT config.key FROM config`
 
 	args := make([]any, 0)
 
 	if prefix != nil {
-		stmt += ` WHERE config.key LIKE ?`
-		args = append(args, *prefix+"%")
+		stmt += ` WHERE config.key LIKE ? ESCAPE '\'`
+		args = append(args, likeEscaper.Replace(*prefix)+"%")
+	}
+
+	if orderBy != "" {
+		stmt += ` ORDER BY ` + orderBy
 	}
 
 	configs := make([]string, 0)
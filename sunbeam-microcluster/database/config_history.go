@@ -0,0 +1,138 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/canonical/lxd/lxd/db/query"
+	"github.com/canonical/lxd/shared/api"
+)
+
+// ConfigHistoryEntry is a change of the value of a ConfigItem.
+// A nil OldValue means the key was created and a nil NewValue that it was deleted.
+type ConfigHistoryEntry struct {
+	ID        int64
+	Key       string
+	OldValue  *string
+	NewValue  *string
+	ChangedBy string
+	ChangedAt string
+}
+
+// ConfigHistoryFilter selects the ConfigHistoryEntries returned by GetConfigHistory.
+type ConfigHistoryFilter struct {
+	// Key of the changes, all keys if nil.
+	Key *string
+	// Since excludes the changes made before it, if not nil.
+	Since *time.Time
+	// Limit is the maximum number of changes returned, unlimited if not positive.
+	Limit int
+}
+
+// CreateConfigHistoryEntry records a change of the value of a ConfigItem.
+func CreateConfigHistoryEntry(ctx context.Context, tx *sql.Tx, entry ConfigHistoryEntry) error {
+	_, err := tx.ExecContext(ctx, `INSERT INTO config_history (key, old_value, new_value, changed_by) VALUES (?, ?, ?, ?)`,
+		entry.Key, entry.OldValue, entry.NewValue, entry.ChangedBy)
+	if err != nil {
+		return fmt.Errorf("Failed to create \"config_history\" entry: %w", err)
+	}
+
+	return nil
+}
+
+// GetConfigHistory returns the ConfigHistoryEntries matching filter, most recent first.
+func GetConfigHistory(ctx context.Context, tx *sql.Tx, filter ConfigHistoryFilter) ([]ConfigHistoryEntry, error) {
+	stmt := `SELECT id, key, old_value, new_value, changed_by, changed_at FROM config_history`
+
+	var where []string
+	args := make([]any, 0)
+
+	if filter.Key != nil {
+		where = append(where, `key = ?`)
+		args = append(args, *filter.Key)
+	}
+
+	if filter.Since != nil {
+		where = append(where, `changed_at >= ?`)
+		args = append(args, filter.Since.UTC().Format(time.DateTime))
+	}
+
+	if len(where) > 0 {
+		stmt += ` WHERE ` + strings.Join(where, ` AND `)
+	}
+
+	stmt += ` ORDER BY changed_at DESC, id DESC`
+
+	if filter.Limit > 0 {
+		stmt += ` LIMIT ?`
+		args = append(args, filter.Limit)
+	}
+
+	return getConfigHistoryEntries(ctx, tx, stmt, args...)
+}
+
+// GetConfigHistoryEntry returns the ConfigHistoryEntry with the given ID.
+func GetConfigHistoryEntry(ctx context.Context, tx *sql.Tx, id int64) (*ConfigHistoryEntry, error) {
+	entries, err := getConfigHistoryEntries(ctx, tx, `SELECT id, key, old_value, new_value, changed_by, changed_at FROM config_history WHERE id = ?`, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(entries) == 0 {
+		return nil, api.StatusErrorf(http.StatusNotFound, "ConfigHistoryEntry not found")
+	}
+
+	return &entries[0], nil
+}
+
+// getConfigHistoryEntries returns the ConfigHistoryEntries selected by stmt with args.
+func getConfigHistoryEntries(ctx context.Context, tx *sql.Tx, stmt string, args ...any) ([]ConfigHistoryEntry, error) {
+	entries := make([]ConfigHistoryEntry, 0)
+
+	dest := func(scan func(dest ...any) error) error {
+		var oldValue, newValue sql.NullString
+		e := ConfigHistoryEntry{}
+		err := scan(&e.ID, &e.Key, &oldValue, &newValue, &e.ChangedBy, &e.ChangedAt)
+		if err != nil {
+			return err
+		}
+
+		if oldValue.Valid {
+			e.OldValue = &oldValue.String
+		}
+
+		if newValue.Valid {
+			e.NewValue = &newValue.String
+		}
+
+		entries = append(entries, e)
+
+		return nil
+	}
+
+	err := query.Scan(ctx, tx, stmt, dest, args...)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to fetch from \"config_history\" table: %w", err)
+	}
+
+	return entries, nil
+}
+
+// DeleteConfigHistoryBefore deletes the ConfigHistoryEntries older than before and returns how many were deleted.
+func DeleteConfigHistoryBefore(ctx context.Context, tx *sql.Tx, before time.Time) (int64, error) {
+	result, err := tx.ExecContext(ctx, `DELETE FROM config_history WHERE changed_at < ?`, before.UTC().Format(time.DateTime))
+	if err != nil {
+		return -1, fmt.Errorf("Delete \"config_history\" entries failed: %w", err)
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return -1, fmt.Errorf("Fetch affected rows: %w", err)
+	}
+
+	return n, nil
+}
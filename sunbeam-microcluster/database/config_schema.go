@@ -0,0 +1,89 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+
+	"github.com/canonical/lxd/lxd/db/query"
+	"github.com/canonical/lxd/shared/api"
+)
+
+// ConfigSchemaEntry is the declared type of the value of a config key.
+type ConfigSchemaEntry struct {
+	Key             string
+	Type            string
+	RegexConstraint string
+}
+
+// GetConfigSchemaEntries returns all the ConfigSchemaEntries.
+func GetConfigSchemaEntries(ctx context.Context, tx *sql.Tx) ([]ConfigSchemaEntry, error) {
+	return getConfigSchemaEntries(ctx, tx, "")
+}
+
+// GetConfigSchemaEntry returns the ConfigSchemaEntry of the given key.
+func GetConfigSchemaEntry(ctx context.Context, tx *sql.Tx, key string) (*ConfigSchemaEntry, error) {
+	entries, err := getConfigSchemaEntries(ctx, tx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(entries) == 0 {
+		return nil, api.StatusErrorf(http.StatusNotFound, "ConfigSchemaEntry not found")
+	}
+
+	return &entries[0], nil
+}
+
+// getConfigSchemaEntries returns the ConfigSchemaEntries, only the one of key if not empty.
+func getConfigSchemaEntries(ctx context.Context, tx *sql.Tx, key string) ([]ConfigSchemaEntry, error) {
+	stmt := `SELECT key, type, IFNULL(regex_constraint, '') FROM config_schema`
+
+	args := make([]any, 0)
+	if key != "" {
+		stmt += ` WHERE key = ?`
+		args = append(args, key)
+	}
+
+	stmt += ` ORDER BY key`
+
+	entries := make([]ConfigSchemaEntry, 0)
+
+	dest := func(scan func(dest ...any) error) error {
+		e := ConfigSchemaEntry{}
+		err := scan(&e.Key, &e.Type, &e.RegexConstraint)
+		if err != nil {
+			return err
+		}
+
+		entries = append(entries, e)
+
+		return nil
+	}
+
+	err := query.Scan(ctx, tx, stmt, dest, args...)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to fetch from \"config_schema\" table: %w", err)
+	}
+
+	return entries, nil
+}
+
+// SetConfigSchemaEntry creates the ConfigSchemaEntry of its key or replaces the existing one.
+func SetConfigSchemaEntry(ctx context.Context, tx *sql.Tx, entry ConfigSchemaEntry) error {
+	var regexConstraint *string
+	if entry.RegexConstraint != "" {
+		regexConstraint = &entry.RegexConstraint
+	}
+
+	_, err := tx.ExecContext(ctx, `
+INSERT INTO config_schema (key, type, regex_constraint) VALUES (?, ?, ?)
+  ON CONFLICT(key) DO UPDATE SET type = excluded.type, regex_constraint = excluded.regex_constraint`,
+		entry.Key, entry.Type, regexConstraint)
+	if err != nil {
+		return fmt.Errorf("Failed to set \"config_schema\" entry: %w", err)
+	}
+
+	return nil
+}
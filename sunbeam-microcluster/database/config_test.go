@@ -0,0 +1,194 @@
+package database
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestConfigItemExpiry(t *testing.T) {
+	tx := NewTestSchemaTx(t)
+	ctx := context.Background()
+
+	_, err := tx.Exec(`INSERT INTO config (key, value) VALUES ('key', 'value')`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expiresAt, err := GetConfigItemExpiry(ctx, tx, "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if expiresAt != nil {
+		t.Errorf("Expiry of a config item without expiry is %v, want nil", expiresAt)
+	}
+
+	want := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	err = SetConfigItemExpiry(ctx, tx, "key", &want)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expiresAt, err = GetConfigItemExpiry(ctx, tx, "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if expiresAt == nil || !expiresAt.Equal(want) {
+		t.Errorf("Expiry is %v, want %v", expiresAt, want)
+	}
+
+	expired, err := ConfigItemExpired(ctx, tx, "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if expired {
+		t.Error("Config item expiring in an hour is expired")
+	}
+}
+
+func TestGetConfigEntriesExcludePrefixes(t *testing.T) {
+	tx := NewTestSchemaTx(t)
+
+	for _, key := range []string{"a", "tfstate-plan", "tflock-plan", "tfstateXplan"} {
+		_, err := tx.Exec(`INSERT INTO config (key, value) VALUES (?, 'value')`, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	entries, err := GetConfigEntries(context.Background(), tx, "tfstate-", "tflock-")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		keys = append(keys, entry.Key)
+	}
+
+	want := []string{"a", "tfstateXplan"}
+	if !reflect.DeepEqual(keys, want) {
+		t.Errorf("GetConfigEntries returned %v, want %v", keys, want)
+	}
+
+	entries, err = GetConfigEntriesWithPrefix(context.Background(), tx, "tf", "tfstate-", "tflock-")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(entries) != 1 || entries[0].Key != "tfstateXplan" {
+		t.Errorf("GetConfigEntriesWithPrefix returned %v, want only tfstateXplan", entries)
+	}
+}
+
+func TestDeleteExpiredConfigItems(t *testing.T) {
+	tx := NewTestSchemaTx(t)
+	ctx := context.Background()
+
+	past := time.Now().Add(-time.Hour)
+	future := time.Now().Add(time.Hour)
+	expiries := map[string]*time.Time{"expired-a": &past, "expired-b": &past, "live": &future, "permanent": nil}
+
+	for key, expiresAt := range expiries {
+		_, err := tx.Exec(`INSERT INTO config (key, value) VALUES (?, 'value')`, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = SetConfigItemExpiry(ctx, tx, key, expiresAt)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	deleted, err := DeleteExpiredConfigItems(ctx, tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"expired-a", "expired-b"}
+	if !reflect.DeepEqual(deleted, want) {
+		t.Errorf("DeleteExpiredConfigItems returned %v, want %v", deleted, want)
+	}
+
+	entries, err := GetConfigEntries(ctx, tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(entries) != 2 || entries[0].Key != "live" || entries[1].Key != "permanent" {
+		t.Errorf("Remaining config items are %v, want live and permanent", entries)
+	}
+
+	deleted, err = DeleteExpiredConfigItems(ctx, tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(deleted) != 0 {
+		t.Errorf("Second DeleteExpiredConfigItems returned %v, want none", deleted)
+	}
+}
+
+func TestConfigPrefixWildcards(t *testing.T) {
+	tx := NewTestSchemaTx(t)
+	ctx := context.Background()
+
+	for _, key := range []string{"a_b.x", "axb.y", "50%.z", "500.w", `a\b.v`} {
+		_, err := tx.Exec(`INSERT INTO config (key, value) VALUES (?, 'value')`, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		prefix string
+		want   []string
+	}{
+		{"a_b", []string{"a_b.x"}},
+		{"50%", []string{"50%.z"}},
+		{`a\b`, []string{`a\b.v`}},
+		{"a", []string{`a\b.v`, "a_b.x", "axb.y"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.prefix, func(t *testing.T) {
+			items, err := GetConfigItemsWithPrefix(ctx, tx, tt.prefix)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			keys := make([]string, 0, len(items))
+			for _, item := range items {
+				keys = append(keys, item.Key)
+			}
+
+			if !reflect.DeepEqual(keys, tt.want) {
+				t.Errorf("GetConfigItemsWithPrefix(%q) returned %v, want %v", tt.prefix, keys, tt.want)
+			}
+
+			prefix := tt.prefix
+			keys, err = GetConfigItemKeysOrdered(ctx, tx, &prefix, "config.key")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(keys, tt.want) {
+				t.Errorf("GetConfigItemKeysOrdered(%q) returned %v, want %v", tt.prefix, keys, tt.want)
+			}
+		})
+	}
+
+	segments, err := GetConfigKeyPrefixes(ctx, tx, "a_", ".")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(segments, []string{"b"}) {
+		t.Errorf("GetConfigKeyPrefixes(%q) returned %v, want [b]", "a_", segments)
+	}
+}
@@ -0,0 +1,113 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/canonical/lxd/lxd/db/query"
+)
+
+// JujuAuditEntry is a use of a JujuUser token or a mutation of a JujuUser.
+type JujuAuditEntry struct {
+	Username   string
+	Action     string
+	SourceIP   string
+	UserAgent  string
+	OccurredAt string
+}
+
+// JujuAuditFilter selects the JujuAuditEntries returned by GetJujuAudit.
+type JujuAuditFilter struct {
+	// Username of the entries, all users if nil.
+	Username *string
+	// Action of the entries, all actions if nil.
+	Action *string
+	// Since excludes the entries older than it, if not nil.
+	Since *time.Time
+	// Limit is the maximum number of entries returned, unlimited if not positive.
+	Limit int
+}
+
+// CreateJujuAuditEntry records a use of a JujuUser token or a mutation of a JujuUser.
+func CreateJujuAuditEntry(ctx context.Context, tx *sql.Tx, entry JujuAuditEntry) error {
+	_, err := tx.ExecContext(ctx, `INSERT INTO juju_audit (username, action, source_ip, user_agent) VALUES (?, ?, ?, ?)`,
+		entry.Username, entry.Action, entry.SourceIP, entry.UserAgent)
+	if err != nil {
+		return fmt.Errorf("Failed to create \"juju_audit\" entry: %w", err)
+	}
+
+	return nil
+}
+
+// GetJujuAudit returns the JujuAuditEntries matching filter, most recent first.
+func GetJujuAudit(ctx context.Context, tx *sql.Tx, filter JujuAuditFilter) ([]JujuAuditEntry, error) {
+	stmt := `SELECT username, action, source_ip, user_agent, occurred_at FROM juju_audit`
+
+	var where []string
+	args := make([]any, 0)
+
+	if filter.Username != nil {
+		where = append(where, `username = ?`)
+		args = append(args, *filter.Username)
+	}
+
+	if filter.Action != nil {
+		where = append(where, `action = ?`)
+		args = append(args, *filter.Action)
+	}
+
+	if filter.Since != nil {
+		where = append(where, `occurred_at >= ?`)
+		args = append(args, filter.Since.UTC().Format(time.DateTime))
+	}
+
+	if len(where) > 0 {
+		stmt += ` WHERE ` + strings.Join(where, ` AND `)
+	}
+
+	stmt += ` ORDER BY occurred_at DESC, id DESC`
+
+	if filter.Limit > 0 {
+		stmt += ` LIMIT ?`
+		args = append(args, filter.Limit)
+	}
+
+	entries := make([]JujuAuditEntry, 0)
+
+	dest := func(scan func(dest ...any) error) error {
+		e := JujuAuditEntry{}
+		err := scan(&e.Username, &e.Action, &e.SourceIP, &e.UserAgent, &e.OccurredAt)
+		if err != nil {
+			return err
+		}
+
+		entries = append(entries, e)
+
+		return nil
+	}
+
+	err := query.Scan(ctx, tx, stmt, dest, args...)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to fetch from \"juju_audit\" table: %w", err)
+	}
+
+	return entries, nil
+}
+
+// DeleteJujuAuditBefore deletes the JujuAuditEntries older than before and returns how many were deleted.
+func DeleteJujuAuditBefore(ctx context.Context, tx *sql.Tx, before time.Time) (int64, error) {
+	result, err := tx.ExecContext(ctx, `DELETE FROM juju_audit WHERE occurred_at < ?`, before.UTC().Format(time.DateTime))
+	if err != nil {
+		return -1, fmt.Errorf("Delete \"juju_audit\" entries failed: %w", err)
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return -1, fmt.Errorf("Fetch affected rows: %w", err)
+	}
+
+	return n, nil
+}
@@ -0,0 +1,98 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/canonical/lxd/lxd/db/query"
+	"github.com/canonical/lxd/shared/api"
+)
+
+// GetJujuUserGroupPermissions returns the permissions of each group the JujuUser with the given
+// username is a member of, keyed by group name. Permissions are the HTTP methods allowed, "*" for all.
+func GetJujuUserGroupPermissions(ctx context.Context, tx *sql.Tx, username string) (map[string][]string, error) {
+	stmt := `
+SELECT juju_groups.group_name, juju_groups.permissions FROM juju_user_groups
+  JOIN juju_groups ON juju_user_groups.group_name = juju_groups.group_name
+  WHERE juju_user_groups.username = ?
+`
+
+	permissions := map[string][]string{}
+
+	dest := func(scan func(dest ...any) error) error {
+		var group, value string
+		err := scan(&group, &value)
+		if err != nil {
+			return err
+		}
+
+		var methods []string
+		err = json.Unmarshal([]byte(value), &methods)
+		if err != nil {
+			return fmt.Errorf("Invalid permissions of juju group %q: %w", group, err)
+		}
+
+		permissions[group] = methods
+
+		return nil
+	}
+
+	err := query.Scan(ctx, tx, stmt, dest, username)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to fetch from \"juju_user_groups\" table: %w", err)
+	}
+
+	return permissions, nil
+}
+
+// AddJujuUserToGroup makes the JujuUser with the given username a member of group.
+// Adding a user to a group it is already a member of does nothing.
+func AddJujuUserToGroup(ctx context.Context, tx *sql.Tx, username string, group string) error {
+	count, err := query.Count(ctx, tx, "juju_groups", "group_name = ?", group)
+	if err != nil {
+		return fmt.Errorf("Failed to fetch from \"juju_groups\" table: %w", err)
+	}
+
+	if count == 0 {
+		return api.StatusErrorf(http.StatusNotFound, "JujuGroup not found")
+	}
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO juju_user_groups (username, group_name) VALUES (?, ?) ON CONFLICT(username, group_name) DO NOTHING`, username, group)
+	if err != nil {
+		return fmt.Errorf("Failed to create \"juju_user_groups\" entry: %w", err)
+	}
+
+	return nil
+}
+
+// RemoveJujuUserFromGroup removes the JujuUser with the given username from group.
+func RemoveJujuUserFromGroup(ctx context.Context, tx *sql.Tx, username string, group string) error {
+	result, err := tx.ExecContext(ctx, `DELETE FROM juju_user_groups WHERE username = ? AND group_name = ?`, username, group)
+	if err != nil {
+		return fmt.Errorf("Delete \"juju_user_groups\" entry failed: %w", err)
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("Fetch affected rows: %w", err)
+	}
+
+	if n == 0 {
+		return api.StatusErrorf(http.StatusNotFound, "JujuUserGroup not found")
+	}
+
+	return nil
+}
+
+// DeleteJujuUserGroups removes the JujuUser with the given username from all its groups.
+func DeleteJujuUserGroups(ctx context.Context, tx *sql.Tx, username string) error {
+	_, err := tx.ExecContext(ctx, `DELETE FROM juju_user_groups WHERE username = ?`, username)
+	if err != nil {
+		return fmt.Errorf("Delete \"juju_user_groups\" entries failed: %w", err)
+	}
+
+	return nil
+}
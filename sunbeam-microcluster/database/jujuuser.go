@@ -1,5 +1,14 @@
 package database
 
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/canonical/lxd/lxd/db/query"
+)
+
 //go:generate -command mapper lxd-generate db mapper -t jujuuser.mapper.go
 //go:generate mapper reset
 //
@@ -19,13 +28,54 @@ package database
 //go:generate mapper method -i -d github.com/canonical/microcluster/cluster -e JujuUser Update table=jujuuser
 
 // JujuUser is used to track User and registration token information.
+// CreatedAt is unset for users created before the creation time was recorded,
+// ExpiresAt for users whose token never expires.
 type JujuUser struct {
-	ID       int
-	Username string `db:"primary=yes"`
-	Token    string
+	ID        int
+	Username  string `db:"primary=yes"`
+	Token     string
+	CreatedAt sql.NullTime
+	ExpiresAt sql.NullTime
 }
 
 // JujuUserFilter is a required struct for use with lxd-generate. It is used for filtering fields on database fetches.
 type JujuUserFilter struct {
 	Username *string
 }
+
+// CreateJujuTokenHistoryEntry records that token of the JujuUser with the given username was revoked now.
+func CreateJujuTokenHistoryEntry(ctx context.Context, tx *sql.Tx, username string, token string) error {
+	_, err := tx.ExecContext(ctx, `INSERT INTO juju_token_history (username, token) VALUES (?, ?)`, username, token)
+	if err != nil {
+		return fmt.Errorf("Failed to create \"juju_token_history\" entry: %w", err)
+	}
+
+	return nil
+}
+
+// JujuTokenRevokedSince returns whether token of the JujuUser with the given username was revoked at or after since.
+func JujuTokenRevokedSince(ctx context.Context, tx *sql.Tx, username string, token string, since time.Time) (bool, error) {
+	count, err := query.Count(ctx, tx, "juju_token_history", "username = ? AND token = ? AND revoked_at >= ?",
+		username, token, since.UTC().Format(time.DateTime))
+	if err != nil {
+		return false, fmt.Errorf("Failed to fetch from \"juju_token_history\" table: %w", err)
+	}
+
+	return count > 0, nil
+}
+
+// DeleteJujuTokenHistoryBefore deletes the juju token history entries of tokens revoked before the given time
+// and returns how many were deleted.
+func DeleteJujuTokenHistoryBefore(ctx context.Context, tx *sql.Tx, before time.Time) (int64, error) {
+	result, err := tx.ExecContext(ctx, `DELETE FROM juju_token_history WHERE revoked_at < ?`, before.UTC().Format(time.DateTime))
+	if err != nil {
+		return 0, fmt.Errorf("Delete \"juju_token_history\" entries failed: %w", err)
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("Fetch affected rows: %w", err)
+	}
+
+	return n, nil
+}
@@ -18,13 +18,13 @@ import (
 var _ = api.ServerEnvironment{}
 
 var jujuUserObjects = cluster.RegisterStmt(`
-SELECT jujuuser.id, jujuuser.username, jujuuser.token
+SELECT jujuuser.id, jujuuser.username, jujuuser.token, jujuuser.created_at, jujuuser.expires_at
   FROM jujuuser
   ORDER BY jujuuser.username
 `)
 
 var jujuUserObjectsByUsername = cluster.RegisterStmt(`
-SELECT jujuuser.id, jujuuser.username, jujuuser.token
+SELECT jujuuser.id, jujuuser.username, jujuuser.token, jujuuser.created_at, jujuuser.expires_at
   FROM jujuuser
   WHERE ( jujuuser.username = ? )
   ORDER BY jujuuser.username
@@ -36,8 +36,8 @@ SELECT jujuuser.id FROM jujuuser
 `)
 
 var jujuUserCreate = cluster.RegisterStmt(`
-INSERT INTO jujuuser (username, token)
-  VALUES (?, ?)
+INSERT INTO jujuuser (username, token, created_at, expires_at)
+  VALUES (?, ?, ?, ?)
 `)
 
 var jujuUserDeleteByUsername = cluster.RegisterStmt(`
@@ -46,14 +46,14 @@ DELETE FROM jujuuser WHERE username = ?
 
 var jujuUserUpdate = cluster.RegisterStmt(`
 UPDATE jujuuser
-  SET username = ?, token = ?
+  SET username = ?, token = ?, created_at = ?, expires_at = ?
  WHERE id = ?
 `)
 
 // jujuUserColumns returns a string of column names to be used with a SELECT statement for the entity.
 // Use this function when building statements to retrieve database entries matching the JujuUser entity.
 func jujuUserColumns() string {
-	return "jujuuser.id, jujuuser.username, jujuuser.token"
+	return "jujuuser.id, jujuuser.username, jujuuser.token, jujuuser.created_at, jujuuser.expires_at"
 }
 
 // getJujuUsers can be used to run handwritten sql.Stmts to return a slice of objects.
@@ -62,7 +62,7 @@ func getJujuUsers(ctx context.Context, stmt *sql.Stmt, args ...any) ([]JujuUser,
 
 	dest := func(scan func(dest ...any) error) error {
 		j := JujuUser{}
-		err := scan(&j.ID, &j.Username, &j.Token)
+		err := scan(&j.ID, &j.Username, &j.Token, &j.CreatedAt, &j.ExpiresAt)
 		if err != nil {
 			return err
 		}
@@ -86,7 +86,7 @@ func getJujuUsersRaw(ctx context.Context, tx *sql.Tx, sql string, args ...any) (
 
 	dest := func(scan func(dest ...any) error) error {
 		j := JujuUser{}
-		err := scan(&j.ID, &j.Username, &j.Token)
+		err := scan(&j.ID, &j.Username, &j.Token, &j.CreatedAt, &j.ExpiresAt)
 		if err != nil {
 			return err
 		}
@@ -242,11 +242,13 @@ func CreateJujuUser(ctx context.Context, tx *sql.Tx, object JujuUser) (int64, er
 		return -1, api.StatusErrorf(http.StatusConflict, "This \"jujuuser\" entry already exists")
 	}
 
-	args := make([]any, 2)
+	args := make([]any, 4)
 
 	// Populate the statement arguments.
 	args[0] = object.Username
 	args[1] = object.Token
+	args[2] = object.CreatedAt
+	args[3] = object.ExpiresAt
 
 	// Prepared statement to use.
 	stmt, err := cluster.Stmt(tx, jujuUserCreate)
@@ -308,7 +310,7 @@ func UpdateJujuUser(ctx context.Context, tx *sql.Tx, username string, object Juj
 		return fmt.Errorf("Failed to get \"jujuUserUpdate\" prepared statement: %w", err)
 	}
 
-	result, err := stmt.Exec(object.Username, object.Token, id)
+	result, err := stmt.Exec(object.Username, object.Token, object.CreatedAt, object.ExpiresAt, id)
 	if err != nil {
 		return fmt.Errorf("Update \"jujuuser\" entry failed: %w", err)
 	}
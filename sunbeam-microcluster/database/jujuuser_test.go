@@ -0,0 +1,60 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+)
+
+func TestDeleteJujuTokenHistoryBefore(t *testing.T) {
+	tx := NewTestSchemaTx(t)
+	ctx := context.Background()
+	now := time.Now().UTC().Truncate(time.Second)
+
+	// The token of the user expired long ago, the user is kept.
+	_, err := CreateJujuUser(ctx, tx, JujuUser{
+		Username:  "expired",
+		Token:     "token",
+		ExpiresAt: sql.NullTime{Time: now.Add(-24 * time.Hour), Valid: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for token, revokedAt := range map[string]time.Time{"old": now.Add(-time.Hour), "recent": now.Add(-time.Second)} {
+		_, err := tx.Exec(`INSERT INTO juju_token_history (username, token, revoked_at) VALUES ('expired', ?, ?)`, token, revokedAt.Format(time.DateTime))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	deleted, err := DeleteJujuTokenHistoryBefore(ctx, tx, now.Add(-time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if deleted != 1 {
+		t.Errorf("Deleted %d juju token history entries, want 1", deleted)
+	}
+
+	for token, want := range map[string]bool{"old": false, "recent": true} {
+		revoked, err := JujuTokenRevokedSince(ctx, tx, "expired", token, now.Add(-24*time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if revoked != want {
+			t.Errorf("Token %q is in the juju token history %v, want %v", token, revoked, want)
+		}
+	}
+
+	exists, err := JujuUserExists(ctx, tx, "expired")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !exists {
+		t.Error("Pruning the juju token history deleted the juju user")
+	}
+}
@@ -1,11 +1,18 @@
 package database
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
 	"database/sql"
+	"encoding/base64"
 	"fmt"
+	"io"
 	"net/http"
+	"strings"
+	"time"
 
+	"github.com/canonical/lxd/lxd/db/query"
 	"github.com/canonical/lxd/shared/api"
 	"github.com/canonical/microcluster/cluster"
 )
@@ -28,11 +35,16 @@ import (
 // ManifestItem is used to save the Sunbeam manifests provided by user.
 // AppliedDate is saved as Timestamp in database but retreived as string
 // Probable Bug: https://github.com/mattn/go-sqlite3/issues/951
+// Status is the application status of the manifest and Error the error it failed with, if any.
+// Compressed flags Data compressed with CompressManifestData.
 type ManifestItem struct {
 	ID          int
 	ManifestID  string `db:"primary=yes"`
 	AppliedDate string
 	Data        string
+	Status      string
+	Error       sql.NullString
+	Compressed  bool
 }
 
 // ManifestItemFilter is a required struct for use with lxd-generate. It is used for filtering fields on database fetches.
@@ -41,12 +53,12 @@ type ManifestItemFilter struct {
 }
 
 var manifestItemCreate = cluster.RegisterStmt(`
-INSERT INTO manifest (manifest_id, data)
-  VALUES (?, ?)
+INSERT INTO manifest (manifest_id, data, compressed)
+  VALUES (?, ?, ?)
 `)
 
 var latestManifestItemObject = cluster.RegisterStmt(`
-SELECT manifest.id, manifest.manifest_id, manifest.applied_date, manifest.data
+SELECT manifest.id, manifest.manifest_id, manifest.applied_date, manifest.data, manifest.status, manifest.error, manifest.compressed
   FROM manifest
   WHERE manifest.applied_date = (SELECT MAX(applied_date) FROM manifest)
 `)
@@ -64,11 +76,12 @@ func CreateManifestItem(ctx context.Context, tx *sql.Tx, object ManifestItem) (i
 		return -1, api.StatusErrorf(http.StatusConflict, "This \"manifest\" entry already exists")
 	}
 
-	args := make([]any, 2)
+	args := make([]any, 3)
 
 	// Populate the statement arguments.
 	args[0] = object.ManifestID
 	args[1] = object.Data
+	args[2] = object.Compressed
 
 	// Prepared statement to use.
 	stmt, err := cluster.Stmt(tx, manifestItemCreate)
@@ -117,3 +130,282 @@ func GetLatestManifestItem(ctx context.Context, tx *sql.Tx) (*ManifestItem, erro
 		return &objects[objectsLen-1], nil
 	}
 }
+
+// ManifestItemStatus is the application status of a ManifestItem and the error it failed with, if any.
+type ManifestItemStatus struct {
+	Status string
+	Error  *string
+}
+
+// ManifestItemPageFilter selects the ManifestItems returned by GetManifestItemsPage.
+type ManifestItemPageFilter struct {
+	// Status of the manifests, all statuses if nil.
+	Status *string
+	// Search is a substring of the manifest ids, all manifests if empty.
+	Search string
+	// Since excludes the manifests applied before it, if not nil.
+	Since *time.Time
+	// Until excludes the manifests applied after it, if not nil.
+	Until *time.Time
+}
+
+// GetManifestItemsPage returns the ManifestItems matching filter sorted by manifest id,
+// skipping the first offset items and returning at most limit items if limit is positive.
+// The total number of items matching filter is returned along with them.
+func GetManifestItemsPage(ctx context.Context, tx *sql.Tx, filter ManifestItemPageFilter, offset int, limit int) ([]ManifestItem, int, error) {
+	var where []string
+	args := make([]any, 0)
+
+	if filter.Status != nil {
+		where = append(where, `manifest.status = ?`)
+		args = append(args, *filter.Status)
+	}
+
+	if filter.Search != "" {
+		where = append(where, `manifest.manifest_id LIKE ? ESCAPE '\'`)
+		args = append(args, "%"+likeEscaper.Replace(filter.Search)+"%")
+	}
+
+	if filter.Since != nil {
+		where = append(where, `manifest.applied_date >= ?`)
+		args = append(args, filter.Since.UTC().Format(time.DateTime))
+	}
+
+	if filter.Until != nil {
+		where = append(where, `manifest.applied_date <= ?`)
+		args = append(args, filter.Until.UTC().Format(time.DateTime))
+	}
+
+	whereClause := strings.Join(where, ` AND `)
+
+	total, err := query.Count(ctx, tx, "manifest", whereClause, args...)
+	if err != nil {
+		return nil, -1, fmt.Errorf("Failed to count \"manifest\" entries: %w", err)
+	}
+
+	stmt := `SELECT ` + manifestItemColumns() + ` FROM manifest`
+	if whereClause != "" {
+		stmt += ` WHERE ` + whereClause
+	}
+
+	stmt += ` ORDER BY manifest.manifest_id`
+
+	if limit > 0 {
+		stmt += ` LIMIT ? OFFSET ?`
+		args = append(args, limit, offset)
+	}
+
+	objects := make([]ManifestItem, 0)
+
+	dest := func(scan func(dest ...any) error) error {
+		m := ManifestItem{}
+		err := scan(&m.ID, &m.ManifestID, &m.AppliedDate, &m.Data, &m.Status, &m.Error, &m.Compressed)
+		if err != nil {
+			return err
+		}
+
+		objects = append(objects, m)
+
+		return nil
+	}
+
+	err = query.Scan(ctx, tx, stmt, dest, args...)
+	if err != nil {
+		return nil, -1, fmt.Errorf("Failed to fetch from \"manifest\" table: %w", err)
+	}
+
+	return objects, total, nil
+}
+
+// CountManifestItemsByStatus returns the number of ManifestItems with each application status.
+func CountManifestItemsByStatus(ctx context.Context, tx *sql.Tx) (map[string]int, error) {
+	stmt := `SELECT manifest.status, COUNT(*) FROM manifest GROUP BY manifest.status`
+
+	counts := map[string]int{}
+
+	dest := func(scan func(dest ...any) error) error {
+		var status string
+		var count int
+		err := scan(&status, &count)
+		if err != nil {
+			return err
+		}
+
+		counts[status] = count
+
+		return nil
+	}
+
+	err := query.Scan(ctx, tx, stmt, dest)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to fetch from \"manifest\" table: %w", err)
+	}
+
+	return counts, nil
+}
+
+// SetManifestItemStatus sets the application status of the ManifestItem with the given manifest id.
+func SetManifestItemStatus(ctx context.Context, tx *sql.Tx, manifestID string, status ManifestItemStatus) error {
+	result, err := tx.ExecContext(ctx, `UPDATE manifest SET status = ?, error = ? WHERE manifest_id = ?`, status.Status, status.Error, manifestID)
+	if err != nil {
+		return fmt.Errorf("Update \"manifest\" status failed: %w", err)
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("Fetch affected rows: %w", err)
+	}
+
+	if n == 0 {
+		return api.StatusErrorf(http.StatusNotFound, "ManifestItem not found")
+	}
+
+	return nil
+}
+
+// TransitionManifestItemStatus sets the application status of the ManifestItem with the given manifest id
+// to status, clearing its error, only if its current status is from. It returns whether the status was set.
+func TransitionManifestItemStatus(ctx context.Context, tx *sql.Tx, manifestID string, from string, status string) (bool, error) {
+	result, err := tx.ExecContext(ctx, `UPDATE manifest SET status = ?, error = NULL WHERE manifest_id = ? AND status = ?`, status, manifestID, from)
+	if err != nil {
+		return false, fmt.Errorf("Update \"manifest\" status failed: %w", err)
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("Fetch affected rows: %w", err)
+	}
+
+	return n > 0, nil
+}
+
+// GetManifestItemRollbackData returns the data reverting the ManifestItem with the given manifest id,
+// empty if it has none.
+func GetManifestItemRollbackData(ctx context.Context, tx *sql.Tx, manifestID string) (string, error) {
+	var rollbackData sql.NullString
+
+	err := tx.QueryRowContext(ctx, `SELECT rollback_data FROM manifest WHERE manifest_id = ?`, manifestID).Scan(&rollbackData)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", api.StatusErrorf(http.StatusNotFound, "ManifestItem not found")
+		}
+
+		return "", fmt.Errorf("Failed to fetch from \"manifest\" table: %w", err)
+	}
+
+	return rollbackData.String, nil
+}
+
+// SetManifestItemRollbackData sets the data reverting the ManifestItem with the given manifest id.
+func SetManifestItemRollbackData(ctx context.Context, tx *sql.Tx, manifestID string, rollbackData string) error {
+	_, err := tx.ExecContext(ctx, `UPDATE manifest SET rollback_data = ? WHERE manifest_id = ?`, rollbackData, manifestID)
+	if err != nil {
+		return fmt.Errorf("Update \"manifest\" rollback_data failed: %w", err)
+	}
+
+	return nil
+}
+
+// GetManifestItemSignature returns the verified signature of the ManifestItem with the given manifest id,
+// empty if it was not signed.
+func GetManifestItemSignature(ctx context.Context, tx *sql.Tx, manifestID string) (string, error) {
+	var signature sql.NullString
+
+	err := tx.QueryRowContext(ctx, `SELECT signature FROM manifest WHERE manifest_id = ?`, manifestID).Scan(&signature)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", api.StatusErrorf(http.StatusNotFound, "ManifestItem not found")
+		}
+
+		return "", fmt.Errorf("Failed to fetch from \"manifest\" table: %w", err)
+	}
+
+	return signature.String, nil
+}
+
+// SetManifestItemSignature sets the verified signature of the ManifestItem with the given manifest id.
+func SetManifestItemSignature(ctx context.Context, tx *sql.Tx, manifestID string, signature string) error {
+	_, err := tx.ExecContext(ctx, `UPDATE manifest SET signature = ? WHERE manifest_id = ?`, signature, manifestID)
+	if err != nil {
+		return fmt.Errorf("Update \"manifest\" signature failed: %w", err)
+	}
+
+	return nil
+}
+
+// CompressManifestData gzip compresses manifest data, base64 encoded to be stored as text.
+func CompressManifestData(data string) (string, error) {
+	var b bytes.Buffer
+
+	w := gzip.NewWriter(&b)
+	_, err := w.Write([]byte(data))
+	if err != nil {
+		return "", fmt.Errorf("Failed to compress manifest data: %w", err)
+	}
+
+	err = w.Close()
+	if err != nil {
+		return "", fmt.Errorf("Failed to compress manifest data: %w", err)
+	}
+
+	return base64.StdEncoding.EncodeToString(b.Bytes()), nil
+}
+
+// DecompressManifestData reverts CompressManifestData.
+func DecompressManifestData(data string) (string, error) {
+	compressed, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return "", fmt.Errorf("Failed to decode compressed manifest data: %w", err)
+	}
+
+	r, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return "", fmt.Errorf("Failed to decompress manifest data: %w", err)
+	}
+
+	defer func() { _ = r.Close() }()
+
+	decompressed, err := io.ReadAll(r)
+	if err != nil {
+		return "", fmt.Errorf("Failed to decompress manifest data: %w", err)
+	}
+
+	return string(decompressed), nil
+}
+
+// compressManifestItems compresses the data of the ManifestItems stored uncompressed.
+func compressManifestItems(ctx context.Context, tx *sql.Tx) error {
+	data := map[int]string{}
+
+	dest := func(scan func(dest ...any) error) error {
+		var id int
+		var value string
+		err := scan(&id, &value)
+		if err != nil {
+			return err
+		}
+
+		data[id] = value
+
+		return nil
+	}
+
+	err := query.Scan(ctx, tx, `SELECT id, data FROM manifest WHERE compressed = 0`, dest)
+	if err != nil {
+		return fmt.Errorf("Failed to fetch from \"manifest\" table: %w", err)
+	}
+
+	for id, value := range data {
+		compressed, err := CompressManifestData(value)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, `UPDATE manifest SET data = ?, compressed = 1 WHERE id = ?`, compressed, id)
+		if err != nil {
+			return fmt.Errorf("Update \"manifest\" data failed: %w", err)
+		}
+	}
+
+	return nil
+}
@@ -18,13 +18,13 @@ import (
 var _ = api.ServerEnvironment{}
 
 var manifestItemObjects = cluster.RegisterStmt(`
-SELECT manifest.id, manifest.manifest_id, manifest.applied_date, manifest.data
+SELECT manifest.id, manifest.manifest_id, manifest.applied_date, manifest.data, manifest.status, manifest.error, manifest.compressed
   FROM manifest
   ORDER BY manifest.manifest_id
 `)
 
 var manifestItemObjectsByManifestID = cluster.RegisterStmt(`
-SELECT manifest.id, manifest.manifest_id, manifest.applied_date, manifest.data
+SELECT manifest.id, manifest.manifest_id, manifest.applied_date, manifest.data, manifest.status, manifest.error, manifest.compressed
   FROM manifest
   WHERE ( manifest.manifest_id = ? )
   ORDER BY manifest.manifest_id
@@ -42,7 +42,7 @@ DELETE FROM manifest WHERE manifest_id = ?
 // manifestItemColumns returns a string of column names to be used with a SELECT statement for the entity.
 // Use this function when building statements to retrieve database entries matching the ManifestItem entity.
 func manifestItemColumns() string {
-	return "manifest.id, manifest.manifest_id, manifest.applied_date, manifest.data"
+	return "manifest.id, manifest.manifest_id, manifest.applied_date, manifest.data, manifest.status, manifest.error, manifest.compressed"
 }
 
 // getManifestItems can be used to run handwritten sql.Stmts to return a slice of objects.
@@ -51,7 +51,7 @@ func getManifestItems(ctx context.Context, stmt *sql.Stmt, args ...any) ([]Manif
 
 	dest := func(scan func(dest ...any) error) error {
 		m := ManifestItem{}
-		err := scan(&m.ID, &m.ManifestID, &m.AppliedDate, &m.Data)
+		err := scan(&m.ID, &m.ManifestID, &m.AppliedDate, &m.Data, &m.Status, &m.Error, &m.Compressed)
 		if err != nil {
 			return err
 		}
@@ -75,7 +75,7 @@ func getManifestItemsRaw(ctx context.Context, tx *sql.Tx, sql string, args ...an
 
 	dest := func(scan func(dest ...any) error) error {
 		m := ManifestItem{}
-		err := scan(&m.ID, &m.ManifestID, &m.AppliedDate, &m.Data)
+		err := scan(&m.ID, &m.ManifestID, &m.AppliedDate, &m.Data, &m.Status, &m.Error, &m.Compressed)
 		if err != nil {
 			return err
 		}
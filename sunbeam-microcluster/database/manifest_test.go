@@ -0,0 +1,173 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// testManifestData returns a YAML manifest of at least size bytes deploying charms with a channel,
+// a revision and config options, as in large deployments.
+func testManifestData(size int) string {
+	var b strings.Builder
+
+	b.WriteString("software:\n  charms:\n")
+	for i := 0; b.Len() < size; i++ {
+		fmt.Fprintf(&b, "    charm-%d:\n      channel: 2024.1/stable\n      revision: %d\n      config:\n", i, 100+i%50)
+		for j := 0; j < 12; j++ {
+			fmt.Fprintf(&b, "        option-%d: value-%d-%d\n", j, i, j)
+		}
+	}
+
+	return b.String()
+}
+
+func TestManifestItemStatus(t *testing.T) {
+	tx := NewTestSchemaTx(t)
+	ctx := context.Background()
+
+	for _, manifestID := range []string{"manifest-1", "manifest-2"} {
+		_, err := CreateManifestItem(ctx, tx, ManifestItem{ManifestID: manifestID, Data: "data"})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	errMsg := "terraform apply failed"
+	err := SetManifestItemStatus(ctx, tx, "manifest-2", ManifestItemStatus{Status: "failed", Error: &errMsg})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	applied, err := GetManifestItem(ctx, tx, "manifest-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if applied.Status != "applied" || applied.Error.Valid {
+		t.Errorf("Manifest manifest-1 is %q with error %v, want applied without error", applied.Status, applied.Error)
+	}
+
+	items, total, err := GetManifestItemsPage(ctx, tx, ManifestItemPageFilter{}, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if total != 2 || len(items) != 2 {
+		t.Fatalf("Listed %d of %d manifests, want 2 of 2", len(items), total)
+	}
+
+	failed := items[1]
+	if failed.Status != "failed" || failed.Error.String != errMsg {
+		t.Errorf("Manifest manifest-2 is %q with error %q, want failed with error %q", failed.Status, failed.Error.String, errMsg)
+	}
+}
+
+func TestManifestItemCompressed(t *testing.T) {
+	tx := NewTestSchemaTx(t)
+	ctx := context.Background()
+	data := testManifestData(1024)
+
+	compressed, err := CompressManifestData(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = CreateManifestItem(ctx, tx, ManifestItem{ManifestID: "compressed", Data: compressed, Compressed: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Manifests stored before the compression, back-filled by the migration.
+	_, err = tx.Exec(`INSERT INTO manifest (manifest_id, data) VALUES ('legacy', ?)`, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = compressManifestItems(ctx, tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, manifestID := range []string{"compressed", "legacy"} {
+		item, err := GetManifestItem(ctx, tx, manifestID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !item.Compressed {
+			t.Fatalf("Manifest %s is not compressed", manifestID)
+		}
+
+		decompressed, err := DecompressManifestData(item.Data)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if decompressed != data {
+			t.Errorf("Manifest %s decompressed to different data", manifestID)
+		}
+	}
+}
+
+// BenchmarkManifestItemStorage stores and reads back a 500 KB manifest as is and compressed,
+// reporting the bytes stored in the data column.
+func BenchmarkManifestItemStorage(b *testing.B) {
+	data := testManifestData(500 * 1024)
+
+	for _, compress := range []bool{false, true} {
+		name := "uncompressed"
+		if compress {
+			name = "compressed"
+		}
+
+		b.Run(name, func(b *testing.B) {
+			tx := NewTestSchemaTx(b)
+			ctx := context.Background()
+
+			b.SetBytes(int64(len(data)))
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				manifestID := fmt.Sprintf("manifest-%d", i)
+
+				stored := data
+				if compress {
+					var err error
+					stored, err = CompressManifestData(data)
+					if err != nil {
+						b.Fatal(err)
+					}
+				}
+
+				_, err := CreateManifestItem(ctx, tx, ManifestItem{ManifestID: manifestID, Data: stored, Compressed: compress})
+				if err != nil {
+					b.Fatal(err)
+				}
+
+				item, err := GetManifestItem(ctx, tx, manifestID)
+				if err != nil {
+					b.Fatal(err)
+				}
+
+				if item.Compressed {
+					_, err = DecompressManifestData(item.Data)
+					if err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+
+			b.StopTimer()
+
+			var storedBytes int
+			err := tx.QueryRow(`SELECT length(data) FROM manifest WHERE manifest_id = 'manifest-0'`).Scan(&storedBytes)
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			b.ReportMetric(float64(storedBytes), "stored-B")
+		})
+	}
+}
@@ -0,0 +1,135 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+
+	"github.com/canonical/lxd/lxd/db/query"
+	"github.com/canonical/lxd/shared/api"
+)
+
+// ConfigNamespacePolicy is the lifecycle policy of the config keys in a namespace.
+type ConfigNamespacePolicy struct {
+	Namespace         string
+	DefaultTTLSeconds *int64
+	AllowUserTTL      bool
+}
+
+// GetConfigNamespacePolicies returns all the ConfigNamespacePolicies.
+func GetConfigNamespacePolicies(ctx context.Context, tx *sql.Tx) ([]ConfigNamespacePolicy, error) {
+	return getConfigNamespacePolicies(ctx, tx, "")
+}
+
+// GetConfigNamespacePolicy returns the ConfigNamespacePolicy of the given namespace.
+func GetConfigNamespacePolicy(ctx context.Context, tx *sql.Tx, namespace string) (*ConfigNamespacePolicy, error) {
+	policies, err := getConfigNamespacePolicies(ctx, tx, namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(policies) == 0 {
+		return nil, api.StatusErrorf(http.StatusNotFound, "ConfigNamespacePolicy not found")
+	}
+
+	return &policies[0], nil
+}
+
+// getConfigNamespacePolicies returns the ConfigNamespacePolicies, only the one of namespace if not empty.
+func getConfigNamespacePolicies(ctx context.Context, tx *sql.Tx, namespace string) ([]ConfigNamespacePolicy, error) {
+	stmt := `SELECT namespace, default_ttl_seconds, allow_user_ttl FROM config_namespace_policies`
+
+	args := make([]any, 0)
+	if namespace != "" {
+		stmt += ` WHERE namespace = ?`
+		args = append(args, namespace)
+	}
+
+	stmt += ` ORDER BY namespace`
+
+	policies := make([]ConfigNamespacePolicy, 0)
+
+	dest := func(scan func(dest ...any) error) error {
+		p := ConfigNamespacePolicy{}
+		var ttl sql.NullInt64
+		err := scan(&p.Namespace, &ttl, &p.AllowUserTTL)
+		if err != nil {
+			return err
+		}
+
+		if ttl.Valid {
+			p.DefaultTTLSeconds = &ttl.Int64
+		}
+
+		policies = append(policies, p)
+
+		return nil
+	}
+
+	err := query.Scan(ctx, tx, stmt, dest, args...)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to fetch from \"config_namespace_policies\" table: %w", err)
+	}
+
+	return policies, nil
+}
+
+// CreateConfigNamespacePolicy adds a new ConfigNamespacePolicy.
+func CreateConfigNamespacePolicy(ctx context.Context, tx *sql.Tx, policy ConfigNamespacePolicy) error {
+	count, err := query.Count(ctx, tx, "config_namespace_policies", "namespace = ?", policy.Namespace)
+	if err != nil {
+		return fmt.Errorf("Failed to check for duplicates: %w", err)
+	}
+
+	if count > 0 {
+		return api.StatusErrorf(http.StatusConflict, "This \"config_namespace_policies\" entry already exists")
+	}
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO config_namespace_policies (namespace, default_ttl_seconds, allow_user_ttl) VALUES (?, ?, ?)`,
+		policy.Namespace, policy.DefaultTTLSeconds, policy.AllowUserTTL)
+	if err != nil {
+		return fmt.Errorf("Failed to create \"config_namespace_policies\" entry: %w", err)
+	}
+
+	return nil
+}
+
+// UpdateConfigNamespacePolicy updates the ConfigNamespacePolicy of policy.Namespace.
+func UpdateConfigNamespacePolicy(ctx context.Context, tx *sql.Tx, policy ConfigNamespacePolicy) error {
+	result, err := tx.ExecContext(ctx, `UPDATE config_namespace_policies SET default_ttl_seconds = ?, allow_user_ttl = ? WHERE namespace = ?`,
+		policy.DefaultTTLSeconds, policy.AllowUserTTL, policy.Namespace)
+	if err != nil {
+		return fmt.Errorf("Update \"config_namespace_policies\" entry failed: %w", err)
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("Fetch affected rows: %w", err)
+	}
+
+	if n == 0 {
+		return api.StatusErrorf(http.StatusNotFound, "ConfigNamespacePolicy not found")
+	}
+
+	return nil
+}
+
+// DeleteConfigNamespacePolicy deletes the ConfigNamespacePolicy of the given namespace.
+func DeleteConfigNamespacePolicy(ctx context.Context, tx *sql.Tx, namespace string) error {
+	result, err := tx.ExecContext(ctx, `DELETE FROM config_namespace_policies WHERE namespace = ?`, namespace)
+	if err != nil {
+		return fmt.Errorf("Delete \"config_namespace_policies\": %w", err)
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("Fetch affected rows: %w", err)
+	}
+
+	if n == 0 {
+		return api.StatusErrorf(http.StatusNotFound, "ConfigNamespacePolicy not found")
+	}
+
+	return nil
+}
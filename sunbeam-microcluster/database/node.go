@@ -4,11 +4,17 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"net/http"
 	"strings"
 
+	"github.com/canonical/lxd/lxd/db/query"
+	"github.com/canonical/lxd/shared/api"
 	"github.com/canonical/microcluster/cluster"
 )
 
+// ErrSystemIDAlreadyAssigned is returned when a node is given a system id already used by another node.
+var ErrSystemIDAlreadyAssigned = api.StatusErrorf(http.StatusConflict, "System ID is already assigned to another node")
+
 //go:generate -command mapper lxd-generate db mapper -t node.mapper.go
 //go:generate mapper reset
 //
@@ -48,6 +54,11 @@ type NodeFilter struct {
 	MachineID *int
 }
 
+// IsSystemIDConstraintError returns whether err is a violation of the nodes system id unique index.
+func IsSystemIDConstraintError(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "UNIQUE constraint failed: nodes.system_id")
+}
+
 // GetNodesFromRoles returns a slice of Nodes that match the given roles.
 func GetNodesFromRoles(ctx context.Context, tx *sql.Tx, roles []string) ([]Node, error) {
 
@@ -82,3 +93,100 @@ func GetNodesFromRoles(ctx context.Context, tx *sql.Tx, roles []string) ([]Node,
 	return nodes, nil
 
 }
+
+// GetNodesFromSystemIDPrefix returns a slice of Nodes whose system id starts with the given prefix.
+func GetNodesFromSystemIDPrefix(ctx context.Context, tx *sql.Tx, prefix string) ([]Node, error) {
+	stmt, err := cluster.StmtString(nodeObjects)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to fetch prepared statement nodeObjects: %v", err)
+	}
+
+	queryParts := strings.SplitN(stmt, "ORDER BY", 2)
+	queryParts[0] += ` WHERE nodes.system_id LIKE ? ESCAPE '\'`
+	stmt = strings.Join(queryParts, " ORDER BY")
+
+	nodes, err := getNodesRaw(ctx, tx, stmt, likeEscaper.Replace(prefix)+"%")
+	if err != nil {
+		return nil, fmt.Errorf("Failed to fetch from \"nodes\" table: %w", err)
+	}
+
+	return nodes, nil
+}
+
+// GetNodesLastSeen returns the last time each node was seen, keyed by node name.
+// Nodes never seen are not part of the result.
+func GetNodesLastSeen(ctx context.Context, tx *sql.Tx) (map[string]string, error) {
+	stmt := `SELECT nodes.name, nodes.last_seen_at FROM nodes WHERE nodes.last_seen_at IS NOT NULL`
+
+	lastSeen := map[string]string{}
+
+	dest := func(scan func(dest ...any) error) error {
+		var name, seenAt string
+		err := scan(&name, &seenAt)
+		if err != nil {
+			return err
+		}
+
+		lastSeen[name] = seenAt
+
+		return nil
+	}
+
+	err := query.Scan(ctx, tx, stmt, dest)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to fetch from \"nodes\" table: %w", err)
+	}
+
+	return lastSeen, nil
+}
+
+// UpdateNodesLastSeen sets the last seen time of each node to the last heartbeat of its cluster member,
+// which the dqlite leader records for each member answering a heartbeat round, including itself.
+// Members that never answered a heartbeat have a zero heartbeat and leave their nodes unseen.
+func UpdateNodesLastSeen(ctx context.Context, tx *sql.Tx) error {
+	stmt := `
+UPDATE nodes SET last_seen_at = (
+    SELECT datetime(internal_cluster_members.heartbeat) FROM internal_cluster_members
+      WHERE internal_cluster_members.id = nodes.member_id)
+  WHERE EXISTS (
+    SELECT 1 FROM internal_cluster_members
+      WHERE internal_cluster_members.id = nodes.member_id
+        AND datetime(internal_cluster_members.heartbeat) > IFNULL(datetime(nodes.last_seen_at), '0001-01-01 00:00:00'))
+`
+
+	_, err := tx.ExecContext(ctx, stmt)
+	if err != nil {
+		return fmt.Errorf("Update \"nodes\" last seen failed: %w", err)
+	}
+
+	return nil
+}
+
+// GetNodesInMaintenance returns the names of the nodes in maintenance mode.
+func GetNodesInMaintenance(ctx context.Context, tx *sql.Tx) ([]string, error) {
+	names, err := query.SelectStrings(ctx, tx, `SELECT nodes.name FROM nodes WHERE nodes.maintenance_mode = 1 ORDER BY nodes.name`)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to fetch from \"nodes\" table: %w", err)
+	}
+
+	return names, nil
+}
+
+// SetNodeMaintenanceMode enables or disables the maintenance mode of the node with the given name.
+func SetNodeMaintenanceMode(ctx context.Context, tx *sql.Tx, name string, enabled bool) error {
+	result, err := tx.ExecContext(ctx, `UPDATE nodes SET maintenance_mode = ? WHERE name = ?`, enabled, name)
+	if err != nil {
+		return fmt.Errorf("Update \"nodes\" maintenance mode failed: %w", err)
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("Fetch affected rows: %w", err)
+	}
+
+	if n == 0 {
+		return api.StatusErrorf(http.StatusNotFound, "Node not found")
+	}
+
+	return nil
+}
@@ -0,0 +1,97 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/canonical/lxd/lxd/db/query"
+)
+
+// GetNodeCapacity returns the capacity values reported by the node with the given name
+// and the time of the latest report, empty if the node never reported any.
+func GetNodeCapacity(ctx context.Context, tx *sql.Tx, name string) (map[string]string, string, error) {
+	stmt := `SELECT key, value, updated_at FROM node_capacity WHERE node_id = ` + nodeIDStmt
+
+	capacity := map[string]string{}
+	var lastUpdated string
+
+	dest := func(scan func(dest ...any) error) error {
+		var key, value, updatedAt string
+		err := scan(&key, &value, &updatedAt)
+		if err != nil {
+			return err
+		}
+
+		capacity[key] = value
+		if updatedAt > lastUpdated {
+			lastUpdated = updatedAt
+		}
+
+		return nil
+	}
+
+	err := query.Scan(ctx, tx, stmt, dest, name)
+	if err != nil {
+		return nil, "", fmt.Errorf("Failed to fetch from \"node_capacity\" table: %w", err)
+	}
+
+	return capacity, lastUpdated, nil
+}
+
+// GetNodesCapacityUpdatedAt returns the time of the latest capacity report of each node, keyed by node name.
+// Nodes which never reported their capacity are not part of the result.
+func GetNodesCapacityUpdatedAt(ctx context.Context, tx *sql.Tx) (map[string]string, error) {
+	stmt := `
+SELECT nodes.name, MAX(node_capacity.updated_at) FROM node_capacity
+  JOIN nodes ON node_capacity.node_id = nodes.id
+  GROUP BY nodes.id
+`
+
+	updatedAt := map[string]string{}
+
+	dest := func(scan func(dest ...any) error) error {
+		var name, updated string
+		err := scan(&name, &updated)
+		if err != nil {
+			return err
+		}
+
+		updatedAt[name] = updated
+
+		return nil
+	}
+
+	err := query.Scan(ctx, tx, stmt, dest)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to fetch from \"node_capacity\" table: %w", err)
+	}
+
+	return updatedAt, nil
+}
+
+// SetNodeCapacity records the given capacity values of the node with the given name,
+// replacing the values previously reported under the same keys.
+func SetNodeCapacity(ctx context.Context, tx *sql.Tx, name string, capacity map[string]string) error {
+	for key, value := range capacity {
+		_, err := tx.ExecContext(ctx, `
+INSERT INTO node_capacity (node_id, key, value) VALUES (`+nodeIDStmt+`, ?, ?)
+  ON CONFLICT(node_id, key) DO UPDATE SET value = excluded.value, updated_at = CURRENT_TIMESTAMP`,
+			name, key, value)
+		if err != nil {
+			return fmt.Errorf("Failed to set \"node_capacity\" entry: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// DeleteNodeCapacity deletes all the capacity values of the node with the given name.
+func DeleteNodeCapacity(ctx context.Context, tx *sql.Tx, name string) error {
+	_, err := tx.ExecContext(ctx, `DELETE FROM node_capacity WHERE node_id = `+nodeIDStmt, name)
+	if err != nil {
+		return fmt.Errorf("Delete \"node_capacity\" entries failed: %w", err)
+	}
+
+	return nil
+}
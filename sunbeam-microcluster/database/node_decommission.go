@@ -0,0 +1,27 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// NodeDecommissionAuditEntry records the decommission of a node, or its restore after a failed decommission.
+type NodeDecommissionAuditEntry struct {
+	Name          string
+	Action        string
+	ClusterMember string
+	Node          string
+	Member        string
+}
+
+// CreateNodeDecommissionAuditEntry adds an entry to the node decommission audit log.
+func CreateNodeDecommissionAuditEntry(ctx context.Context, tx *sql.Tx, entry NodeDecommissionAuditEntry) error {
+	_, err := tx.ExecContext(ctx, `INSERT INTO node_decommission_audit (name, action, cluster_member, node, member) VALUES (?, ?, ?, ?, ?)`,
+		entry.Name, entry.Action, entry.ClusterMember, entry.Node, entry.Member)
+	if err != nil {
+		return fmt.Errorf("Failed to create \"node_decommission_audit\" entry: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,157 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/canonical/lxd/lxd/db/query"
+	"github.com/canonical/lxd/shared/api"
+)
+
+// nodeIDStmt selects the id of the node with the given name.
+const nodeIDStmt = `(SELECT nodes.id FROM nodes WHERE nodes.name = ?)`
+
+// GetAllNodeLabels returns the labels of all the nodes, keyed by node name.
+// Nodes without labels are not part of the result.
+func GetAllNodeLabels(ctx context.Context, tx *sql.Tx) (map[string]map[string]string, error) {
+	stmt := `
+SELECT nodes.name, node_labels.key, node_labels.value FROM node_labels
+  JOIN nodes ON node_labels.node_id = nodes.id
+`
+
+	labels := map[string]map[string]string{}
+
+	dest := func(scan func(dest ...any) error) error {
+		var name, key, value string
+		err := scan(&name, &key, &value)
+		if err != nil {
+			return err
+		}
+
+		if labels[name] == nil {
+			labels[name] = map[string]string{}
+		}
+
+		labels[name][key] = value
+
+		return nil
+	}
+
+	err := query.Scan(ctx, tx, stmt, dest)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to fetch from \"node_labels\" table: %w", err)
+	}
+
+	return labels, nil
+}
+
+// GetNodeLabels returns the labels of the node with the given name.
+func GetNodeLabels(ctx context.Context, tx *sql.Tx, name string) (map[string]string, error) {
+	stmt := `SELECT key, value FROM node_labels WHERE node_id = ` + nodeIDStmt
+
+	labels := map[string]string{}
+
+	dest := func(scan func(dest ...any) error) error {
+		var key, value string
+		err := scan(&key, &value)
+		if err != nil {
+			return err
+		}
+
+		labels[key] = value
+
+		return nil
+	}
+
+	err := query.Scan(ctx, tx, stmt, dest, name)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to fetch from \"node_labels\" table: %w", err)
+	}
+
+	return labels, nil
+}
+
+// SetNodeLabels replaces the labels of the node with the given name.
+func SetNodeLabels(ctx context.Context, tx *sql.Tx, name string, labels map[string]string) error {
+	err := DeleteNodeLabels(ctx, tx, name)
+	if err != nil {
+		return err
+	}
+
+	for key, value := range labels {
+		_, err := tx.ExecContext(ctx, `INSERT INTO node_labels (node_id, key, value) VALUES (`+nodeIDStmt+`, ?, ?)`, name, key, value)
+		if err != nil {
+			return fmt.Errorf("Failed to create \"node_labels\" entry: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// DeleteNodeLabel deletes the label with the given key of the node with the given name.
+func DeleteNodeLabel(ctx context.Context, tx *sql.Tx, name string, key string) error {
+	result, err := tx.ExecContext(ctx, `DELETE FROM node_labels WHERE node_id = `+nodeIDStmt+` AND key = ?`, name, key)
+	if err != nil {
+		return fmt.Errorf("Delete \"node_labels\" entry failed: %w", err)
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("Fetch affected rows: %w", err)
+	}
+
+	if n == 0 {
+		return api.StatusErrorf(http.StatusNotFound, "NodeLabel not found")
+	}
+
+	return nil
+}
+
+// DeleteNodeLabels deletes all the labels of the node with the given name.
+func DeleteNodeLabels(ctx context.Context, tx *sql.Tx, name string) error {
+	_, err := tx.ExecContext(ctx, `DELETE FROM node_labels WHERE node_id = `+nodeIDStmt, name)
+	if err != nil {
+		return fmt.Errorf("Delete \"node_labels\" entries failed: %w", err)
+	}
+
+	return nil
+}
+
+// GetNodeNamesWithLabels returns the names of the nodes having all the given labels.
+func GetNodeNamesWithLabels(ctx context.Context, tx *sql.Tx, labels map[string]string) ([]string, error) {
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	clauses := make([]string, 0, len(keys))
+	args := make([]any, 0, 2*len(keys)+1)
+	for _, key := range keys {
+		clauses = append(clauses, `(node_labels.key = ? AND node_labels.value = ?)`)
+		args = append(args, key, labels[key])
+	}
+
+	args = append(args, len(keys))
+
+	stmt := `
+SELECT nodes.name FROM nodes
+  JOIN node_labels ON node_labels.node_id = nodes.id
+  WHERE ` + strings.Join(clauses, ` OR `) + `
+  GROUP BY nodes.id
+  HAVING COUNT(*) = ?
+  ORDER BY nodes.name
+`
+
+	names, err := query.SelectStrings(ctx, tx, stmt, args...)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to fetch from \"node_labels\" table: %w", err)
+	}
+
+	return names, nil
+}
@@ -0,0 +1,123 @@
+package database
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestUpdateNodesLastSeen(t *testing.T) {
+	tx := NewTestSchemaTx(t)
+
+	now := time.Now().UTC().Truncate(time.Second)
+	members := []struct {
+		name      string
+		heartbeat time.Time
+	}{
+		{"leader", now},
+		{"member", now.Add(-time.Minute)},
+		{"unreachable", now.Add(-time.Hour)},
+		{"joining", time.Time{}},
+	}
+
+	for i, member := range members {
+		_, err := tx.Exec(`INSERT INTO internal_cluster_members (id, name, address, certificate, schema_internal, schema_external, heartbeat, role)
+  VALUES (?, ?, ?, ?, 1, 1, ?, 'voter')`, i+1, member.name, member.name, member.name, member.heartbeat)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	nodes := []struct {
+		name     string
+		memberID int
+		lastSeen any
+	}{
+		{"leader-1", 1, nil},
+		{"leader-2", 1, nil},
+		{"member-1", 2, now.Add(-2 * time.Minute).Format(time.DateTime)},
+		{"unreachable-1", 3, now.Add(-time.Minute).Format(time.DateTime)},
+		{"joining-1", 4, nil},
+	}
+
+	for _, node := range nodes {
+		_, err := tx.Exec(`INSERT INTO nodes (member_id, name, last_seen_at) VALUES (?, ?, ?)`, node.memberID, node.name, node.lastSeen)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := UpdateNodesLastSeen(context.Background(), tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lastSeen, err := GetNodesLastSeen(context.Background(), tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := map[string]time.Time{}
+	for name, seenAt := range lastSeen {
+		got[name], err = time.Parse(time.RFC3339, seenAt)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := map[string]time.Time{
+		"leader-1":      now,
+		"leader-2":      now,
+		"member-1":      now.Add(-time.Minute),
+		"unreachable-1": now.Add(-time.Minute),
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Nodes last seen at %v, want %v", got, want)
+	}
+}
+
+func TestGetNodesFromSystemIDPrefix(t *testing.T) {
+	tx := NewTestSchemaTx(t)
+
+	_, err := tx.Exec(`INSERT INTO internal_cluster_members (id, name, address, certificate, schema_internal, schema_external, heartbeat, role)
+  VALUES (1, 'member', 'member', 'member', 1, 1, ?, 'voter')`, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, systemID := range []string{"ab_1", "abx2", "ab%3"} {
+		_, err := tx.Exec(`INSERT INTO nodes (member_id, name, role, machine_id, system_id) VALUES (1, ?, '[]', 0, ?)`, "node-"+systemID, systemID)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		prefix string
+		want   []string
+	}{
+		{"ab_", []string{"ab_1"}},
+		{"ab%", []string{"ab%3"}},
+		{"ab", []string{"ab%3", "ab_1", "abx2"}},
+	}
+
+	for _, tt := range tests {
+		nodes, err := GetNodesFromSystemIDPrefix(context.Background(), tx, tt.prefix)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		systemIDs := []string{}
+		for _, node := range nodes {
+			systemIDs = append(systemIDs, node.SystemID)
+		}
+
+		sort.Strings(systemIDs)
+		if !reflect.DeepEqual(systemIDs, tt.want) {
+			t.Errorf("GetNodesFromSystemIDPrefix(%q) returned %v, want %v", tt.prefix, systemIDs, tt.want)
+		}
+	}
+}
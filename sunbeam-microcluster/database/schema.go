@@ -4,18 +4,176 @@ package database
 import (
 	"context"
 	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync/atomic"
 
+	"github.com/canonical/lxd/lxd/db/query"
 	"github.com/canonical/lxd/lxd/db/schema"
+	"github.com/canonical/lxd/shared/logger"
+	_ "github.com/mattn/go-sqlite3" // Registers the sqlite3 driver pre-migration backups are written with
 )
 
 // SchemaExtensions is a list of schema extensions that can be passed to the MicroCluster daemon.
 // Each entry will increase the database schema version by one, and will be applied after internal schema updates.
-var SchemaExtensions = []schema.Update{
+var SchemaExtensions = withPreMigrationBackup([]schema.Update{
 	NodesSchemaUpdate,
 	ConfigSchemaUpdate,
 	JujuUserSchemaUpdate,
 	ManifestsSchemaUpdate,
 	AddSystemIDToNodes,
+	SystemIDUniqueIndexUpdate,
+	ConfigDescriptionSchemaUpdate,
+	NodesLastSeenSchemaUpdate,
+	ConfigExpiresAtSchemaUpdate,
+	ConfigNamespacePoliciesSchemaUpdate,
+	JujuUserCreatedAtSchemaUpdate,
+	ConfigUpdatedAtSchemaUpdate,
+	TerraformStateVersionsSchemaUpdate,
+	TerraformLockAuditSchemaUpdate,
+	ConfigChecksumSchemaUpdate,
+	ConfigHistorySchemaUpdate,
+	ConfigSchemaSchemaUpdate,
+	NodeLabelsSchemaUpdate,
+	NodesMaintenanceModeSchemaUpdate,
+	NodeCapacitySchemaUpdate,
+	JujuTokenHistorySchemaUpdate,
+	JujuUserExpiresAtSchemaUpdate,
+	JujuGroupsSchemaUpdate,
+	JujuAuditSchemaUpdate,
+	ManifestStatusSchemaUpdate,
+	ManifestRollbackDataSchemaUpdate,
+	ManifestCompressedSchemaUpdate,
+	ManifestSignatureSchemaUpdate,
+	NodeDecommissionAuditSchemaUpdate,
+})
+
+// StateDir is the daemon state directory holding the dqlite database.
+// Pre-migration backups are written to this directory, they are skipped if it is empty.
+var StateDir string
+
+// SkipPreMigrationBackup disables the database backup taken before the schema extensions are applied.
+var SkipPreMigrationBackup bool
+
+// preMigrationBackupChecked is set by the first pending schema extension of the daemon run,
+// which is the only one that may take a backup.
+var preMigrationBackupChecked atomic.Bool
+
+// withPreMigrationBackup wraps each schema extension with SafeSchemaUpdate.
+func withPreMigrationBackup(updates []schema.Update) []schema.Update {
+	safeUpdates := make([]schema.Update, len(updates))
+	for i, update := range updates {
+		safeUpdates[i] = SafeSchemaUpdate(i+1, update)
+	}
+
+	return safeUpdates
+}
+
+// SafeSchemaUpdate decorates a schema extension so that, if it is the first pending extension
+// of an existing database, a dump of the database is written to <state-dir>/db-backup-v<N>.db
+// before the migration runs, N being the schema version being upgraded from. The backup is
+// skipped on bootstrap, when the first pending extension is the first one.
+// If the migration fails, the backup allows manual recovery.
+func SafeSchemaUpdate(version int, update schema.Update) schema.Update {
+	return func(ctx context.Context, tx *sql.Tx) error {
+		first := preMigrationBackupChecked.CompareAndSwap(false, true)
+		if first && version > 1 && !SkipPreMigrationBackup && StateDir != "" {
+			err := backupDatabase(ctx, tx, StateDir, version-1)
+			if err != nil {
+				return fmt.Errorf("Failed to backup database before schema extension %d: %w", version, err)
+			}
+		}
+
+		return update(ctx, tx)
+	}
+}
+
+// backupDatabase writes a SQLite database at <stateDir>/db-backup-v<version>.db holding a dump
+// of the database taken in tx, so that it is consistent with the schema version being upgraded from.
+func backupDatabase(ctx context.Context, tx *sql.Tx, stateDir string, version int) error {
+	dump, err := query.Dump(ctx, tx, false)
+	if err != nil {
+		return fmt.Errorf("Failed to dump database: %w", err)
+	}
+
+	backupPath := filepath.Join(stateDir, fmt.Sprintf("db-backup-v%d.db", version))
+	tmpPath := backupPath + ".tmp"
+
+	err = os.Remove(tmpPath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	err = writeSQLiteDump(ctx, tmpPath, dump)
+	if err != nil {
+		_ = os.Remove(tmpPath)
+		return err
+	}
+
+	err = os.Rename(tmpPath, backupPath)
+	if err != nil {
+		return err
+	}
+
+	logger.Infof("Database backed up to %q before applying schema extension %d", backupPath, version+1)
+
+	return nil
+}
+
+// writeSQLiteDump creates the SQLite database at path from a SQL text dump.
+func writeSQLiteDump(ctx context.Context, path string, dump string) error {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.ExecContext(ctx, dump)
+	if err != nil {
+		_ = db.Close()
+		return fmt.Errorf("Failed to restore database dump: %w", err)
+	}
+
+	err = db.Close()
+	if err != nil {
+		return err
+	}
+
+	return os.Chmod(path, 0600)
+}
+
+// GetSchemaVersion returns the version of the sunbeam schema extensions applied to the database.
+// MicroCluster records the applied extensions in the schemas table with type 1.
+func GetSchemaVersion(ctx context.Context, tx *sql.Tx) (int, error) {
+	var version int
+
+	err := tx.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM schemas WHERE type = 1`).Scan(&version)
+	if err != nil {
+		return -1, fmt.Errorf("Failed to fetch schema version: %w", err)
+	}
+
+	return version, nil
+}
+
+// addColumn adds column to table with the given definition unless the table has it already,
+// so that the schema extension adding it can be run again.
+func addColumn(ctx context.Context, tx *sql.Tx, table string, column string, definition string) error {
+	var count int
+	err := tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?`, table, column).Scan(&count)
+	if err != nil {
+		return fmt.Errorf("Failed to fetch columns of table %q: %w", table, err)
+	}
+
+	if count > 0 {
+		return nil
+	}
+
+	_, err = tx.ExecContext(ctx, fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	if err != nil {
+		return fmt.Errorf("Failed to add column %q to table %q: %w", column, table, err)
+	}
+
+	return nil
 }
 
 // NodesSchemaUpdate is schema for table nodes
@@ -96,3 +254,367 @@ ALTER TABLE nodes ADD COLUMN system_id TEXT default '';
 
 	return err
 }
+
+// SystemIDUniqueIndexUpdate makes non empty system ids unique in table nodes.
+// A system id assigned to several nodes is kept by the first node created, it is cleared on the others.
+func SystemIDUniqueIndexUpdate(ctx context.Context, tx *sql.Tx) error {
+	_, err := tx.ExecContext(ctx, `UPDATE nodes SET system_id = '' WHERE system_id IS NULL`)
+	if err != nil {
+		return err
+	}
+
+	duplicates := `system_id != '' AND id NOT IN (SELECT MIN(id) FROM nodes WHERE system_id != '' GROUP BY system_id)`
+
+	err = query.Scan(ctx, tx, `SELECT name, system_id FROM nodes WHERE `+duplicates+` ORDER BY id`, func(scan func(dest ...any) error) error {
+		var name, systemID string
+		err := scan(&name, &systemID)
+		if err != nil {
+			return err
+		}
+
+		logger.Warnf("Clearing system id %q of node %q, it is already assigned to another node", systemID, name)
+
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to fetch the nodes with a duplicate system id: %w", err)
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE nodes SET system_id = '' WHERE `+duplicates)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `CREATE UNIQUE INDEX IF NOT EXISTS nodes_system_id ON nodes(system_id) WHERE system_id != ''`)
+
+	return err
+}
+
+// ConfigDescriptionSchemaUpdate adds an optional description to table config
+func ConfigDescriptionSchemaUpdate(ctx context.Context, tx *sql.Tx) error {
+	return addColumn(ctx, tx, "config", "description", "TEXT")
+}
+
+// NodesLastSeenSchemaUpdate adds the last heartbeat time to table nodes
+func NodesLastSeenSchemaUpdate(ctx context.Context, tx *sql.Tx) error {
+	return addColumn(ctx, tx, "nodes", "last_seen_at", "TIMESTAMP")
+}
+
+// ConfigExpiresAtSchemaUpdate adds an optional expiry time to table config
+func ConfigExpiresAtSchemaUpdate(ctx context.Context, tx *sql.Tx) error {
+	return addColumn(ctx, tx, "config", "expires_at", "TIMESTAMP")
+}
+
+// ConfigNamespacePoliciesSchemaUpdate is schema for table config_namespace_policies
+func ConfigNamespacePoliciesSchemaUpdate(_ context.Context, tx *sql.Tx) error {
+	stmt := `
+CREATE TABLE config_namespace_policies (
+  id                            INTEGER  PRIMARY KEY AUTOINCREMENT NOT NULL,
+  namespace                     TEXT     NOT  NULL,
+  default_ttl_seconds           INTEGER,
+  allow_user_ttl                BOOLEAN  NOT  NULL DEFAULT 1,
+  UNIQUE(namespace)
+);
+  `
+
+	_, err := tx.Exec(stmt)
+
+	return err
+}
+
+// JujuUserCreatedAtSchemaUpdate adds the creation time to table jujuuser
+func JujuUserCreatedAtSchemaUpdate(ctx context.Context, tx *sql.Tx) error {
+	return addColumn(ctx, tx, "jujuuser", "created_at", "TIMESTAMP")
+}
+
+// ConfigUpdatedAtSchemaUpdate adds the last modification time to table config
+func ConfigUpdatedAtSchemaUpdate(ctx context.Context, tx *sql.Tx) error {
+	return addColumn(ctx, tx, "config", "updated_at", "TIMESTAMP")
+}
+
+// TerraformStateVersionsSchemaUpdate is schema for table terraform_state_versions
+func TerraformStateVersionsSchemaUpdate(_ context.Context, tx *sql.Tx) error {
+	stmt := `
+CREATE TABLE terraform_state_versions (
+  id                            INTEGER  PRIMARY KEY AUTOINCREMENT NOT NULL,
+  name                          TEXT     NOT  NULL,
+  version                       INTEGER  NOT  NULL,
+  state                         TEXT     NOT  NULL,
+  applied_at                    TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+  UNIQUE(name, version)
+);
+  `
+
+	_, err := tx.Exec(stmt)
+
+	return err
+}
+
+// TerraformLockAuditSchemaUpdate is schema for table terraform_lock_audit
+func TerraformLockAuditSchemaUpdate(_ context.Context, tx *sql.Tx) error {
+	stmt := `
+CREATE TABLE terraform_lock_audit (
+  id                            INTEGER  PRIMARY KEY AUTOINCREMENT NOT NULL,
+  name                          TEXT     NOT  NULL,
+  action                        TEXT     NOT  NULL,
+  lock_id                       TEXT,
+  lock_who                      TEXT,
+  caller                        TEXT,
+  member                        TEXT,
+  created_at                    TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+  `
+
+	_, err := tx.Exec(stmt)
+
+	return err
+}
+
+// ConfigChecksumSchemaUpdate adds an optional checksum of the value to table config
+func ConfigChecksumSchemaUpdate(ctx context.Context, tx *sql.Tx) error {
+	return addColumn(ctx, tx, "config", "checksum", "TEXT")
+}
+
+// ConfigHistorySchemaUpdate is schema update for table config_history
+func ConfigHistorySchemaUpdate(_ context.Context, tx *sql.Tx) error {
+	stmt := `
+CREATE TABLE config_history (
+  id                            INTEGER  PRIMARY KEY AUTOINCREMENT NOT NULL,
+  key                           TEXT     NOT  NULL,
+  old_value                     TEXT,
+  new_value                     TEXT,
+  changed_by                    TEXT     NOT  NULL,
+  changed_at                    DATETIME NOT  NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX config_history_key_changed_at ON config_history (key, changed_at);
+CREATE INDEX config_history_changed_at ON config_history (changed_at);
+  `
+
+	_, err := tx.Exec(stmt)
+
+	return err
+}
+
+// ConfigSchemaSchemaUpdate is schema update for table config_schema
+// along with the declared types of the well known config keys
+func ConfigSchemaSchemaUpdate(_ context.Context, tx *sql.Tx) error {
+	stmt := `
+CREATE TABLE config_schema (
+  id                            INTEGER  PRIMARY KEY AUTOINCREMENT NOT NULL,
+  key                           TEXT     NOT  NULL,
+  type                          TEXT     NOT  NULL,
+  regex_constraint              TEXT,
+  UNIQUE(key)
+);
+
+INSERT INTO config_schema (key, type, regex_constraint) VALUES
+  ('deployment.type', 'enum', '^(local|maas)$'),
+  ('config.terraform-state-max-bytes', 'positive-integer', NULL),
+  ('config.terraform-state-versions', 'positive-integer', NULL),
+  ('config.history-retention-days', 'positive-integer', NULL),
+  ('terraform.lock.warn-threshold-minutes', 'positive-integer', NULL);
+  `
+
+	_, err := tx.Exec(stmt)
+
+	return err
+}
+
+// NodeLabelsSchemaUpdate is schema update for table node_labels
+func NodeLabelsSchemaUpdate(_ context.Context, tx *sql.Tx) error {
+	stmt := `
+CREATE TABLE node_labels (
+  id                            INTEGER  PRIMARY KEY AUTOINCREMENT NOT NULL,
+  node_id                       INTEGER  NOT  NULL,
+  key                           TEXT     NOT  NULL,
+  value                         TEXT     NOT  NULL,
+  FOREIGN KEY (node_id) REFERENCES "nodes" (id) ON DELETE CASCADE
+  UNIQUE(node_id, key)
+);
+  `
+
+	_, err := tx.Exec(stmt)
+
+	return err
+}
+
+// NodesMaintenanceModeSchemaUpdate adds the maintenance mode flag to table nodes
+func NodesMaintenanceModeSchemaUpdate(ctx context.Context, tx *sql.Tx) error {
+	return addColumn(ctx, tx, "nodes", "maintenance_mode", "BOOLEAN NOT NULL DEFAULT 0")
+}
+
+// NodeCapacitySchemaUpdate is schema update for table node_capacity
+func NodeCapacitySchemaUpdate(_ context.Context, tx *sql.Tx) error {
+	stmt := `
+CREATE TABLE node_capacity (
+  id                            INTEGER  PRIMARY KEY AUTOINCREMENT NOT NULL,
+  node_id                       INTEGER  NOT  NULL,
+  key                           TEXT     NOT  NULL,
+  value                         TEXT     NOT  NULL,
+  updated_at                    DATETIME NOT  NULL DEFAULT CURRENT_TIMESTAMP,
+  FOREIGN KEY (node_id) REFERENCES "nodes" (id) ON DELETE CASCADE
+  UNIQUE(node_id, key)
+);
+  `
+
+	_, err := tx.Exec(stmt)
+
+	return err
+}
+
+// JujuTokenHistorySchemaUpdate is schema update for table juju_token_history
+func JujuTokenHistorySchemaUpdate(_ context.Context, tx *sql.Tx) error {
+	stmt := `
+CREATE TABLE juju_token_history (
+  id                            INTEGER  PRIMARY KEY AUTOINCREMENT NOT NULL,
+  username                      TEXT     NOT  NULL,
+  token                         TEXT     NOT  NULL,
+  revoked_at                    DATETIME NOT  NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX juju_token_history_username ON juju_token_history (username);
+  `
+
+	_, err := tx.Exec(stmt)
+
+	return err
+}
+
+// JujuUserExpiresAtSchemaUpdate adds the token expiry time to table jujuuser
+// and declares the type of the token TTL config key
+func JujuUserExpiresAtSchemaUpdate(ctx context.Context, tx *sql.Tx) error {
+	err := addColumn(ctx, tx, "jujuuser", "expires_at", "DATETIME")
+	if err != nil {
+		return err
+	}
+
+	stmt := `
+INSERT INTO config_schema (key, type, regex_constraint) VALUES
+  ('config.juju-token-ttl', 'duration', NULL)
+  ON CONFLICT(key) DO NOTHING;
+  `
+
+	_, err = tx.ExecContext(ctx, stmt)
+
+	return err
+}
+
+// JujuGroupsSchemaUpdate is schema update for tables juju_groups and juju_user_groups
+// and declares the type of the juju group access config key
+func JujuGroupsSchemaUpdate(_ context.Context, tx *sql.Tx) error {
+	stmt := `
+CREATE TABLE juju_groups (
+  id                            INTEGER  PRIMARY KEY AUTOINCREMENT NOT NULL,
+  group_name                    TEXT     NOT  NULL,
+  permissions                   TEXT     NOT  NULL,
+  UNIQUE(group_name)
+);
+
+CREATE TABLE juju_user_groups (
+  id                            INTEGER  PRIMARY KEY AUTOINCREMENT NOT NULL,
+  username                      TEXT     NOT  NULL,
+  group_name                    TEXT     NOT  NULL,
+  FOREIGN KEY (username) REFERENCES "jujuuser" (username) ON DELETE CASCADE,
+  FOREIGN KEY (group_name) REFERENCES "juju_groups" (group_name) ON DELETE CASCADE,
+  UNIQUE(username, group_name)
+);
+
+INSERT INTO juju_groups (group_name, permissions) VALUES
+  ('admin', '["*"]'),
+  ('readonly', '["GET"]');
+
+INSERT INTO config_schema (key, type, regex_constraint) VALUES
+  ('cluster.juju-group-access', 'boolean', NULL)
+  ON CONFLICT(key) DO NOTHING;
+  `
+
+	_, err := tx.Exec(stmt)
+
+	return err
+}
+
+// JujuAuditSchemaUpdate is schema update for table juju_audit
+func JujuAuditSchemaUpdate(_ context.Context, tx *sql.Tx) error {
+	stmt := `
+CREATE TABLE juju_audit (
+  id                            INTEGER  PRIMARY KEY AUTOINCREMENT NOT NULL,
+  username                      TEXT     NOT  NULL,
+  action                        TEXT     NOT  NULL,
+  source_ip                     TEXT     NOT  NULL,
+  user_agent                    TEXT     NOT  NULL,
+  occurred_at                   DATETIME NOT  NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX juju_audit_username_occurred_at ON juju_audit (username, occurred_at);
+CREATE INDEX juju_audit_occurred_at ON juju_audit (occurred_at);
+  `
+
+	_, err := tx.Exec(stmt)
+
+	return err
+}
+
+// ManifestStatusSchemaUpdate adds the application status and error to table manifest
+func ManifestStatusSchemaUpdate(ctx context.Context, tx *sql.Tx) error {
+	err := addColumn(ctx, tx, "manifest", "status", "TEXT NOT NULL DEFAULT 'applied'")
+	if err != nil {
+		return err
+	}
+
+	return addColumn(ctx, tx, "manifest", "error", "TEXT")
+}
+
+// ManifestRollbackDataSchemaUpdate adds the data reverting a manifest to table manifest
+func ManifestRollbackDataSchemaUpdate(ctx context.Context, tx *sql.Tx) error {
+	return addColumn(ctx, tx, "manifest", "rollback_data", "TEXT")
+}
+
+// ManifestCompressedSchemaUpdate adds the data compression flag to table manifest
+// and compresses the data of the existing manifests
+func ManifestCompressedSchemaUpdate(ctx context.Context, tx *sql.Tx) error {
+	err := addColumn(ctx, tx, "manifest", "compressed", "BOOLEAN NOT NULL DEFAULT 0")
+	if err != nil {
+		return err
+	}
+
+	return compressManifestItems(ctx, tx)
+}
+
+// ManifestSignatureSchemaUpdate adds the verified signature to table manifest
+// and declares the type of the signature requirement config key
+func ManifestSignatureSchemaUpdate(ctx context.Context, tx *sql.Tx) error {
+	err := addColumn(ctx, tx, "manifest", "signature", "TEXT")
+	if err != nil {
+		return err
+	}
+
+	stmt := `
+INSERT INTO config_schema (key, type, regex_constraint) VALUES
+  ('cluster.manifest-require-signature', 'boolean', NULL)
+  ON CONFLICT(key) DO NOTHING;
+  `
+
+	_, err = tx.ExecContext(ctx, stmt)
+
+	return err
+}
+
+// NodeDecommissionAuditSchemaUpdate is schema for table node_decommission_audit
+func NodeDecommissionAuditSchemaUpdate(_ context.Context, tx *sql.Tx) error {
+	stmt := `
+CREATE TABLE node_decommission_audit (
+  id                            INTEGER  PRIMARY KEY AUTOINCREMENT NOT NULL,
+  name                          TEXT     NOT  NULL,
+  action                        TEXT     NOT  NULL,
+  cluster_member                TEXT,
+  node                          TEXT,
+  member                        TEXT,
+  created_at                    TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+  `
+
+	_, err := tx.Exec(stmt)
+
+	return err
+}
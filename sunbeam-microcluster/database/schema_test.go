@@ -0,0 +1,250 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/canonical/lxd/lxd/db/query"
+)
+
+// newTestTx returns a transaction on an in-memory SQLite database created with stmt.
+func newTestTx(t *testing.T, stmt string) *sql.Tx {
+	t.Helper()
+
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Each connection to :memory: is a distinct database.
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { _ = db.Close() })
+
+	_, err = db.Exec(stmt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { _ = tx.Rollback() })
+
+	return tx
+}
+
+func TestSchemaExtensions(t *testing.T) {
+	NewTestSchemaTx(t)
+}
+
+// resetPreMigrationBackup points the pre-migration backups to a temporary directory for a new daemon run.
+func resetPreMigrationBackup(t *testing.T) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	StateDir = dir
+	SkipPreMigrationBackup = false
+	preMigrationBackupChecked.Store(false)
+
+	t.Cleanup(func() {
+		StateDir = ""
+		preMigrationBackupChecked.Store(false)
+	})
+
+	return dir
+}
+
+func noopUpdate(context.Context, *sql.Tx) error { return nil }
+
+func TestSafeSchemaUpdateBacksUpOnceOnUpgrade(t *testing.T) {
+	dir := resetPreMigrationBackup(t)
+	tx := newTestTx(t, `
+CREATE TABLE items (id INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL, name TEXT NOT NULL);
+INSERT INTO items (name) VALUES ('a'), ('b');
+`)
+
+	for _, version := range []int{3, 4, 5} {
+		err := SafeSchemaUpdate(version, noopUpdate)(context.Background(), tx)
+		if err != nil {
+			t.Fatalf("Schema extension %d failed: %v", version, err)
+		}
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(entries) != 1 || entries[0].Name() != "db-backup-v2.db" {
+		t.Fatalf("Expected only db-backup-v2.db in the state directory, got %v", entries)
+	}
+
+	backup, err := sql.Open("sqlite3", filepath.Join(dir, "db-backup-v2.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() { _ = backup.Close() }()
+
+	var count int
+	err = backup.QueryRow(`SELECT COUNT(*) FROM items`).Scan(&count)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if count != 2 {
+		t.Fatalf("Expected 2 rows in the backup, got %d", count)
+	}
+}
+
+func TestSafeSchemaUpdateSkipsBackupOnBootstrap(t *testing.T) {
+	dir := resetPreMigrationBackup(t)
+	tx := newTestTx(t, `CREATE TABLE items (id INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL);`)
+
+	for _, version := range []int{1, 2} {
+		err := SafeSchemaUpdate(version, noopUpdate)(context.Background(), tx)
+		if err != nil {
+			t.Fatalf("Schema extension %d failed: %v", version, err)
+		}
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(entries) != 0 {
+		t.Fatalf("Expected no backup on bootstrap, got %v", entries)
+	}
+}
+
+func TestSafeSchemaUpdateSkipPreMigrationBackup(t *testing.T) {
+	dir := resetPreMigrationBackup(t)
+	SkipPreMigrationBackup = true
+	t.Cleanup(func() { SkipPreMigrationBackup = false })
+
+	tx := newTestTx(t, `CREATE TABLE items (id INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL);`)
+
+	err := SafeSchemaUpdate(3, noopUpdate)(context.Background(), tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(entries) != 0 {
+		t.Fatalf("Expected no backup when skipped, got %v", entries)
+	}
+}
+
+func TestAddColumn(t *testing.T) {
+	tx := newTestTx(t, `CREATE TABLE items (id INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL);`)
+
+	// Adding the column again leaves the table as is.
+	for i := 0; i < 2; i++ {
+		err := addColumn(context.Background(), tx, "items", "name", "TEXT NOT NULL DEFAULT 'none'")
+		if err != nil {
+			t.Fatalf("Adding column, attempt %d: %v", i+1, err)
+		}
+	}
+
+	_, err := tx.Exec(`INSERT INTO items (id) VALUES (1)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var name string
+	err = tx.QueryRow(`SELECT name FROM items WHERE id = 1`).Scan(&name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if name != "none" {
+		t.Fatalf("Expected the column default, got %q", name)
+	}
+}
+
+func TestAddColumnMissingTable(t *testing.T) {
+	tx := newTestTx(t, `CREATE TABLE items (id INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL);`)
+
+	err := addColumn(context.Background(), tx, "missing", "name", "TEXT")
+	if err == nil {
+		t.Fatal("Expected an error adding a column to a missing table")
+	}
+}
+
+func TestSystemIDUniqueIndexUpdateDuplicates(t *testing.T) {
+	tx := newTestTx(t, testClusterSchema)
+	ctx := context.Background()
+
+	preMigrationBackupChecked.Store(true)
+	t.Cleanup(func() { preMigrationBackupChecked.Store(false) })
+
+	// Apply the schema extensions up to AddSystemIDToNodes.
+	for i, update := range SchemaExtensions[:5] {
+		err := update(ctx, tx)
+		if err != nil {
+			t.Fatalf("Schema extension %d failed: %v", i+1, err)
+		}
+	}
+
+	_, err := tx.Exec(`
+INSERT INTO internal_cluster_members (id, name, address, certificate, schema_internal, schema_external, heartbeat, role)
+  VALUES (1, 'member', 'member', 'member', 1, 1, '2024-01-01 00:00:00', 'voter');
+INSERT INTO nodes (id, member_id, name, system_id) VALUES
+  (1, 1, 'node-1', 'abc'),
+  (2, 1, 'node-2', 'abc'),
+  (3, 1, 'node-3', 'def'),
+  (4, 1, 'node-4', 'abc'),
+  (5, 1, 'node-5', ''),
+  (6, 1, 'node-6', ''),
+  (7, 1, 'node-7', NULL);
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = SystemIDUniqueIndexUpdate(ctx, tx)
+	if err != nil {
+		t.Fatalf("Migration with duplicate system ids failed: %v", err)
+	}
+
+	want := map[string]string{
+		"node-1": "abc",
+		"node-2": "",
+		"node-3": "def",
+		"node-4": "",
+		"node-5": "",
+		"node-6": "",
+		"node-7": "",
+	}
+
+	got := map[string]string{}
+	err = query.Scan(ctx, tx, `SELECT name, system_id FROM nodes`, func(scan func(dest ...any) error) error {
+		var name, systemID string
+		err := scan(&name, &systemID)
+		got[name] = systemID
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Migration left the system ids %v, want %v", got, want)
+	}
+
+	_, err = tx.Exec(`INSERT INTO nodes (member_id, name, system_id) VALUES (1, 'node-8', 'def')`)
+	if err == nil {
+		t.Error("Duplicate system id was accepted after the migration")
+	}
+}
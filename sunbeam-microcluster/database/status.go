@@ -0,0 +1,37 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+
+	"github.com/canonical/lxd/lxd/db/query"
+	"github.com/canonical/lxd/shared/api"
+)
+
+// CountClusterMembers returns the number of MicroCluster cluster members.
+func CountClusterMembers(ctx context.Context, tx *sql.Tx) (int, error) {
+	count, err := query.Count(ctx, tx, "internal_cluster_members", "")
+	if err != nil {
+		return -1, fmt.Errorf("Failed to count \"internal_cluster_members\" rows: %w", err)
+	}
+
+	return count, nil
+}
+
+// GetClusterMemberAddress returns the address of the MicroCluster cluster member with the given name.
+func GetClusterMemberAddress(ctx context.Context, tx *sql.Tx, name string) (string, error) {
+	var address string
+
+	err := tx.QueryRowContext(ctx, `SELECT address FROM internal_cluster_members WHERE name = ?`, name).Scan(&address)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", api.StatusErrorf(http.StatusNotFound, "Cluster member not found")
+		}
+
+		return "", fmt.Errorf("Failed to fetch from \"internal_cluster_members\" table: %w", err)
+	}
+
+	return address, nil
+}
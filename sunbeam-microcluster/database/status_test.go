@@ -0,0 +1,37 @@
+package database
+
+import (
+	"context"
+	"testing"
+)
+
+func TestCountClusterMembers(t *testing.T) {
+	tx := NewTestSchemaTx(t)
+	ctx := context.Background()
+
+	count, err := CountClusterMembers(ctx, tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if count != 0 {
+		t.Errorf("Counted %d cluster members in an empty cluster, want 0", count)
+	}
+
+	for _, name := range []string{"member-1", "member-2", "member-3"} {
+		_, err := tx.Exec(`INSERT INTO internal_cluster_members (name, address, certificate, schema_internal, schema_external, heartbeat, role)
+  VALUES (?, ?, ?, 1, 1, '2024-01-01 00:00:00', 'voter')`, name, name, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	count, err = CountClusterMembers(ctx, tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if count != 3 {
+		t.Errorf("Counted %d cluster members, want 3", count)
+	}
+}
@@ -0,0 +1,121 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+
+	"github.com/canonical/lxd/lxd/db/query"
+	"github.com/canonical/lxd/shared/api"
+)
+
+// TerraformStateVersion is a terraform state as it was written at a given version.
+type TerraformStateVersion struct {
+	Name      string
+	Version   int
+	State     string
+	AppliedAt string
+}
+
+// CreateTerraformStateVersion records state as the next version of the terraform state name
+// and returns the version number.
+func CreateTerraformStateVersion(ctx context.Context, tx *sql.Tx, name string, state string) (int, error) {
+	var version int
+
+	err := tx.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) + 1 FROM terraform_state_versions WHERE name = ?`, name).Scan(&version)
+	if err != nil {
+		return -1, fmt.Errorf("Failed to fetch next \"terraform_state_versions\" version: %w", err)
+	}
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO terraform_state_versions (name, version, state) VALUES (?, ?, ?)`, name, version, state)
+	if err != nil {
+		return -1, fmt.Errorf("Failed to create \"terraform_state_versions\" entry: %w", err)
+	}
+
+	return version, nil
+}
+
+// GetTerraformStateVersions returns the versions of the terraform state name, newest first.
+// The state of each version is not part of the result.
+func GetTerraformStateVersions(ctx context.Context, tx *sql.Tx, name string) ([]TerraformStateVersion, error) {
+	stmt := `SELECT name, version, applied_at FROM terraform_state_versions WHERE name = ? ORDER BY version DESC`
+
+	versions := make([]TerraformStateVersion, 0)
+
+	dest := func(scan func(dest ...any) error) error {
+		v := TerraformStateVersion{}
+		err := scan(&v.Name, &v.Version, &v.AppliedAt)
+		if err != nil {
+			return err
+		}
+
+		versions = append(versions, v)
+
+		return nil
+	}
+
+	err := query.Scan(ctx, tx, stmt, dest, name)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to fetch from \"terraform_state_versions\" table: %w", err)
+	}
+
+	return versions, nil
+}
+
+// GetTerraformStateVersion returns the given version of the terraform state name.
+func GetTerraformStateVersion(ctx context.Context, tx *sql.Tx, name string, version int) (*TerraformStateVersion, error) {
+	v := TerraformStateVersion{Name: name, Version: version}
+
+	err := tx.QueryRowContext(ctx, `SELECT state, applied_at FROM terraform_state_versions WHERE name = ? AND version = ?`, name, version).Scan(&v.State, &v.AppliedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, api.StatusErrorf(http.StatusNotFound, "TerraformStateVersion not found")
+		}
+
+		return nil, fmt.Errorf("Failed to fetch from \"terraform_state_versions\" table: %w", err)
+	}
+
+	return &v, nil
+}
+
+// DeleteTerraformStateVersions deletes all the versions of the terraform state name.
+func DeleteTerraformStateVersions(ctx context.Context, tx *sql.Tx, name string) error {
+	_, err := tx.ExecContext(ctx, `DELETE FROM terraform_state_versions WHERE name = ?`, name)
+	if err != nil {
+		return fmt.Errorf("Delete \"terraform_state_versions\": %w", err)
+	}
+
+	return nil
+}
+
+// DeleteTerraformStateVersionsBefore deletes the versions of the terraform state name older than version.
+func DeleteTerraformStateVersionsBefore(ctx context.Context, tx *sql.Tx, name string, version int) error {
+	_, err := tx.ExecContext(ctx, `DELETE FROM terraform_state_versions WHERE name = ? AND version < ?`, name, version)
+	if err != nil {
+		return fmt.Errorf("Delete \"terraform_state_versions\": %w", err)
+	}
+
+	return nil
+}
+
+// TerraformLockAuditEntry records an action taken on a terraform lock.
+type TerraformLockAuditEntry struct {
+	Name    string
+	Action  string
+	LockID  string
+	LockWho string
+	Caller  string
+	Member  string
+}
+
+// CreateTerraformLockAuditEntry adds an entry to the terraform lock audit log.
+func CreateTerraformLockAuditEntry(ctx context.Context, tx *sql.Tx, entry TerraformLockAuditEntry) error {
+	_, err := tx.ExecContext(ctx, `INSERT INTO terraform_lock_audit (name, action, lock_id, lock_who, caller, member) VALUES (?, ?, ?, ?, ?, ?)`,
+		entry.Name, entry.Action, entry.LockID, entry.LockWho, entry.Caller, entry.Member)
+	if err != nil {
+		return fmt.Errorf("Failed to create \"terraform_lock_audit\" entry: %w", err)
+	}
+
+	return nil
+}
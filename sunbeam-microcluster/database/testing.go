@@ -0,0 +1,92 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/canonical/microcluster/cluster"
+)
+
+// testClusterSchema is the part of the MicroCluster schema the schema extensions depend on.
+const testClusterSchema = `
+CREATE TABLE internal_cluster_members (
+  id                   INTEGER   PRIMARY  KEY    AUTOINCREMENT  NOT  NULL,
+  name                 TEXT      NOT      NULL,
+  address              TEXT      NOT      NULL,
+  certificate          TEXT      NOT      NULL,
+  schema_internal      INTEGER   NOT      NULL,
+  schema_external      INTEGER   NOT      NULL,
+  heartbeat            DATETIME  NOT      NULL,
+  role                 TEXT      NOT      NULL,
+  UNIQUE(name),
+  UNIQUE(certificate)
+);
+
+CREATE TABLE schemas (
+  id         INTEGER    PRIMARY KEY AUTOINCREMENT NOT NULL,
+  version    INTEGER    NOT NULL,
+  type       INTEGER    NOT NULL,
+  updated_at DATETIME   NOT NULL,
+  UNIQUE (version, type)
+);
+`
+
+// NewTestSchemaTx returns a transaction on an in-memory SQLite database with all the schema
+// extensions applied, for the tests of this package and of the packages using it.
+// The statements of the generated mappers are prepared on the database.
+func NewTestSchemaTx(t testing.TB) *sql.Tx {
+	t.Helper()
+
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Each connection to :memory: is a distinct database.
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { _ = db.Close() })
+
+	_, err = db.Exec(testClusterSchema)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The database is created on bootstrap, there is nothing to back up.
+	preMigrationBackupChecked.Store(true)
+	t.Cleanup(func() { preMigrationBackupChecked.Store(false) })
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, update := range SchemaExtensions {
+		err := update(context.Background(), tx)
+		if err != nil {
+			_ = tx.Rollback()
+			t.Fatalf("Schema extension %d failed: %v", i+1, err)
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The MicroCluster statements fail to prepare as only the part of its schema
+	// the schema extensions depend on is created.
+	err = cluster.PrepareStmts(db, "", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tx, err = db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { _ = tx.Rollback() })
+
+	return tx
+}
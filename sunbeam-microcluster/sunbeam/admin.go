@@ -0,0 +1,38 @@
+package sunbeam
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/canonical/microcluster/state"
+
+	"github.com/canonical/snap-openstack/sunbeam-microcluster/api/types"
+	"github.com/canonical/snap-openstack/sunbeam-microcluster/database"
+)
+
+// GetDatabaseTableSizes returns the row count and estimated size of each database table
+func GetDatabaseTableSizes(s *state.State) (map[string]types.TableStats, error) {
+	stats := map[string]types.TableStats{}
+
+	err := s.Database.Transaction(s.Context, func(ctx context.Context, tx *sql.Tx) error {
+		sizes, err := database.GetTableSizes(ctx, tx)
+		if err != nil {
+			return fmt.Errorf("Failed to fetch table sizes: %w", err)
+		}
+
+		for table, size := range sizes {
+			stats[table] = types.TableStats{
+				RowCount:       size.RowCount,
+				EstimatedBytes: size.EstimatedBytes,
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return stats, nil
+}
@@ -4,32 +4,77 @@ package sunbeam
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"fmt"
+	"net/http"
+	"slices"
+	"sort"
 	"strings"
+	"time"
 
+	"github.com/canonical/lxd/shared/api"
 	"github.com/canonical/microcluster/state"
 
+	"github.com/canonical/snap-openstack/sunbeam-microcluster/api/types"
 	"github.com/canonical/snap-openstack/sunbeam-microcluster/database"
 )
 
-// GetConfig returns the ConfigItem based on key from the database
-func GetConfig(s *state.State, key string) (string, error) {
+// GetConfig returns the value of the ConfigItem based on key from the database
+// and whether the key exists, so that a missing key can be told apart from an empty value.
+// An expired key does not exist.
+func GetConfig(s *state.State, key string) (string, bool, error) {
+	var value *string
+
+	err := s.Database.Transaction(s.Context, func(ctx context.Context, tx *sql.Tx) error {
+		var err error
+		value, err = configValue(ctx, tx, key)
+		return err
+	})
+	if err != nil {
+		return "", false, err
+	}
+
+	if value == nil {
+		return "", false, nil
+	}
+
+	return *value, true, nil
+}
+
+// GetConfigWithExpiry returns the value of the ConfigItem based on key from the database and the time
+// it expires at, nil if it never expires. A 410 error is returned if it has expired but not been deleted yet.
+func GetConfigWithExpiry(s *state.State, key string) (string, *time.Time, error) {
 	var value string
+	var expiresAt *time.Time
 
 	err := s.Database.Transaction(s.Context, func(ctx context.Context, tx *sql.Tx) error {
 		record, err := database.GetConfigItem(ctx, tx, key)
 		if err != nil {
 			return err
 		}
+
+		expiresAt, err = database.GetConfigItemExpiry(ctx, tx, key)
+		if err != nil {
+			return err
+		}
+
+		expired, err := database.ConfigItemExpired(ctx, tx, key)
+		if err != nil {
+			return err
+		}
+
+		if expired {
+			return api.StatusErrorf(http.StatusGone, "ConfigItem has expired")
+		}
+
 		value = record.Value
 		return nil
 	})
-
 	if err != nil {
-		return "", err
+		return "", nil, err
 	}
 
-	return value, nil
+	return value, expiresAt, nil
 }
 
 // GetConfigItemKeys returns the list of ConfigItem keys from the database
@@ -52,38 +97,645 @@ func GetConfigItemKeys(s *state.State, prefix *string) ([]string, error) {
 	return keys, nil
 }
 
+// GetConfigItemKeysOrdered returns the list of ConfigItem keys from the database sorted by orderBy
+func GetConfigItemKeysOrdered(s *state.State, prefix *string, orderBy string) ([]string, error) {
+	var keys []string
+
+	err := s.Database.Transaction(s.Context, func(ctx context.Context, tx *sql.Tx) error {
+		var err error
+		keys, err = database.GetConfigItemKeysOrdered(ctx, tx, prefix, orderBy)
+		return err
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+// ListConfigKeys returns the sorted keys of the ConfigItems starting with prefix
+func ListConfigKeys(s *state.State, prefix string) ([]string, error) {
+	var keys []string
+
+	err := s.Database.Transaction(s.Context, func(ctx context.Context, tx *sql.Tx) error {
+		var err error
+		keys, _, err = database.GetConfigItemKeysPage(ctx, tx, database.ConfigKeyFilter{Prefix: prefix}, "config.key", 0, 0)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+// ListConfigEntriesWithPrefix returns the ConfigItems starting with prefix along with their description,
+// except the terraform states and locks.
+func ListConfigEntriesWithPrefix(s *state.State, prefix string) (types.ConfigEntries, error) {
+	var entries types.ConfigEntries
+
+	err := s.Database.Transaction(s.Context, func(ctx context.Context, tx *sql.Tx) error {
+		records, err := database.GetConfigEntriesWithPrefix(ctx, tx, prefix, terraformKeyPrefixes...)
+		if err != nil {
+			return err
+		}
+
+		entries = configEntries(records)
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+// configEntries converts ConfigEntry records from the database to API types
+func configEntries(records []database.ConfigEntry) types.ConfigEntries {
+	entries := types.ConfigEntries{}
+	for _, record := range records {
+		entries = append(entries, types.ConfigEntry{
+			Key:         record.Key,
+			Value:       record.Value,
+			Description: record.Description,
+		})
+	}
+
+	return entries
+}
+
+// ListConfigEntries returns all the config keys with their value and description,
+// except the terraform states and locks.
+// If modifiedSince is not nil, only the keys modified at or after it are returned.
+func ListConfigEntries(s *state.State, modifiedSince *time.Time) (types.ConfigEntries, error) {
+	entries := types.ConfigEntries{}
+
+	err := s.Database.Transaction(s.Context, func(ctx context.Context, tx *sql.Tx) error {
+		var records []database.ConfigEntry
+		var err error
+		if modifiedSince != nil {
+			records, err = database.GetConfigEntriesModifiedSince(ctx, tx, *modifiedSince, terraformKeyPrefixes...)
+		} else {
+			records, err = database.GetConfigEntries(ctx, tx, terraformKeyPrefixes...)
+		}
+
+		if err != nil {
+			return err
+		}
+
+		entries = configEntries(records)
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+// SetConfigDescription sets the description of an existing config key
+func SetConfigDescription(s *state.State, key string, description string) error {
+	return s.Database.Transaction(s.Context, func(ctx context.Context, tx *sql.Tx) error {
+		return database.UpdateConfigItemDescription(ctx, tx, key, description)
+	})
+}
+
 // CreateConfig adds a new ConfigItem to the database
 func CreateConfig(s *state.State, key string, value string) error {
+	err := s.Database.Transaction(s.Context, func(ctx context.Context, tx *sql.Tx) error {
+		err := checkReservedConfigKey(ctx, key)
+		if err != nil {
+			return err
+		}
 
-	return s.Database.Transaction(s.Context, func(ctx context.Context, tx *sql.Tx) error {
-		_, err := database.CreateConfigItem(ctx, tx, database.ConfigItem{Key: key, Value: value})
+		err = validateConfigValue(ctx, tx, key, value)
+		if err != nil {
+			return err
+		}
+
+		_, err = database.CreateConfigItem(ctx, tx, database.ConfigItem{Key: key, Value: value})
 		if err != nil {
 			return fmt.Errorf("Failed to record config item: %w", err)
 		}
-		return nil
+
+		err = database.MarkConfigItemModified(ctx, tx, key)
+		if err != nil {
+			return err
+		}
+
+		return recordConfigChange(ctx, tx, s.Name(), key, nil, &value)
 	})
+	if err != nil {
+		return err
+	}
+
+	notifyConfigChange(key, value, ConfigEventSet)
+
+	return nil
 }
 
 // UpdateConfig updates a ConfigItem in the database
 func UpdateConfig(s *state.State, key string, value string) error {
-	configItem := database.ConfigItem{Key: key, Value: value}
+	return UpdateConfigWithExpiry(s, key, value, nil)
+}
 
-	return s.Database.Transaction(s.Context, func(ctx context.Context, tx *sql.Tx) error {
-		err := database.UpdateConfigItem(ctx, tx, key, configItem)
-		if err != nil && strings.Contains(err.Error(), "ConfigItem not found") {
-			_, err = database.CreateConfigItem(ctx, tx, configItem)
+// MaxBulkConfigKeys is the maximum number of keys UpdateConfigs writes at once
+const MaxBulkConfigKeys = 1000
+
+// UpdateConfigs writes all the given ConfigItems in a single transaction and returns the
+// result for each key, sorted by key. If any key fails validation none of them are written.
+func UpdateConfigs(s *state.State, configs map[string]string) (types.ConfigBulkResults, error) {
+	if len(configs) > MaxBulkConfigKeys {
+		return nil, api.StatusErrorf(http.StatusBadRequest, "Too many config keys, at most %d can be written at once", MaxBulkConfigKeys)
+	}
+
+	keys := make([]string, 0, len(configs))
+	for key := range configs {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	results := make(types.ConfigBulkResults, 0, len(keys))
+	valid := true
+	for _, key := range keys {
+		result := types.ConfigBulkResult{Key: key}
+		err := validateConfigKey(key)
+		if err == nil {
+			err = checkReservedConfigKey(s.Context, key)
 		}
+
 		if err != nil {
-			return fmt.Errorf("Failed to record config item: %w", err)
+			result.Error = err.Error()
+			valid = false
+		}
+
+		results = append(results, result)
+	}
+
+	if !valid {
+		return results, nil
+	}
+
+	err := s.Database.Transaction(s.Context, func(ctx context.Context, tx *sql.Tx) error {
+		// Check all the values first so that nothing is written if any is invalid
+		for i, key := range keys {
+			err := validateConfigValue(ctx, tx, key, configs[key])
+			if err != nil {
+				if !api.StatusErrorCheck(err, http.StatusBadRequest) {
+					return err
+				}
+
+				results[i].Error = err.Error()
+				valid = false
+			}
+		}
+
+		if !valid {
+			return nil
+		}
+
+		for _, key := range keys {
+			err := setConfigItem(ctx, tx, s.Name(), key, configs[key], nil)
+			if err != nil {
+				return fmt.Errorf("Failed to write config key %q: %w", key, err)
+			}
 		}
 
 		return nil
 	})
+	if err != nil {
+		return nil, err
+	}
+
+	if !valid {
+		return results, nil
+	}
+
+	for i := range results {
+		results[i].Written = true
+		notifyConfigChange(results[i].Key, configs[results[i].Key], ConfigEventSet)
+	}
+
+	return results, nil
+}
+
+// configRouteKeys are the config key names taken by the endpoints under /1.0/config,
+// a ConfigItem with one of these keys could not be read back through /1.0/config/<name>.
+var configRouteKeys = []string{"bulk", "history", "watch"}
+
+// validateConfigKey returns an error if key cannot be written as a plain ConfigItem.
+// Terraform states and locks are only written through their own endpoints.
+func validateConfigKey(key string) error {
+	if key == "" {
+		return fmt.Errorf("Config key cannot be empty")
+	}
+
+	if slices.Contains(configRouteKeys, key) {
+		return fmt.Errorf("Config key %q is reserved for the /1.0/config/%s endpoint", key, key)
+	}
+
+	if isTerraformKey(key) {
+		return fmt.Errorf("Config key %q is reserved for terraform", key)
+	}
+
+	return nil
+}
+
+// isTerraformKey returns whether key holds a terraform state or lock
+func isTerraformKey(key string) bool {
+	return strings.HasPrefix(key, tfstatePrefix) || strings.HasPrefix(key, tflockPrefix)
+}
+
+// UpdateConfigWithExpiry updates a ConfigItem in the database and sets the time it expires at.
+// A nil expiresAt means the ConfigItem never expires, unless the policy of its namespace has a default TTL.
+func UpdateConfigWithExpiry(s *state.State, key string, value string, expiresAt *time.Time) error {
+	err := s.Database.Transaction(s.Context, func(ctx context.Context, tx *sql.Tx) error {
+		return setConfigItem(ctx, tx, s.Name(), key, value, expiresAt)
+	})
+	if err != nil {
+		return err
+	}
+
+	notifyConfigChange(key, value, ConfigEventSet)
+
+	return nil
+}
+
+// SeedConfig writes the given ConfigItems in a single transaction with an admin override,
+// so that the bootstrap path can set the initial value of reserved keys.
+func SeedConfig(s *state.State, configs map[string]string) error {
+	keys := make([]string, 0, len(configs))
+	for key := range configs {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	err := s.Database.Transaction(WithAdminOverride(s.Context), func(ctx context.Context, tx *sql.Tx) error {
+		for _, key := range keys {
+			err := setConfigItem(ctx, tx, s.Name(), key, configs[key], nil)
+			if err != nil {
+				return fmt.Errorf("Failed to seed config key %q: %w", key, err)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		notifyConfigChange(key, configs[key], ConfigEventSet)
+	}
+
+	return nil
+}
+
+// setConfigItem creates or updates the ConfigItem key on behalf of member and records the change in the config history.
+// Reserved keys are only written if ctx has an admin override.
+func setConfigItem(ctx context.Context, tx *sql.Tx, member string, key string, value string, expiresAt *time.Time) error {
+	err := checkReservedConfigKey(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	oldValue, err := configValue(ctx, tx, key)
+	if err != nil {
+		return err
+	}
+
+	err = updateConfigItem(ctx, tx, key, value, expiresAt)
+	if err != nil {
+		return err
+	}
+
+	return recordConfigChange(ctx, tx, member, key, oldValue, &value)
+}
+
+// reservedConfigPrefixes are the prefixes of the config keys that are only written
+// at bootstrap or with an admin override, as overwriting them can break the cluster.
+var reservedConfigPrefixes = []string{"deployment.", "cluster.", "microcluster."}
+
+// ReservedConfig returns the entries of configs whose key is reserved
+func ReservedConfig(configs map[string]string) map[string]string {
+	reserved := map[string]string{}
+	for key, value := range configs {
+		if isReservedConfigKey(key) {
+			reserved[key] = value
+		}
+	}
+
+	return reserved
+}
+
+// adminOverrideKey is the context key of the admin override
+type adminOverrideKey struct{}
+
+// WithAdminOverride returns a copy of ctx allowing writes to reserved config keys
+func WithAdminOverride(ctx context.Context) context.Context {
+	return context.WithValue(ctx, adminOverrideKey{}, true)
+}
+
+// AdminOverride returns whether ctx allows writes to reserved config keys
+func AdminOverride(ctx context.Context) bool {
+	override, _ := ctx.Value(adminOverrideKey{}).(bool)
+	return override
+}
+
+// checkReservedConfigKey returns a 403 error if key is reserved and ctx has no admin override
+func checkReservedConfigKey(ctx context.Context, key string) error {
+	if isReservedConfigKey(key) && !AdminOverride(ctx) {
+		return api.StatusErrorf(http.StatusForbidden, "Config key %q is reserved", key)
+	}
+
+	return nil
+}
+
+// isReservedConfigKey returns whether key starts with a reserved prefix
+func isReservedConfigKey(key string) bool {
+	for _, prefix := range reservedConfigPrefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// updateConfigItem creates or updates the ConfigItem key within the transaction tx,
+// expiring at expiresAt or as the policy of its namespace sets
+func updateConfigItem(ctx context.Context, tx *sql.Tx, key string, value string, expiresAt *time.Time) error {
+	err := validateConfigValue(ctx, tx, key, value)
+	if err != nil {
+		return err
+	}
+
+	expiresAt, err = applyNamespacePolicy(ctx, tx, key, expiresAt)
+	if err != nil {
+		return err
+	}
+
+	return writeConfigItem(ctx, tx, key, value, expiresAt)
+}
+
+// updateConfigItemValue sets the value of the ConfigItem key within the transaction tx.
+// A live key, whose current value is oldValue, keeps its expiry, otherwise the key is
+// created or updated as updateConfigItem does without an expiry.
+func updateConfigItemValue(ctx context.Context, tx *sql.Tx, key string, value string, oldValue *string) error {
+	if oldValue == nil {
+		return updateConfigItem(ctx, tx, key, value, nil)
+	}
+
+	err := validateConfigValue(ctx, tx, key, value)
+	if err != nil {
+		return err
+	}
+
+	expiresAt, err := database.GetConfigItemExpiry(ctx, tx, key)
+	if err != nil {
+		return err
+	}
+
+	return writeConfigItem(ctx, tx, key, value, expiresAt)
+}
+
+// writeConfigItem creates or updates the ConfigItem key expiring at expiresAt within the transaction tx
+func writeConfigItem(ctx context.Context, tx *sql.Tx, key string, value string, expiresAt *time.Time) error {
+	configItem := database.ConfigItem{Key: key, Value: value}
+
+	err := database.UpdateConfigItem(ctx, tx, key, configItem)
+	if err != nil && strings.Contains(err.Error(), "ConfigItem not found") {
+		_, err = database.CreateConfigItem(ctx, tx, configItem)
+	}
+	if err != nil {
+		return fmt.Errorf("Failed to record config item: %w", err)
+	}
+
+	err = database.MarkConfigItemModified(ctx, tx, key)
+	if err != nil {
+		return err
+	}
+
+	return database.SetConfigItemExpiry(ctx, tx, key, expiresAt)
+}
+
+// applyNamespacePolicy returns the expiry of key according to the policy of its namespace.
+// The default TTL of the namespace applies if expiresAt is nil, and an expiresAt is rejected
+// if the namespace does not allow user TTLs.
+func applyNamespacePolicy(ctx context.Context, tx *sql.Tx, key string, expiresAt *time.Time) (*time.Time, error) {
+	namespace, _, found := strings.Cut(key, ".")
+	if !found {
+		return expiresAt, nil
+	}
+
+	policy, err := database.GetConfigNamespacePolicy(ctx, tx, namespace)
+	if err != nil {
+		if api.StatusErrorCheck(err, http.StatusNotFound) {
+			return expiresAt, nil
+		}
+
+		return nil, err
+	}
+
+	if expiresAt != nil {
+		if !policy.AllowUserTTL {
+			return nil, api.StatusErrorf(http.StatusBadRequest, "Config namespace %q does not allow setting an expiry", namespace)
+		}
+
+		return expiresAt, nil
+	}
+
+	if policy.DefaultTTLSeconds == nil {
+		return nil, nil
+	}
+
+	defaultExpiry := time.Now().Add(time.Duration(*policy.DefaultTTLSeconds) * time.Second)
+
+	return &defaultExpiry, nil
+}
+
+// DeleteExpiredConfig deletes all the expired ConfigItems and returns how many were deleted.
+// A delete event is sent to the config watchers of this cluster member for each deleted key.
+func DeleteExpiredConfig(s *state.State) (int64, error) {
+	var keys []string
+
+	err := s.Database.Transaction(s.Context, func(ctx context.Context, tx *sql.Tx) error {
+		var err error
+		keys, err = database.DeleteExpiredConfigItems(ctx, tx)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	for _, key := range keys {
+		notifyConfigChange(key, "", ConfigEventDelete)
+	}
+
+	return int64(len(keys)), nil
+}
+
+// PatchConfig deep merges the JSON object patch into the JSON object stored under key
+// and returns the merged value. Nested objects are merged, any other value including
+// arrays replaces the existing one. The key keeps its expiry. If the key does not exist
+// or has expired, patch is stored as is.
+func PatchConfig(s *state.State, key string, patch string) (string, error) {
+	var patchValue map[string]any
+	err := json.Unmarshal([]byte(patch), &patchValue)
+	if err != nil {
+		return "", api.StatusErrorf(http.StatusBadRequest, "Patch for config key %q is not a JSON object: %v", key, err)
+	}
+
+	var merged string
+	err = s.Database.Transaction(s.Context, func(ctx context.Context, tx *sql.Tx) error {
+		var err error
+		merged, err = patchConfigItem(ctx, tx, s.Name(), key, patchValue)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+
+	notifyConfigChange(key, merged, ConfigEventSet)
+
+	return merged, nil
+}
+
+// patchConfigItem deep merges patch into the JSON object stored under key on behalf of member
+// and returns the merged value. A live ConfigItem keeps its expiry. An expired ConfigItem is
+// treated as missing, and the expiry of the merged value is reset to the default of its namespace.
+func patchConfigItem(ctx context.Context, tx *sql.Tx, member string, key string, patch map[string]any) (string, error) {
+	err := checkReservedConfigKey(ctx, key)
+	if err != nil {
+		return "", err
+	}
+
+	oldValue, err := configValue(ctx, tx, key)
+	if err != nil {
+		return "", err
+	}
+
+	var existing map[string]any
+	if oldValue != nil {
+		err = json.Unmarshal([]byte(*oldValue), &existing)
+		if err != nil {
+			return "", api.StatusErrorf(http.StatusUnprocessableEntity, "Existing value of config key %q is not a JSON object", key)
+		}
+	}
+
+	mergedJSON, err := json.Marshal(mergeJSONObjects(existing, patch))
+	if err != nil {
+		return "", fmt.Errorf("Failed to marshal merged config item: %w", err)
+	}
+
+	merged := string(mergedJSON)
+	err = updateConfigItemValue(ctx, tx, key, merged, oldValue)
+	if err != nil {
+		return "", err
+	}
+
+	return merged, recordConfigChange(ctx, tx, member, key, oldValue, &merged)
+}
+
+// mergeJSONObjects recursively merges patch into dst and returns dst
+func mergeJSONObjects(dst map[string]any, patch map[string]any) map[string]any {
+	if dst == nil {
+		dst = map[string]any{}
+	}
+
+	for key, value := range patch {
+		patchObject, ok := value.(map[string]any)
+		if ok {
+			dstObject, ok := dst[key].(map[string]any)
+			if ok {
+				dst[key] = mergeJSONObjects(dstObject, patchObject)
+				continue
+			}
+		}
+
+		dst[key] = value
+	}
+
+	return dst
+}
+
+// CompareAndSwapConfig sets key to newValue only if its current value is expected
+// and returns whether the value was swapped. A missing key is created if expected is empty.
+func CompareAndSwapConfig(s *state.State, key string, expected string, newValue string) (bool, error) {
+	var swapped bool
+
+	err := s.Database.Transaction(s.Context, func(ctx context.Context, tx *sql.Tx) error {
+		var err error
+		swapped, err = compareAndSwapConfigItem(ctx, tx, s.Name(), key, expected, newValue)
+		return err
+	})
+	if err != nil {
+		return false, err
+	}
+
+	if swapped {
+		notifyConfigChange(key, newValue, ConfigEventSet)
+	}
+
+	return swapped, nil
+}
+
+// compareAndSwapConfigItem sets key to newValue on behalf of member only if its current value is expected.
+// A live ConfigItem keeps its expiry. An expired ConfigItem is treated as missing, and the expiry
+// of a value swapped in is then reset to the default of its namespace.
+func compareAndSwapConfigItem(ctx context.Context, tx *sql.Tx, member string, key string, expected string, newValue string) (bool, error) {
+	err := checkReservedConfigKey(ctx, key)
+	if err != nil {
+		return false, err
+	}
+
+	oldValue, err := configValue(ctx, tx, key)
+	if err != nil {
+		return false, err
+	}
+
+	if oldValue == nil && expected != "" || oldValue != nil && *oldValue != expected {
+		return false, nil
+	}
+
+	err = updateConfigItemValue(ctx, tx, key, newValue, oldValue)
+	if err != nil {
+		return false, err
+	}
+
+	return true, recordConfigChange(ctx, tx, member, key, oldValue, &newValue)
 }
 
 // DeleteConfig deletes a ConfigItem from the database
 func DeleteConfig(s *state.State, key string) error {
-	return s.Database.Transaction(s.Context, func(ctx context.Context, tx *sql.Tx) error {
-		return database.DeleteConfigItem(ctx, tx, key)
+	err := s.Database.Transaction(s.Context, func(ctx context.Context, tx *sql.Tx) error {
+		err := checkReservedConfigKey(ctx, key)
+		if err != nil {
+			return err
+		}
+
+		oldValue, err := configValue(ctx, tx, key)
+		if err != nil {
+			return err
+		}
+
+		err = database.DeleteConfigItem(ctx, tx, key)
+		if err != nil {
+			return err
+		}
+
+		return recordConfigChange(ctx, tx, s.Name(), key, oldValue, nil)
 	})
+	if err != nil {
+		return err
+	}
+
+	notifyConfigChange(key, "", ConfigEventDelete)
+
+	return nil
 }
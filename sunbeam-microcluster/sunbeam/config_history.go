@@ -0,0 +1,186 @@
+package sunbeam
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/canonical/lxd/shared/api"
+	"github.com/canonical/lxd/shared/logger"
+	"github.com/canonical/microcluster/state"
+
+	"github.com/canonical/snap-openstack/sunbeam-microcluster/api/types"
+	"github.com/canonical/snap-openstack/sunbeam-microcluster/database"
+)
+
+// ConfigHistoryRetentionDaysKey is the config key holding the number of days the config history is kept
+const ConfigHistoryRetentionDaysKey = "config.history-retention-days"
+
+// defaultConfigHistoryRetentionDays is used when ConfigHistoryRetentionDaysKey is not set
+const defaultConfigHistoryRetentionDays = 90
+
+// configValue returns the value of the ConfigItem key, or nil if it does not exist or has expired.
+// Expired ConfigItems are only deleted on the next heartbeat, until then they are treated as absent.
+func configValue(ctx context.Context, tx *sql.Tx, key string) (*string, error) {
+	record, err := database.GetConfigItem(ctx, tx, key)
+	if err != nil {
+		if api.StatusErrorCheck(err, http.StatusNotFound) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	expired, err := database.ConfigItemExpired(ctx, tx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	if expired {
+		return nil, nil
+	}
+
+	return &record.Value, nil
+}
+
+// recordConfigChange records in the config history that member changed key from oldValue to newValue,
+// nil meaning the key does not exist. Terraform states and locks have their own history and are not recorded.
+func recordConfigChange(ctx context.Context, tx *sql.Tx, member string, key string, oldValue *string, newValue *string) error {
+	if isTerraformKey(key) {
+		return nil
+	}
+
+	if oldValue != nil && newValue != nil && *oldValue == *newValue {
+		return nil
+	}
+
+	return database.CreateConfigHistoryEntry(ctx, tx, database.ConfigHistoryEntry{
+		Key:       key,
+		OldValue:  oldValue,
+		NewValue:  newValue,
+		ChangedBy: member,
+	})
+}
+
+// GetConfigHistory returns the changes of the config key, or of all keys if key is nil, most recent first.
+// Changes made before since are excluded if since is not nil, and at most limit changes are returned if positive.
+func GetConfigHistory(s *state.State, key *string, since *time.Time, limit int) (types.ConfigHistory, error) {
+	history := types.ConfigHistory{}
+
+	err := s.Database.Transaction(s.Context, func(ctx context.Context, tx *sql.Tx) error {
+		records, err := database.GetConfigHistory(ctx, tx, database.ConfigHistoryFilter{Key: key, Since: since, Limit: limit})
+		if err != nil {
+			return err
+		}
+
+		for _, record := range records {
+			changedAt, err := parseDBTimestamp(record.ChangedAt)
+			if err != nil {
+				return err
+			}
+
+			history = append(history, types.ConfigHistoryEntry{
+				ID:        record.ID,
+				Key:       record.Key,
+				OldValue:  record.OldValue,
+				NewValue:  record.NewValue,
+				ChangedBy: record.ChangedBy,
+				ChangedAt: changedAt,
+			})
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return history, nil
+}
+
+// RevertConfig sets key back to the value it had before the change historyID of the config history
+// and returns that value. The revert is itself recorded in the config history.
+func RevertConfig(s *state.State, key string, historyID int64) (string, error) {
+	var value string
+	err := s.Database.Transaction(s.Context, func(ctx context.Context, tx *sql.Tx) error {
+		var err error
+		value, err = revertConfigItem(ctx, tx, s.Name(), key, historyID)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+
+	notifyConfigChange(key, value, ConfigEventSet)
+
+	return value, nil
+}
+
+// revertConfigItem sets key on behalf of member back to the old value of the change historyID and returns it.
+// The change must be of key, and is a 422 error if it created key as there is no old value to revert to.
+func revertConfigItem(ctx context.Context, tx *sql.Tx, member string, key string, historyID int64) (string, error) {
+	entry, err := database.GetConfigHistoryEntry(ctx, tx, historyID)
+	if err != nil {
+		if api.StatusErrorCheck(err, http.StatusNotFound) {
+			return "", api.StatusErrorf(http.StatusNotFound, "Config history entry %d not found", historyID)
+		}
+
+		return "", err
+	}
+
+	if entry.Key != key {
+		return "", api.StatusErrorf(http.StatusNotFound, "Config history entry %d not found for config key %q", historyID, key)
+	}
+
+	if entry.OldValue == nil {
+		return "", api.StatusErrorf(http.StatusUnprocessableEntity, "Config history entry %d created config key %q, it has no previous value", historyID, key)
+	}
+
+	err = setConfigItem(ctx, tx, member, key, *entry.OldValue, nil)
+	if err != nil {
+		return "", err
+	}
+
+	return *entry.OldValue, nil
+}
+
+// configHistoryRetention returns how long the config history is kept from config, or the default if unset or invalid
+func configHistoryRetention(s *state.State) (time.Duration, error) {
+	value, exists, err := GetConfig(s, ConfigHistoryRetentionDaysKey)
+	if err != nil {
+		return 0, err
+	}
+
+	days := defaultConfigHistoryRetentionDays
+	if exists {
+		days, err = strconv.Atoi(value)
+		if err != nil || days <= 0 {
+			logger.Warnf("Invalid %s %q, using default of %d", ConfigHistoryRetentionDaysKey, value, defaultConfigHistoryRetentionDays)
+			days = defaultConfigHistoryRetentionDays
+		}
+	}
+
+	return time.Duration(days) * 24 * time.Hour, nil
+}
+
+// PruneConfigHistory deletes the config history older than its retention period and returns how many changes were deleted
+func PruneConfigHistory(s *state.State) (int64, error) {
+	retention, err := configHistoryRetention(s)
+	if err != nil {
+		return 0, err
+	}
+
+	var deleted int64
+	err = s.Database.Transaction(s.Context, func(ctx context.Context, tx *sql.Tx) error {
+		deleted, err = database.DeleteConfigHistoryBefore(ctx, tx, time.Now().Add(-retention))
+		return err
+	})
+	if err != nil {
+		return 0, fmt.Errorf("Failed to prune config history: %w", err)
+	}
+
+	return deleted, nil
+}
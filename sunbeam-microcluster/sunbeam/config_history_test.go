@@ -0,0 +1,82 @@
+package sunbeam
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/canonical/lxd/shared/api"
+
+	"github.com/canonical/snap-openstack/sunbeam-microcluster/database"
+)
+
+func TestRevertConfigItem(t *testing.T) {
+	tx := database.NewTestSchemaTx(t)
+	ctx := context.Background()
+
+	for _, value := range []string{"first", "second"} {
+		err := setConfigItem(ctx, tx, "member", "test.key", value, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := setConfigItem(ctx, tx, "member", "test.other", "other", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key := "test.key"
+	history, err := database.GetConfigHistory(ctx, tx, database.ConfigHistoryFilter{Key: &key})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(history) != 2 {
+		t.Fatalf("Got %d config history entries, want 2", len(history))
+	}
+
+	created, updated := history[1], history[0]
+
+	value, err := revertConfigItem(ctx, tx, "member", "test.key", updated.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if value != "first" {
+		t.Errorf("Revert returned %q, want %q", value, "first")
+	}
+
+	stored, err := configValue(ctx, tx, "test.key")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if stored == nil || *stored != "first" {
+		t.Errorf("Revert stored %v, want %q", stored, "first")
+	}
+
+	history, err = database.GetConfigHistory(ctx, tx, database.ConfigHistoryFilter{Key: &key})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(history) != 3 || history[0].NewValue == nil || *history[0].NewValue != "first" {
+		t.Errorf("Revert was not recorded in the config history: %+v", history)
+	}
+
+	_, err = revertConfigItem(ctx, tx, "member", "test.key", created.ID)
+	if !api.StatusErrorCheck(err, http.StatusUnprocessableEntity) {
+		t.Errorf("Revert of the creation returned %v, want a 422 error", err)
+	}
+
+	_, err = revertConfigItem(ctx, tx, "member", "test.other", updated.ID)
+	if !api.StatusErrorCheck(err, http.StatusNotFound) {
+		t.Errorf("Revert to an entry of another key returned %v, want a 404 error", err)
+	}
+
+	_, err = revertConfigItem(ctx, tx, "member", "test.key", 1000)
+	if !api.StatusErrorCheck(err, http.StatusNotFound) {
+		t.Errorf("Revert to a missing entry returned %v, want a 404 error", err)
+	}
+}
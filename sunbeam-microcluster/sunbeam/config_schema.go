@@ -0,0 +1,167 @@
+package sunbeam
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/canonical/lxd/shared/api"
+	"github.com/canonical/microcluster/state"
+
+	"github.com/canonical/snap-openstack/sunbeam-microcluster/api/types"
+	"github.com/canonical/snap-openstack/sunbeam-microcluster/database"
+)
+
+// Config value types of the config schema
+const (
+	ConfigTypeString          = "string"
+	ConfigTypeInteger         = "integer"
+	ConfigTypePositiveInteger = "positive-integer"
+	ConfigTypeBoolean         = "boolean"
+	ConfigTypeDuration        = "duration"
+	ConfigTypeEnum            = "enum"
+)
+
+// configTypeValidators checks a value can be parsed as each config value type.
+// Enum values are only checked against the regex constraint.
+var configTypeValidators = map[string]func(value string) error{
+	ConfigTypeString: func(string) error { return nil },
+	ConfigTypeInteger: func(value string) error {
+		_, err := strconv.ParseInt(value, 10, 64)
+		return err
+	},
+	ConfigTypePositiveInteger: func(value string) error {
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err == nil && n <= 0 {
+			return fmt.Errorf("not positive")
+		}
+
+		return err
+	},
+	ConfigTypeBoolean: func(value string) error {
+		if value != "true" && value != "false" {
+			return fmt.Errorf("expected true or false")
+		}
+
+		return nil
+	},
+	ConfigTypeDuration: func(value string) error {
+		_, err := time.ParseDuration(value)
+		return err
+	},
+	ConfigTypeEnum: func(string) error { return nil },
+}
+
+// ListConfigSchema returns the declared types of all the config keys in the config schema
+func ListConfigSchema(s *state.State) (types.ConfigSchema, error) {
+	schema := types.ConfigSchema{}
+
+	err := s.Database.Transaction(s.Context, func(ctx context.Context, tx *sql.Tx) error {
+		records, err := database.GetConfigSchemaEntries(ctx, tx)
+		if err != nil {
+			return err
+		}
+
+		for _, record := range records {
+			schema = append(schema, types.ConfigSchemaEntry{
+				Key:             record.Key,
+				Type:            record.Type,
+				RegexConstraint: record.RegexConstraint,
+			})
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return schema, nil
+}
+
+// SetConfigSchemaEntry declares the type of the value of a config key.
+// Values already stored under the key are not validated again.
+func SetConfigSchemaEntry(s *state.State, entry types.ConfigSchemaEntry) error {
+	err := validateConfigSchemaEntry(entry)
+	if err != nil {
+		return err
+	}
+
+	return s.Database.Transaction(s.Context, func(ctx context.Context, tx *sql.Tx) error {
+		return database.SetConfigSchemaEntry(ctx, tx, database.ConfigSchemaEntry{
+			Key:             entry.Key,
+			Type:            entry.Type,
+			RegexConstraint: entry.RegexConstraint,
+		})
+	})
+}
+
+// validateConfigSchemaEntry checks the type is known and the regex constraint compiles
+func validateConfigSchemaEntry(entry types.ConfigSchemaEntry) error {
+	if entry.Key == "" {
+		return api.StatusErrorf(http.StatusBadRequest, "Config key cannot be empty")
+	}
+
+	_, ok := configTypeValidators[entry.Type]
+	if !ok {
+		return api.StatusErrorf(http.StatusBadRequest, "Unknown config type %q", entry.Type)
+	}
+
+	if entry.Type == ConfigTypeEnum && entry.RegexConstraint == "" {
+		return api.StatusErrorf(http.StatusBadRequest, "Config type %q requires a regex_constraint", ConfigTypeEnum)
+	}
+
+	_, err := regexp.Compile(entry.RegexConstraint)
+	if err != nil {
+		return api.StatusErrorf(http.StatusBadRequest, "Invalid regex_constraint %q: %v", entry.RegexConstraint, err)
+	}
+
+	return nil
+}
+
+// validateConfigValue returns a 400 error if value does not match the config schema of key.
+// Keys not in the config schema accept any value.
+func validateConfigValue(ctx context.Context, tx *sql.Tx, key string, value string) error {
+	entry, err := database.GetConfigSchemaEntry(ctx, tx, key)
+	if err != nil {
+		if api.StatusErrorCheck(err, http.StatusNotFound) {
+			return nil
+		}
+
+		return err
+	}
+
+	return checkConfigValue(*entry, value)
+}
+
+// checkConfigValue returns a 400 error if value does not match the config schema entry
+func checkConfigValue(entry database.ConfigSchemaEntry, value string) error {
+	validator, ok := configTypeValidators[entry.Type]
+	if !ok {
+		return fmt.Errorf("Config key %q has unknown type %q", entry.Key, entry.Type)
+	}
+
+	err := validator(value)
+	if err != nil {
+		return api.StatusErrorf(http.StatusBadRequest, "Invalid value %q for config key %q of type %s: %v", value, entry.Key, entry.Type, err)
+	}
+
+	if entry.RegexConstraint == "" {
+		return nil
+	}
+
+	matched, err := regexp.MatchString(entry.RegexConstraint, value)
+	if err != nil {
+		return fmt.Errorf("Config key %q has invalid regex constraint %q: %w", entry.Key, entry.RegexConstraint, err)
+	}
+
+	if !matched {
+		return api.StatusErrorf(http.StatusBadRequest, "Invalid value %q for config key %q, it must match %q", value, entry.Key, entry.RegexConstraint)
+	}
+
+	return nil
+}
@@ -0,0 +1,63 @@
+package sunbeam
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/canonical/lxd/shared/api"
+
+	"github.com/canonical/snap-openstack/sunbeam-microcluster/database"
+)
+
+func TestCheckConfigValue(t *testing.T) {
+	tests := []struct {
+		name    string
+		entry   database.ConfigSchemaEntry
+		value   string
+		wantErr bool
+	}{
+		{name: "string", entry: database.ConfigSchemaEntry{Type: ConfigTypeString}, value: "anything"},
+		{name: "integer", entry: database.ConfigSchemaEntry{Type: ConfigTypeInteger}, value: "-3"},
+		{name: "invalid integer", entry: database.ConfigSchemaEntry{Type: ConfigTypeInteger}, value: "3.5", wantErr: true},
+		{name: "positive integer", entry: database.ConfigSchemaEntry{Type: ConfigTypePositiveInteger}, value: "1"},
+		{name: "zero positive integer", entry: database.ConfigSchemaEntry{Type: ConfigTypePositiveInteger}, value: "0", wantErr: true},
+		{name: "negative positive integer", entry: database.ConfigSchemaEntry{Type: ConfigTypePositiveInteger}, value: "-1", wantErr: true},
+		{name: "boolean", entry: database.ConfigSchemaEntry{Type: ConfigTypeBoolean}, value: "false"},
+		{name: "invalid boolean", entry: database.ConfigSchemaEntry{Type: ConfigTypeBoolean}, value: "yes", wantErr: true},
+		{name: "duration", entry: database.ConfigSchemaEntry{Type: ConfigTypeDuration}, value: "1h30m"},
+		{name: "invalid duration", entry: database.ConfigSchemaEntry{Type: ConfigTypeDuration}, value: "90", wantErr: true},
+		{name: "enum", entry: database.ConfigSchemaEntry{Type: ConfigTypeEnum, RegexConstraint: "^(local|maas)$"}, value: "maas"},
+		{name: "invalid enum", entry: database.ConfigSchemaEntry{Type: ConfigTypeEnum, RegexConstraint: "^(local|maas)$"}, value: "cloud", wantErr: true},
+		{name: "regex constraint", entry: database.ConfigSchemaEntry{Type: ConfigTypeInteger, RegexConstraint: "^[0-9]{2}$"}, value: "123", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.entry.Key = "test.key"
+			err := checkConfigValue(tt.entry, tt.value)
+			if !tt.wantErr {
+				if err != nil {
+					t.Errorf("Value %q was rejected: %v", tt.value, err)
+				}
+
+				return
+			}
+
+			if !api.StatusErrorCheck(err, http.StatusBadRequest) {
+				t.Errorf("Value %q returned %v, want a 400 error", tt.value, err)
+			}
+		})
+	}
+}
+
+func TestCheckConfigValueInvalidSchema(t *testing.T) {
+	for _, entry := range []database.ConfigSchemaEntry{
+		{Key: "test.key", Type: "unknown"},
+		{Key: "test.key", Type: ConfigTypeString, RegexConstraint: "("},
+	} {
+		err := checkConfigValue(entry, "value")
+		if err == nil || api.StatusErrorCheck(err, http.StatusBadRequest) {
+			t.Errorf("Schema %+v returned %v, want an internal error", entry, err)
+		}
+	}
+}
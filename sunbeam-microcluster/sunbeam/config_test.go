@@ -0,0 +1,343 @@
+package sunbeam
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/canonical/lxd/shared/api"
+
+	"github.com/canonical/snap-openstack/sunbeam-microcluster/database"
+)
+
+// setTestConfig stores value under key, expiring at expiresAt if not nil.
+func setTestConfig(t *testing.T, tx *sql.Tx, key string, value string, expiresAt *time.Time) {
+	t.Helper()
+
+	ctx := context.Background()
+	_, err := database.CreateConfigItem(ctx, tx, database.ConfigItem{Key: key, Value: value})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = database.SetConfigItemExpiry(ctx, tx, key, expiresAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// testConfigExpiry returns the expiry of key.
+func testConfigExpiry(t *testing.T, tx *sql.Tx, key string) *time.Time {
+	t.Helper()
+
+	expiresAt, err := database.GetConfigItemExpiry(context.Background(), tx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return expiresAt
+}
+
+func TestMergeJSONObjects(t *testing.T) {
+	tests := []struct {
+		name  string
+		dst   map[string]any
+		patch map[string]any
+		want  map[string]any
+	}{
+		{
+			name:  "nil destination",
+			dst:   nil,
+			patch: map[string]any{"a": "x"},
+			want:  map[string]any{"a": "x"},
+		},
+		{
+			name:  "new key",
+			dst:   map[string]any{"a": "x"},
+			patch: map[string]any{"b": "y"},
+			want:  map[string]any{"a": "x", "b": "y"},
+		},
+		{
+			name:  "nested objects are merged",
+			dst:   map[string]any{"a": map[string]any{"b": "x", "c": "y"}},
+			patch: map[string]any{"a": map[string]any{"c": "z"}},
+			want:  map[string]any{"a": map[string]any{"b": "x", "c": "z"}},
+		},
+		{
+			name:  "arrays are replaced",
+			dst:   map[string]any{"a": []any{"x", "y"}},
+			patch: map[string]any{"a": []any{"z"}},
+			want:  map[string]any{"a": []any{"z"}},
+		},
+		{
+			name:  "object replaces scalar",
+			dst:   map[string]any{"a": "x"},
+			patch: map[string]any{"a": map[string]any{"b": "y"}},
+			want:  map[string]any{"a": map[string]any{"b": "y"}},
+		},
+		{
+			name:  "scalar replaces object",
+			dst:   map[string]any{"a": map[string]any{"b": "y"}},
+			patch: map[string]any{"a": "x"},
+			want:  map[string]any{"a": "x"},
+		},
+		{
+			name:  "null is kept",
+			dst:   map[string]any{"a": "x"},
+			patch: map[string]any{"a": nil},
+			want:  map[string]any{"a": nil},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := mergeJSONObjects(tt.dst, tt.patch)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("mergeJSONObjects returned %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPatchConfigItemExpired(t *testing.T) {
+	tx := database.NewTestSchemaTx(t)
+	past := time.Now().Add(-time.Hour)
+	setTestConfig(t, tx, "test.key", `{"a":"x"}`, &past)
+
+	merged, err := patchConfigItem(context.Background(), tx, "member", "test.key", map[string]any{"b": "y"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if merged != `{"b":"y"}` {
+		t.Errorf("Patch of an expired key returned %s, want %s", merged, `{"b":"y"}`)
+	}
+
+	if expiresAt := testConfigExpiry(t, tx, "test.key"); expiresAt != nil {
+		t.Errorf("Patch of an expired key kept the expiry %v", expiresAt)
+	}
+}
+
+func TestPatchConfigItemLive(t *testing.T) {
+	tx := database.NewTestSchemaTx(t)
+	future := time.Now().Add(time.Hour)
+	setTestConfig(t, tx, "test.key", `{"a":"x"}`, &future)
+
+	merged, err := patchConfigItem(context.Background(), tx, "member", "test.key", map[string]any{"b": "y"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if merged != `{"a":"x","b":"y"}` {
+		t.Errorf("Patch returned %s, want %s", merged, `{"a":"x","b":"y"}`)
+	}
+
+	expiresAt := testConfigExpiry(t, tx, "test.key")
+	if expiresAt == nil || !expiresAt.Equal(future.UTC().Truncate(time.Second)) {
+		t.Errorf("Patch set the expiry %v, want %v", expiresAt, future)
+	}
+}
+
+func TestPatchConfigItemNamespacePolicy(t *testing.T) {
+	tx := database.NewTestSchemaTx(t)
+	ttl := int64(3600)
+	err := database.CreateConfigNamespacePolicy(context.Background(), tx, database.ConfigNamespacePolicy{Namespace: "test", DefaultTTLSeconds: &ttl})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The expiry set from the default TTL is kept even though the namespace does not allow setting one.
+	expiry := time.Now().Add(10 * time.Minute)
+	setTestConfig(t, tx, "test.expiring", `{"a":"x"}`, &expiry)
+	setTestConfig(t, tx, "test.permanent", `{"a":"x"}`, nil)
+
+	for _, key := range []string{"test.expiring", "test.permanent"} {
+		_, err := patchConfigItem(context.Background(), tx, "member", key, map[string]any{"b": "y"})
+		if err != nil {
+			t.Fatalf("Patch of %q failed: %v", key, err)
+		}
+	}
+
+	expiresAt := testConfigExpiry(t, tx, "test.expiring")
+	if expiresAt == nil || !expiresAt.Equal(expiry.UTC().Truncate(time.Second)) {
+		t.Errorf("Patch set the expiry %v, want %v", expiresAt, expiry)
+	}
+
+	if expiresAt := testConfigExpiry(t, tx, "test.permanent"); expiresAt != nil {
+		t.Errorf("Patch of a permanent key set the expiry %v", expiresAt)
+	}
+}
+
+func TestCompareAndSwapConfigItem(t *testing.T) {
+	past := time.Now().Add(-time.Hour)
+	future := time.Now().Add(time.Hour)
+
+	tests := []struct {
+		name        string
+		value       *string
+		expiresAt   *time.Time
+		expected    string
+		wantSwapped bool
+		wantExpiry  *time.Time
+	}{
+		{name: "missing key, empty expected", expected: "", wantSwapped: true},
+		{name: "missing key, expected value", expected: "old", wantSwapped: false},
+		{name: "live value matches", value: ptr("old"), expected: "old", wantSwapped: true},
+		{name: "live value differs", value: ptr("old"), expected: "other", wantSwapped: false},
+		{name: "live value, empty expected", value: ptr("old"), expected: "", wantSwapped: false},
+		{name: "live expiring value matches", value: ptr("old"), expiresAt: &future, expected: "old", wantSwapped: true, wantExpiry: &future},
+		{name: "expired value matches", value: ptr("old"), expiresAt: &past, expected: "old", wantSwapped: false},
+		{name: "expired value, empty expected", value: ptr("old"), expiresAt: &past, expected: "", wantSwapped: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tx := database.NewTestSchemaTx(t)
+			if tt.value != nil {
+				setTestConfig(t, tx, "test.key", *tt.value, tt.expiresAt)
+			}
+
+			swapped, err := compareAndSwapConfigItem(context.Background(), tx, "member", "test.key", tt.expected, "new")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if swapped != tt.wantSwapped {
+				t.Fatalf("Swap returned %v, want %v", swapped, tt.wantSwapped)
+			}
+
+			if !swapped {
+				return
+			}
+
+			value, err := configValue(context.Background(), tx, "test.key")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if value == nil || *value != "new" {
+				t.Errorf("Swap stored %v, want %q", value, "new")
+			}
+
+			expiresAt := testConfigExpiry(t, tx, "test.key")
+			if tt.wantExpiry == nil && expiresAt != nil {
+				t.Errorf("Swap set the expiry %v, want none", expiresAt)
+			}
+
+			if tt.wantExpiry != nil && (expiresAt == nil || !expiresAt.Equal(tt.wantExpiry.UTC().Truncate(time.Second))) {
+				t.Errorf("Swap set the expiry %v, want %v", expiresAt, tt.wantExpiry)
+			}
+		})
+	}
+}
+
+func TestCompareAndSwapConfigItemNamespacePolicy(t *testing.T) {
+	tx := database.NewTestSchemaTx(t)
+	ttl := int64(3600)
+	err := database.CreateConfigNamespacePolicy(context.Background(), tx, database.ConfigNamespacePolicy{Namespace: "test", DefaultTTLSeconds: &ttl})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	past := time.Now().Add(-time.Hour)
+	setTestConfig(t, tx, "test.key", "old", &past)
+
+	swapped, err := compareAndSwapConfigItem(context.Background(), tx, "member", "test.key", "", "new")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !swapped {
+		t.Fatal("Swap of an expired key with an empty expected value was refused")
+	}
+
+	expiresAt := testConfigExpiry(t, tx, "test.key")
+	if expiresAt == nil || !expiresAt.After(time.Now()) {
+		t.Errorf("Swap set the expiry %v, want the default TTL of the namespace", expiresAt)
+	}
+}
+
+// ptr returns a pointer to value.
+func ptr(value string) *string {
+	return &value
+}
+
+func TestConfigValue(t *testing.T) {
+	past := time.Now().Add(-time.Hour)
+	future := time.Now().Add(time.Hour)
+
+	tests := []struct {
+		name      string
+		value     *string
+		expiresAt *time.Time
+		want      *string
+	}{
+		{name: "missing key", want: nil},
+		{name: "empty value", value: ptr(""), want: ptr("")},
+		{name: "value", value: ptr("value"), want: ptr("value")},
+		{name: "live expiring value", value: ptr("value"), expiresAt: &future, want: ptr("value")},
+		{name: "expired value", value: ptr("value"), expiresAt: &past, want: nil},
+		{name: "expired empty value", value: ptr(""), expiresAt: &past, want: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tx := database.NewTestSchemaTx(t)
+			if tt.value != nil {
+				setTestConfig(t, tx, "test.key", *tt.value, tt.expiresAt)
+			}
+
+			got, err := configValue(context.Background(), tx, "test.key")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("configValue returned %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateConfigKey(t *testing.T) {
+	tests := []struct {
+		key     string
+		wantErr bool
+	}{
+		{key: "", wantErr: true},
+		{key: "bulk", wantErr: true},
+		{key: "bulk.size", wantErr: false},
+		{key: "history", wantErr: true},
+		{key: "watch", wantErr: true},
+		{key: "tfstate-plan", wantErr: true},
+		{key: "tflock-plan", wantErr: true},
+		{key: "test.key", wantErr: false},
+	}
+
+	for _, tt := range tests {
+		err := validateConfigKey(tt.key)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("validateConfigKey(%q) returned %v, want error %v", tt.key, err, tt.wantErr)
+		}
+	}
+}
+
+func TestSetConfigItemReserved(t *testing.T) {
+	tx := database.NewTestSchemaTx(t)
+	ctx := context.Background()
+
+	for _, key := range []string{ManifestSigningKeysKey, ManifestRequireSignatureKey, JujuGroupAccessKey} {
+		err := setConfigItem(ctx, tx, "member-1", key, "true", nil)
+		if !api.StatusErrorCheck(err, http.StatusForbidden) {
+			t.Errorf("Setting %s without admin override returned %v, want status %d", key, err, http.StatusForbidden)
+		}
+
+		err = setConfigItem(WithAdminOverride(ctx), tx, "member-1", key, "true", nil)
+		if err != nil {
+			t.Errorf("Setting %s with admin override failed: %v", key, err)
+		}
+	}
+}
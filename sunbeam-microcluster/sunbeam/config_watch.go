@@ -0,0 +1,56 @@
+package sunbeam
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/canonical/lxd/shared/logger"
+
+	"github.com/canonical/snap-openstack/sunbeam-microcluster/api/types"
+)
+
+// Actions of the config change events
+const (
+	ConfigEventSet    = "set"
+	ConfigEventDelete = "delete"
+)
+
+// configWatchBuffer is the number of events buffered for each watcher
+const configWatchBuffer = 64
+
+// configWatchers maps the channel of each config watcher to the key prefix it watches
+var configWatchers sync.Map
+
+// WatchConfig subscribes to the changes of the config keys starting with prefix made on this
+// cluster member. The returned function unsubscribes and must be called once done watching.
+// Events are dropped for a watcher that does not keep up.
+func WatchConfig(prefix string) (<-chan types.ConfigEvent, func()) {
+	events := make(chan types.ConfigEvent, configWatchBuffer)
+	configWatchers.Store(events, prefix)
+
+	return events, func() { configWatchers.Delete(events) }
+}
+
+// notifyConfigChange sends a config change event to the watchers of key.
+// It is called once the transaction changing key is committed.
+func notifyConfigChange(key string, value string, action string) {
+	if isTerraformKey(key) {
+		return
+	}
+
+	event := types.ConfigEvent{Key: key, Value: value, Action: action}
+
+	configWatchers.Range(func(k, v any) bool {
+		if !strings.HasPrefix(key, v.(string)) {
+			return true
+		}
+
+		select {
+		case k.(chan types.ConfigEvent) <- event:
+		default:
+			logger.Warnf("Dropped config %s event of %q for a slow watcher", action, key)
+		}
+
+		return true
+	})
+}
@@ -0,0 +1,104 @@
+package sunbeam
+
+import (
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/canonical/lxd/shared/logger"
+	"github.com/canonical/microcluster/state"
+
+	"github.com/canonical/snap-openstack/sunbeam-microcluster/api/types"
+)
+
+const (
+	// HealthOK is the status of a healthy component
+	HealthOK = "ok"
+	// HealthDegraded is the status of a component that works but needs attention
+	HealthDegraded = "degraded"
+)
+
+// TerraformLockWarnThresholdKey is the config key holding after how many minutes a held terraform lock degrades health
+const TerraformLockWarnThresholdKey = "terraform.lock.warn-threshold-minutes"
+
+// defaultTerraformLockWarnThreshold is used when TerraformLockWarnThresholdKey is not set
+const defaultTerraformLockWarnThreshold = 30 * time.Minute
+
+// CollectHealth returns the health of the cluster components.
+// The cluster is degraded if any component is degraded.
+func CollectHealth(s *state.State) (types.Health, error) {
+	terraform, err := collectTerraformHealth(s)
+	if err != nil {
+		return types.Health{}, err
+	}
+
+	health := types.Health{
+		Status: HealthOK,
+		Components: map[string]types.HealthComponent{
+			"terraform": terraform,
+		},
+	}
+
+	for _, component := range health.Components {
+		if component.Status != HealthOK {
+			health.Status = HealthDegraded
+		}
+	}
+
+	return health, nil
+}
+
+// collectTerraformHealth reports the held terraform locks, degraded if any is held longer than the warn threshold
+func collectTerraformHealth(s *state.State) (types.HealthComponent, error) {
+	component := types.HealthComponent{Status: HealthOK, TerraformLocks: []types.TerraformLockHealth{}}
+
+	threshold, err := terraformLockWarnThreshold(s)
+	if err != nil {
+		return component, err
+	}
+
+	locks, err := GetAllTerraformLocks(s)
+	if err != nil {
+		return component, err
+	}
+
+	now := time.Now()
+	for name, lock := range locks {
+		heldFor := now.Sub(lock.Created)
+		if heldFor > threshold {
+			component.Status = HealthDegraded
+		}
+
+		component.TerraformLocks = append(component.TerraformLocks, types.TerraformLockHealth{
+			Name:           name,
+			HeldForSeconds: int64(heldFor.Seconds()),
+			Locker:         lock.Who,
+		})
+	}
+
+	sort.Slice(component.TerraformLocks, func(i, j int) bool {
+		return component.TerraformLocks[i].Name < component.TerraformLocks[j].Name
+	})
+
+	return component, nil
+}
+
+// terraformLockWarnThreshold returns the warn threshold from config, or the default if unset or invalid
+func terraformLockWarnThreshold(s *state.State) (time.Duration, error) {
+	value, exists, err := GetConfig(s, TerraformLockWarnThresholdKey)
+	if err != nil {
+		return 0, err
+	}
+
+	if !exists {
+		return defaultTerraformLockWarnThreshold, nil
+	}
+
+	minutes, err := strconv.Atoi(value)
+	if err != nil || minutes <= 0 {
+		logger.Warnf("Invalid %s %q, using default of %s", TerraformLockWarnThresholdKey, value, defaultTerraformLockWarnThreshold)
+		return defaultTerraformLockWarnThreshold, nil
+	}
+
+	return time.Duration(minutes) * time.Minute, nil
+}
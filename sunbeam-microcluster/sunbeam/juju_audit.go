@@ -0,0 +1,142 @@
+package sunbeam
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/canonical/lxd/shared/api"
+	"github.com/canonical/lxd/shared/logger"
+	"github.com/canonical/microcluster/state"
+
+	"github.com/canonical/snap-openstack/sunbeam-microcluster/api/types"
+	"github.com/canonical/snap-openstack/sunbeam-microcluster/database"
+)
+
+const (
+	// JujuAuditCreate is the audit action of the creation of a juju user
+	JujuAuditCreate = "create"
+	// JujuAuditDelete is the audit action of the deletion of a juju user
+	JujuAuditDelete = "delete"
+	// JujuAuditRotate is the audit action of the rotation of a juju user token
+	JujuAuditRotate = "rotate"
+	// JujuAuditAuthenticate is the audit action of a request authenticated with a juju user token
+	JujuAuditAuthenticate = "authenticate"
+)
+
+// jujuAuditActions are the valid juju audit actions
+var jujuAuditActions = map[string]bool{
+	JujuAuditCreate:       true,
+	JujuAuditDelete:       true,
+	JujuAuditRotate:       true,
+	JujuAuditAuthenticate: true,
+}
+
+// JujuAuditRetentionDaysKey is the config key holding the number of days the juju audit log is kept
+const JujuAuditRetentionDaysKey = "config.juju-audit-retention-days"
+
+// defaultJujuAuditRetentionDays is used when JujuAuditRetentionDaysKey is not set
+const defaultJujuAuditRetentionDays = 90
+
+// RecordJujuAudit records action on the juju user by the request r in the juju audit log.
+// Failures are logged rather than returned so that auditing never fails the request.
+func RecordJujuAudit(s *state.State, r *http.Request, username string, action string) {
+	sourceIP, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		sourceIP = r.RemoteAddr
+	}
+
+	err = s.Database.Transaction(s.Context, func(ctx context.Context, tx *sql.Tx) error {
+		return database.CreateJujuAuditEntry(ctx, tx, database.JujuAuditEntry{
+			Username:  username,
+			Action:    action,
+			SourceIP:  sourceIP,
+			UserAgent: r.UserAgent(),
+		})
+	})
+	if err != nil {
+		logger.Warnf("Failed to record juju audit %q of %q: %v", action, username, err)
+	}
+}
+
+// GetJujuAudit returns the juju audit log of the user, or of all users if username is nil, most recent first.
+// Only entries of action are returned if not nil, entries older than since are excluded if not nil,
+// and at most limit entries are returned if positive.
+func GetJujuAudit(s *state.State, username *string, action *string, since *time.Time, limit int) (types.JujuAudit, error) {
+	if action != nil && !jujuAuditActions[*action] {
+		return nil, api.StatusErrorf(http.StatusBadRequest, "Invalid juju audit action %q", *action)
+	}
+
+	audit := types.JujuAudit{}
+
+	err := s.Database.Transaction(s.Context, func(ctx context.Context, tx *sql.Tx) error {
+		records, err := database.GetJujuAudit(ctx, tx, database.JujuAuditFilter{Username: username, Action: action, Since: since, Limit: limit})
+		if err != nil {
+			return err
+		}
+
+		for _, record := range records {
+			occurredAt, err := parseDBTimestamp(record.OccurredAt)
+			if err != nil {
+				return err
+			}
+
+			audit = append(audit, types.JujuAuditEntry{
+				Username:   record.Username,
+				Action:     record.Action,
+				SourceIP:   record.SourceIP,
+				UserAgent:  record.UserAgent,
+				OccurredAt: occurredAt,
+			})
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return audit, nil
+}
+
+// jujuAuditRetention returns how long the juju audit log is kept from config, or the default if unset or invalid
+func jujuAuditRetention(s *state.State) (time.Duration, error) {
+	value, exists, err := GetConfig(s, JujuAuditRetentionDaysKey)
+	if err != nil {
+		return 0, err
+	}
+
+	days := defaultJujuAuditRetentionDays
+	if exists {
+		days, err = strconv.Atoi(value)
+		if err != nil || days <= 0 {
+			logger.Warnf("Invalid %s %q, using default of %d", JujuAuditRetentionDaysKey, value, defaultJujuAuditRetentionDays)
+			days = defaultJujuAuditRetentionDays
+		}
+	}
+
+	return time.Duration(days) * 24 * time.Hour, nil
+}
+
+// PruneJujuAudit deletes the juju audit log older than its retention period and returns how many entries were deleted
+func PruneJujuAudit(s *state.State) (int64, error) {
+	retention, err := jujuAuditRetention(s)
+	if err != nil {
+		return 0, err
+	}
+
+	var deleted int64
+	err = s.Database.Transaction(s.Context, func(ctx context.Context, tx *sql.Tx) error {
+		deleted, err = database.DeleteJujuAuditBefore(ctx, tx, time.Now().Add(-retention))
+		return err
+	})
+	if err != nil {
+		return 0, fmt.Errorf("Failed to prune juju audit log: %w", err)
+	}
+
+	return deleted, nil
+}
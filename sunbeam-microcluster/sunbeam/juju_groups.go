@@ -0,0 +1,126 @@
+package sunbeam
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+
+	"github.com/canonical/lxd/shared/api"
+	"github.com/canonical/lxd/shared/logger"
+	"github.com/canonical/microcluster/state"
+
+	"github.com/canonical/snap-openstack/sunbeam-microcluster/database"
+)
+
+const (
+	// JujuGroupAdmin is the built-in group with full access
+	JujuGroupAdmin = "admin"
+	// JujuGroupReadonly is the built-in group allowed GET requests only
+	JujuGroupReadonly = "readonly"
+)
+
+// jujuGroupAllMethods is the permission allowing all HTTP methods
+const jujuGroupAllMethods = "*"
+
+// JujuGroupAccessKey is the reserved config key enabling the group checks of the juju users
+// of requests verified against the cluster CA. It is off unless set to true on bootstrap,
+// as clients verified against the cluster CA do not send juju user credentials otherwise.
+const JujuGroupAccessKey = "cluster.juju-group-access"
+
+// JujuGroupAccessEnabled returns whether JujuGroupAccessKey enables the group checks, off if unset or invalid
+func JujuGroupAccessEnabled(s *state.State) (bool, error) {
+	value, exists, err := GetConfig(s, JujuGroupAccessKey)
+	if err != nil || !exists {
+		return false, err
+	}
+
+	enabled, err := strconv.ParseBool(value)
+	if err != nil {
+		logger.Warnf("Invalid %s %q, juju group access is off", JujuGroupAccessKey, value)
+		return false, nil
+	}
+
+	return enabled, nil
+}
+
+// AddJujuUserGroup adds the juju user to group
+func AddJujuUserGroup(s *state.State, name string, group string) error {
+	return s.Database.Transaction(s.Context, func(ctx context.Context, tx *sql.Tx) error {
+		_, err := database.GetJujuUser(ctx, tx, name)
+		if err != nil {
+			return err
+		}
+
+		return database.AddJujuUserToGroup(ctx, tx, name, group)
+	})
+}
+
+// RemoveJujuUserGroup removes the juju user from group
+func RemoveJujuUserGroup(s *state.State, name string, group string) error {
+	return s.Database.Transaction(s.Context, func(ctx context.Context, tx *sql.Tx) error {
+		return database.RemoveJujuUserFromGroup(ctx, tx, name, group)
+	})
+}
+
+// CheckJujuUserAccess returns a Forbidden error if the juju user may not send a request with the
+// given HTTP method to an endpoint requiring membership of one of the required groups, if any
+func CheckJujuUserAccess(s *state.State, name string, method string, required []string) error {
+	return s.Database.Transaction(s.Context, func(ctx context.Context, tx *sql.Tx) error {
+		return checkJujuUserAccess(ctx, tx, name, method, required)
+	})
+}
+
+// checkJujuUserAccess is CheckJujuUserAccess within the transaction tx
+func checkJujuUserAccess(ctx context.Context, tx *sql.Tx, name string, method string, required []string) error {
+	permissions, err := database.GetJujuUserGroupPermissions(ctx, tx, name)
+	if err != nil {
+		return err
+	}
+
+	if !jujuGroupsAllow(permissions, method, required) {
+		return api.StatusErrorf(http.StatusForbidden, "Juju user %q is not allowed to %s this endpoint", name, method)
+	}
+
+	return nil
+}
+
+// jujuGroupsAllow returns whether a member of the groups with the given permissions may send a request
+// with the given HTTP method to an endpoint requiring membership of one of the required groups.
+// Without required groups, members of no group keep full access.
+func jujuGroupsAllow(permissions map[string][]string, method string, required []string) bool {
+	if len(required) == 0 && len(permissions) == 0 {
+		return true
+	}
+
+	for group, methods := range permissions {
+		if len(required) > 0 && !slices.Contains(required, group) {
+			continue
+		}
+
+		if slices.Contains(methods, jujuGroupAllMethods) || slices.Contains(methods, method) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// jujuUserGroups returns the sorted names of the groups of the juju user
+func jujuUserGroups(ctx context.Context, tx *sql.Tx, name string) ([]string, error) {
+	permissions, err := database.GetJujuUserGroupPermissions(ctx, tx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	groups := make([]string, 0, len(permissions))
+	for group := range permissions {
+		groups = append(groups, group)
+	}
+
+	sort.Strings(groups)
+
+	return groups, nil
+}
@@ -2,34 +2,47 @@ package sunbeam
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/subtle"
 	"database/sql"
+	"encoding/hex"
 	"fmt"
+	"net/http"
+	"strconv"
+	"time"
 
+	"github.com/canonical/lxd/shared/api"
+	"github.com/canonical/lxd/shared/logger"
 	"github.com/canonical/microcluster/state"
 
 	"github.com/canonical/snap-openstack/sunbeam-microcluster/api/types"
 	"github.com/canonical/snap-openstack/sunbeam-microcluster/database"
 )
 
-// ListJujuUsers returns the jujuusers from the database
+// JujuTokenGracePeriodKey is the config key holding the number of seconds a rotated juju user token stays valid
+const JujuTokenGracePeriodKey = "config.juju-token-grace-seconds"
+
+// defaultJujuTokenGracePeriodSeconds is used when JujuTokenGracePeriodKey is not set
+const defaultJujuTokenGracePeriodSeconds = 60
+
+// JujuTokenTTLKey is the config key holding how long a juju user token is valid, as a duration
+const JujuTokenTTLKey = "config.juju-token-ttl"
+
+// defaultJujuTokenTTL is used when JujuTokenTTLKey is not set
+const defaultJujuTokenTTL = 90 * 24 * time.Hour
+
+// jujuTokenBytes is the number of random bytes of a generated juju user token
+const jujuTokenBytes = 32
+
+// ListJujuUsers returns the jujuusers from the database, without their tokens
 func ListJujuUsers(s *state.State) (types.JujuUsers, error) {
-	users := types.JujuUsers{}
+	var users types.JujuUsers
 
 	// Get the juju users from the database.
 	err := s.Database.Transaction(s.Context, func(ctx context.Context, tx *sql.Tx) error {
-		records, err := database.GetJujuUsers(ctx, tx)
-		if err != nil {
-			return fmt.Errorf("Failed to fetch juju user: %w", err)
-		}
-
-		for _, user := range records {
-			users = append(users, types.JujuUser{
-				Username: user.Username,
-				Token:    user.Token,
-			})
-		}
-
-		return nil
+		var err error
+		users, err = listJujuUsers(ctx, tx, time.Now())
+		return err
 	})
 	if err != nil {
 		return nil, err
@@ -38,6 +51,21 @@ func ListJujuUsers(s *state.State) (types.JujuUsers, error) {
 	return users, nil
 }
 
+// listJujuUsers returns the jujuusers from the database without their tokens, expired as of now
+func listJujuUsers(ctx context.Context, tx *sql.Tx, now time.Time) (types.JujuUsers, error) {
+	records, err := database.GetJujuUsers(ctx, tx)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to fetch juju user: %w", err)
+	}
+
+	users := types.JujuUsers{}
+	for _, record := range records {
+		users = append(users, jujuUserFromRecord(record, now))
+	}
+
+	return users, nil
+}
+
 // GetJujuUser returns a JujuUser with the given name
 func GetJujuUser(s *state.State, name string) (types.JujuUser, error) {
 	jujuUser := types.JujuUser{}
@@ -47,25 +75,44 @@ func GetJujuUser(s *state.State, name string) (types.JujuUser, error) {
 			return err
 		}
 
-		jujuUser.Username = record.Username
+		jujuUser = jujuUserFromRecord(*record, time.Now())
 		jujuUser.Token = record.Token
 
-		return nil
+		jujuUser.Groups, err = jujuUserGroups(ctx, tx, record.Username)
+		return err
 	})
 
 	return jujuUser, err
 }
 
-// AddJujuUser adds a Jujuuser to the database
+// jujuUserFromRecord returns the juju user of record without its token,
+// with whether its token is expired as of now
+func jujuUserFromRecord(record database.JujuUser, now time.Time) types.JujuUser {
+	jujuUser := types.JujuUser{Username: record.Username}
+
+	if record.CreatedAt.Valid {
+		jujuUser.CreatedAt = record.CreatedAt.Time
+	}
+
+	if record.ExpiresAt.Valid {
+		expiresAt := record.ExpiresAt.Time
+		jujuUser.ExpiresAt = &expiresAt
+		jujuUser.IsExpired = expiresAt.Before(now)
+	}
+
+	return jujuUser
+}
+
+// AddJujuUser adds a Jujuuser to the database, its token expiring after the token TTL
 func AddJujuUser(s *state.State, name string, token string) error {
-	// Add juju user to the database.
-	err := s.Database.Transaction(s.Context, func(ctx context.Context, tx *sql.Tx) error {
-		_, err := database.CreateJujuUser(ctx, tx, database.JujuUser{Username: name, Token: token})
-		if err != nil {
-			return fmt.Errorf("Failed to record juju user: %w", err)
-		}
+	ttl, err := jujuTokenTTL(s)
+	if err != nil {
+		return err
+	}
 
-		return nil
+	// Add juju user to the database.
+	err = s.Database.Transaction(s.Context, func(ctx context.Context, tx *sql.Tx) error {
+		return addJujuUser(ctx, tx, name, token, time.Now(), ttl)
 	})
 	if err != nil {
 		return err
@@ -74,11 +121,33 @@ func AddJujuUser(s *state.State, name string, token string) error {
 	return nil
 }
 
+// addJujuUser records the juju user created at now, its token expiring after ttl
+func addJujuUser(ctx context.Context, tx *sql.Tx, name string, token string, now time.Time, ttl time.Duration) error {
+	now = now.UTC().Truncate(time.Second)
+
+	_, err := database.CreateJujuUser(ctx, tx, database.JujuUser{
+		Username:  name,
+		Token:     token,
+		CreatedAt: sql.NullTime{Time: now, Valid: true},
+		ExpiresAt: sql.NullTime{Time: now.Add(ttl), Valid: true},
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to record juju user: %w", err)
+	}
+
+	return nil
+}
+
 // DeleteJujuUser deletes the juju user record from the database
 func DeleteJujuUser(s *state.State, name string) error {
 	// Delete juju user from the database.
 	err := s.Database.Transaction(s.Context, func(ctx context.Context, tx *sql.Tx) error {
-		err := database.DeleteJujuUser(ctx, tx, name)
+		err := database.DeleteJujuUserGroups(ctx, tx, name)
+		if err != nil {
+			return err
+		}
+
+		err = database.DeleteJujuUser(ctx, tx, name)
 		if err != nil {
 			return fmt.Errorf("Failed to delete juju user: %w", err)
 		}
@@ -91,3 +160,195 @@ func DeleteJujuUser(s *state.State, name string) error {
 
 	return nil
 }
+
+// RotateJujuUserToken replaces the token of the juju user with a new random one and returns the user
+// with the new token, expiring after the token TTL. The old token is recorded in the token history
+// and stays valid for the grace period.
+func RotateJujuUserToken(s *state.State, name string) (types.JujuUser, error) {
+	token, err := generateJujuToken()
+	if err != nil {
+		return types.JujuUser{}, err
+	}
+
+	ttl, err := jujuTokenTTL(s)
+	if err != nil {
+		return types.JujuUser{}, err
+	}
+
+	err = s.Database.Transaction(s.Context, func(ctx context.Context, tx *sql.Tx) error {
+		record, err := database.GetJujuUser(ctx, tx, name)
+		if err != nil {
+			return err
+		}
+
+		err = database.CreateJujuTokenHistoryEntry(ctx, tx, name, record.Token)
+		if err != nil {
+			return err
+		}
+
+		record.Token = token
+		record.ExpiresAt = sql.NullTime{Time: time.Now().UTC().Truncate(time.Second).Add(ttl), Valid: true}
+		err = database.UpdateJujuUser(ctx, tx, name, *record)
+		if err != nil {
+			return fmt.Errorf("Failed to update juju user: %w", err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return types.JujuUser{}, err
+	}
+
+	return GetJujuUser(s, name)
+}
+
+// ValidateJujuUserToken returns whether token is the current token of the juju user,
+// or one of its tokens rotated less than the grace period ago. An expired current token
+// is an Unauthorized error.
+func ValidateJujuUserToken(s *state.State, name string, token string) (bool, error) {
+	gracePeriod, err := jujuTokenGracePeriod(s)
+	if err != nil {
+		return false, err
+	}
+
+	valid := false
+	err = s.Database.Transaction(s.Context, func(ctx context.Context, tx *sql.Tx) error {
+		valid, err = validateJujuUserToken(ctx, tx, name, token, time.Now(), gracePeriod)
+		return err
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return valid, nil
+}
+
+// validateJujuUserToken is ValidateJujuUserToken at now within the transaction tx
+func validateJujuUserToken(ctx context.Context, tx *sql.Tx, name string, token string, now time.Time, gracePeriod time.Duration) (bool, error) {
+	record, err := database.GetJujuUser(ctx, tx, name)
+	if err != nil {
+		return false, err
+	}
+
+	if subtle.ConstantTimeCompare([]byte(record.Token), []byte(token)) == 1 {
+		jujuUser := jujuUserFromRecord(*record, now)
+		if jujuUser.IsExpired {
+			return false, api.StatusErrorf(http.StatusUnauthorized, "Token of juju user %q expired at %s", name, jujuUser.ExpiresAt.Format(time.RFC3339))
+		}
+
+		return true, nil
+	}
+
+	return database.JujuTokenRevokedSince(ctx, tx, name, token, now.Add(-gracePeriod))
+}
+
+// AuthenticateJujuUser returns an Unauthorized error if token is not a valid token of the juju user,
+// and a Forbidden error if its groups do not allow the request r to an endpoint requiring membership
+// of one of the required groups. Authenticated requests are recorded in the juju audit log.
+func AuthenticateJujuUser(s *state.State, r *http.Request, name string, token string, required []string) error {
+	gracePeriod, err := jujuTokenGracePeriod(s)
+	if err != nil {
+		return err
+	}
+
+	err = s.Database.Transaction(s.Context, func(ctx context.Context, tx *sql.Tx) error {
+		return authenticateJujuUser(ctx, tx, name, token, r.Method, required, time.Now(), gracePeriod)
+	})
+	if err != nil {
+		return err
+	}
+
+	RecordJujuAudit(s, r, name, JujuAuditAuthenticate)
+
+	return nil
+}
+
+// authenticateJujuUser checks the token of the juju user at now and whether its groups allow
+// a request with the given HTTP method to an endpoint requiring one of the required groups,
+// within the transaction tx
+func authenticateJujuUser(ctx context.Context, tx *sql.Tx, name string, token string, method string, required []string, now time.Time, gracePeriod time.Duration) error {
+	valid, err := validateJujuUserToken(ctx, tx, name, token, now, gracePeriod)
+	if err != nil {
+		if api.StatusErrorCheck(err, http.StatusNotFound) {
+			return api.StatusErrorf(http.StatusUnauthorized, "Unknown juju user %q", name)
+		}
+
+		return err
+	}
+
+	if !valid {
+		return api.StatusErrorf(http.StatusUnauthorized, "Invalid token for juju user %q", name)
+	}
+
+	return checkJujuUserAccess(ctx, tx, name, method, required)
+}
+
+// PruneJujuTokenHistory deletes the tokens rotated more than the grace period ago from the
+// juju token history, as they are no longer valid, and returns how many were deleted.
+// Juju users are never deleted, an expired token is only rejected when validated.
+func PruneJujuTokenHistory(s *state.State) (int64, error) {
+	gracePeriod, err := jujuTokenGracePeriod(s)
+	if err != nil {
+		return 0, err
+	}
+
+	var deleted int64
+	err = s.Database.Transaction(s.Context, func(ctx context.Context, tx *sql.Tx) error {
+		deleted, err = database.DeleteJujuTokenHistoryBefore(ctx, tx, time.Now().Add(-gracePeriod))
+		return err
+	})
+	if err != nil {
+		return 0, fmt.Errorf("Failed to prune juju token history: %w", err)
+	}
+
+	return deleted, nil
+}
+
+// jujuTokenTTL returns how long a juju user token is valid from config, or the default if unset or invalid
+func jujuTokenTTL(s *state.State) (time.Duration, error) {
+	value, exists, err := GetConfig(s, JujuTokenTTLKey)
+	if err != nil {
+		return 0, err
+	}
+
+	ttl := defaultJujuTokenTTL
+	if exists {
+		ttl, err = time.ParseDuration(value)
+		if err != nil || ttl <= 0 {
+			logger.Warnf("Invalid %s %q, using default of %s", JujuTokenTTLKey, value, defaultJujuTokenTTL)
+			ttl = defaultJujuTokenTTL
+		}
+	}
+
+	return ttl, nil
+}
+
+// jujuTokenGracePeriod returns how long a rotated juju user token stays valid from config, or the default if unset or invalid
+func jujuTokenGracePeriod(s *state.State) (time.Duration, error) {
+	value, exists, err := GetConfig(s, JujuTokenGracePeriodKey)
+	if err != nil {
+		return 0, err
+	}
+
+	seconds := defaultJujuTokenGracePeriodSeconds
+	if exists {
+		seconds, err = strconv.Atoi(value)
+		if err != nil || seconds < 0 {
+			logger.Warnf("Invalid %s %q, using default of %d", JujuTokenGracePeriodKey, value, defaultJujuTokenGracePeriodSeconds)
+			seconds = defaultJujuTokenGracePeriodSeconds
+		}
+	}
+
+	return time.Duration(seconds) * time.Second, nil
+}
+
+// generateJujuToken returns a new random juju user token
+func generateJujuToken() (string, error) {
+	token := make([]byte, jujuTokenBytes)
+	_, err := rand.Read(token)
+	if err != nil {
+		return "", fmt.Errorf("Failed to generate token: %w", err)
+	}
+
+	return hex.EncodeToString(token), nil
+}
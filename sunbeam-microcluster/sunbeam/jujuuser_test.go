@@ -0,0 +1,156 @@
+package sunbeam
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/canonical/lxd/shared/api"
+
+	"github.com/canonical/snap-openstack/sunbeam-microcluster/database"
+)
+
+func TestListJujuUsers(t *testing.T) {
+	tx := database.NewTestSchemaTx(t)
+	ctx := context.Background()
+	now := time.Now().UTC().Truncate(time.Second)
+
+	err := addJujuUser(ctx, tx, "alice", "token-a", now, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = addJujuUser(ctx, tx, "bob", "token-b", now.Add(-2*time.Hour), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Users created before the creation and expiry times were recorded, and by the previous release.
+	_, err = tx.Exec(`INSERT INTO jujuuser (username, token) VALUES ('carol', 'token-c')`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = tx.Exec(`INSERT INTO jujuuser (username, token, created_at, expires_at) VALUES ('dave', 'token-d', CURRENT_TIMESTAMP, ?)`,
+		now.Add(time.Hour).Format(time.DateTime))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	users, err := listJujuUsers(ctx, tx, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(users) != 4 {
+		t.Fatalf("Listed %d juju users, want 4", len(users))
+	}
+
+	alice, bob, carol, dave := users[0], users[1], users[2], users[3]
+	if alice.Username != "alice" || bob.Username != "bob" || carol.Username != "carol" || dave.Username != "dave" {
+		t.Errorf("Listed juju users %q, %q, %q and %q, want alice, bob, carol and dave", alice.Username, bob.Username, carol.Username, dave.Username)
+	}
+
+	for _, user := range users {
+		if user.Token != "" {
+			t.Errorf("Listed juju user %q with its token", user.Username)
+		}
+	}
+
+	if !alice.CreatedAt.Equal(now) || alice.ExpiresAt == nil || !alice.ExpiresAt.Equal(now.Add(time.Hour)) || alice.IsExpired {
+		t.Errorf("Juju user alice is %+v, want created now and expiring in an hour", alice)
+	}
+
+	if bob.ExpiresAt == nil || !bob.IsExpired {
+		t.Errorf("Juju user bob is %+v, want expired", bob)
+	}
+
+	if !carol.CreatedAt.IsZero() || carol.ExpiresAt != nil || carol.IsExpired {
+		t.Errorf("Juju user carol is %+v, want no creation or expiry time", carol)
+	}
+
+	if dave.CreatedAt.IsZero() || dave.ExpiresAt == nil || !dave.ExpiresAt.Equal(now.Add(time.Hour)) {
+		t.Errorf("Juju user dave is %+v, want its creation time and expiring in an hour", dave)
+	}
+}
+
+func TestAuthenticateJujuUser(t *testing.T) {
+	tx := database.NewTestSchemaTx(t)
+	ctx := context.Background()
+	now := time.Now().UTC().Truncate(time.Second)
+
+	for _, user := range []struct{ name, group string }{{"alice", JujuGroupAdmin}, {"bob", JujuGroupReadonly}, {"carol", ""}} {
+		err := addJujuUser(ctx, tx, user.name, "token-"+user.name, now, time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if user.group != "" {
+			err = database.AddJujuUserToGroup(ctx, tx, user.name, user.group)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	err := addJujuUser(ctx, tx, "dave", "token-dave", now.Add(-2*time.Hour), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = database.AddJujuUserToGroup(ctx, tx, "dave", JujuGroupAdmin)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Rotate the token of alice, keeping the old one valid for the grace period.
+	err = database.CreateJujuTokenHistoryEntry(ctx, tx, "alice", "token-alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = tx.Exec(`UPDATE jujuuser SET token = 'token-alice-2' WHERE username = 'alice'`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	required := []string{JujuGroupAdmin, JujuGroupReadonly}
+
+	tests := []struct {
+		name        string
+		user        string
+		token       string
+		method      string
+		after       time.Duration
+		gracePeriod time.Duration
+		want        int
+	}{
+		{name: "admin POST", user: "alice", token: "token-alice-2", method: http.MethodPost, want: http.StatusOK},
+		{name: "readonly GET", user: "bob", token: "token-bob", method: http.MethodGet, want: http.StatusOK},
+		{name: "readonly POST", user: "bob", token: "token-bob", method: http.MethodPost, want: http.StatusForbidden},
+		{name: "no groups", user: "carol", token: "token-carol", method: http.MethodGet, want: http.StatusForbidden},
+		{name: "expired token", user: "dave", token: "token-dave", method: http.MethodGet, want: http.StatusUnauthorized},
+		{name: "unknown user", user: "erin", token: "token-erin", method: http.MethodGet, want: http.StatusUnauthorized},
+		{name: "wrong token", user: "bob", token: "token-alice-2", method: http.MethodGet, want: http.StatusUnauthorized},
+		{name: "rotated token within grace period", user: "alice", token: "token-alice", method: http.MethodPost, gracePeriod: time.Minute, want: http.StatusOK},
+		{name: "rotated token after grace period", user: "alice", token: "token-alice", method: http.MethodPost, after: 2 * time.Minute, gracePeriod: time.Minute, want: http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := authenticateJujuUser(ctx, tx, tt.user, tt.token, tt.method, required, time.Now().Add(tt.after), tt.gracePeriod)
+			if tt.want == http.StatusOK {
+				if err != nil {
+					t.Errorf("Authenticating %s of %q failed: %v", tt.method, tt.user, err)
+				}
+
+				return
+			}
+
+			if !api.StatusErrorCheck(err, tt.want) {
+				t.Errorf("Authenticating %s of %q returned %v, want status %d", tt.method, tt.user, err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,171 @@
+package sunbeam
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/canonical/lxd/shared/api"
+	"github.com/canonical/microcluster/state"
+	"gopkg.in/yaml.v3"
+
+	"github.com/canonical/snap-openstack/sunbeam-microcluster/api/types"
+)
+
+const (
+	// ManifestChangeAdded is the operation of a value only in the newer manifest
+	ManifestChangeAdded = "added"
+	// ManifestChangeRemoved is the operation of a value only in the older manifest
+	ManifestChangeRemoved = "removed"
+	// ManifestChangeChanged is the operation of a value differing between the manifests
+	ManifestChangeChanged = "changed"
+)
+
+// DiffManifests returns the structural differences between the data of the manifests
+// with ids from and to, sorted by path.
+func DiffManifests(s *state.State, from string, to string) (types.ManifestDiff, error) {
+	diff := types.ManifestDiff{From: from, To: to, Changes: []types.ManifestChange{}}
+
+	fromData, err := manifestDiffData(s, from, "from")
+	if err != nil {
+		return diff, err
+	}
+
+	toData, err := manifestDiffData(s, to, "to")
+	if err != nil {
+		return diff, err
+	}
+
+	diffManifestValues("", fromData, toData, &diff.Changes)
+
+	return diff, nil
+}
+
+// manifestDiffData returns the parsed data of the manifest with the given id, side naming it in errors
+func manifestDiffData(s *state.State, manifestid string, side string) (any, error) {
+	manifest, err := GetManifest(s, manifestid)
+	if err != nil {
+		if api.StatusErrorCheck(err, http.StatusNotFound) {
+			return nil, api.StatusErrorf(http.StatusNotFound, "Manifest %q given as %s not found", manifestid, side)
+		}
+
+		return nil, err
+	}
+
+	// Manifest data is JSON or YAML, YAML being a superset of JSON.
+	var data any
+	err = yaml.Unmarshal([]byte(manifest.Data), &data)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse data of manifest %q: %w", manifestid, err)
+	}
+
+	return data, nil
+}
+
+// diffManifestValues appends to changes the differences between the values from and to at path
+func diffManifestValues(path string, from any, to any, changes *[]types.ManifestChange) {
+	fromMap, fromIsMap := from.(map[string]any)
+	toMap, toIsMap := to.(map[string]any)
+	if fromIsMap && toIsMap {
+		keys := make([]string, 0, len(fromMap)+len(toMap))
+		for key := range fromMap {
+			keys = append(keys, key)
+		}
+
+		for key := range toMap {
+			_, ok := fromMap[key]
+			if !ok {
+				keys = append(keys, key)
+			}
+		}
+
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			fromValue, inFrom := fromMap[key]
+			toValue, inTo := toMap[key]
+			keyPath := manifestDiffPath(path, key)
+
+			switch {
+			case !inFrom:
+				*changes = append(*changes, types.ManifestChange{Path: keyPath, Op: ManifestChangeAdded, To: toValue})
+			case !inTo:
+				*changes = append(*changes, types.ManifestChange{Path: keyPath, Op: ManifestChangeRemoved, From: fromValue})
+			default:
+				diffManifestValues(keyPath, fromValue, toValue, changes)
+			}
+		}
+
+		return
+	}
+
+	fromList, fromIsList := from.([]any)
+	toList, toIsList := to.([]any)
+	if fromIsList && toIsList {
+		for i := 0; i < len(fromList) || i < len(toList); i++ {
+			indexPath := fmt.Sprintf("%s[%d]", path, i)
+
+			switch {
+			case i >= len(fromList):
+				*changes = append(*changes, types.ManifestChange{Path: indexPath, Op: ManifestChangeAdded, To: toList[i]})
+			case i >= len(toList):
+				*changes = append(*changes, types.ManifestChange{Path: indexPath, Op: ManifestChangeRemoved, From: fromList[i]})
+			default:
+				diffManifestValues(indexPath, fromList[i], toList[i], changes)
+			}
+		}
+
+		return
+	}
+
+	if !reflect.DeepEqual(from, to) {
+		*changes = append(*changes, types.ManifestChange{Path: path, Op: ManifestChangeChanged, From: from, To: to})
+	}
+}
+
+// manifestDiffPath returns the path of key in the value at path
+func manifestDiffPath(path string, key string) string {
+	if path == "" {
+		return key
+	}
+
+	return path + "." + key
+}
+
+// FormatManifestDiff renders the diff as a human readable unified diff, one line per removed or added value
+func FormatManifestDiff(diff types.ManifestDiff) string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "--- manifest %s\n", diff.From)
+	fmt.Fprintf(&b, "+++ manifest %s\n", diff.To)
+
+	for _, change := range diff.Changes {
+		path := change.Path
+		if path == "" {
+			path = "."
+		}
+
+		if change.Op != ManifestChangeAdded {
+			fmt.Fprintf(&b, "-%s: %s\n", path, manifestDiffValue(change.From))
+		}
+
+		if change.Op != ManifestChangeRemoved {
+			fmt.Fprintf(&b, "+%s: %s\n", path, manifestDiffValue(change.To))
+		}
+	}
+
+	return b.String()
+}
+
+// manifestDiffValue renders a manifest value on a single line
+func manifestDiffValue(value any) string {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprintf("%v", value)
+	}
+
+	return string(data)
+}
@@ -0,0 +1,113 @@
+package sunbeam
+
+import (
+	"reflect"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/canonical/snap-openstack/sunbeam-microcluster/api/types"
+)
+
+// parseManifestData parses manifest data as manifestDiffData does.
+func parseManifestData(t *testing.T, data string) any {
+	t.Helper()
+
+	var value any
+	err := yaml.Unmarshal([]byte(data), &value)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return value
+}
+
+func TestDiffManifestValues(t *testing.T) {
+	tests := []struct {
+		name string
+		from string
+		to   string
+		want []types.ManifestChange
+	}{
+		{
+			name: "identical",
+			from: "core:\n  software:\n    charms: {}\n",
+			to:   `{"core": {"software": {"charms": {}}}}`,
+			want: []types.ManifestChange{},
+		},
+		{
+			name: "nested keys",
+			from: "a:\n  b: 1\n  c: x\n",
+			to:   "a:\n  b: 2\n  d: y\n",
+			want: []types.ManifestChange{
+				{Path: "a.b", Op: ManifestChangeChanged, From: 1, To: 2},
+				{Path: "a.c", Op: ManifestChangeRemoved, From: "x"},
+				{Path: "a.d", Op: ManifestChangeAdded, To: "y"},
+			},
+		},
+		{
+			name: "lists",
+			from: "a: [1, 2, 3]\n",
+			to:   "a: [1, 4]\n",
+			want: []types.ManifestChange{
+				{Path: "a[1]", Op: ManifestChangeChanged, From: 2, To: 4},
+				{Path: "a[2]", Op: ManifestChangeRemoved, From: 3},
+			},
+		},
+		{
+			name: "type change",
+			from: "a: {b: 1}\n",
+			to:   "a: [1]\n",
+			want: []types.ManifestChange{
+				{Path: "a", Op: ManifestChangeChanged, From: map[string]any{"b": 1}, To: []any{1}},
+			},
+		},
+		{
+			name: "root",
+			from: "a\n",
+			to:   "b\n",
+			want: []types.ManifestChange{
+				{Path: "", Op: ManifestChangeChanged, From: "a", To: "b"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			changes := []types.ManifestChange{}
+			diffManifestValues("", parseManifestData(t, tt.from), parseManifestData(t, tt.to), &changes)
+
+			if !reflect.DeepEqual(changes, tt.want) {
+				t.Errorf("diffManifestValues returned %v, want %v", changes, tt.want)
+			}
+		})
+	}
+}
+
+func TestFormatManifestDiff(t *testing.T) {
+	diff := types.ManifestDiff{
+		From: "1",
+		To:   "2",
+		Changes: []types.ManifestChange{
+			{Path: "a.b", Op: ManifestChangeChanged, From: 1, To: 2},
+			{Path: "a.c", Op: ManifestChangeRemoved, From: "x"},
+			{Path: "a.d", Op: ManifestChangeAdded, To: map[string]any{"e": true}},
+			{Path: "", Op: ManifestChangeChanged, From: "a", To: "b"},
+		},
+	}
+
+	want := `--- manifest 1
++++ manifest 2
+-a.b: 1
++a.b: 2
+-a.c: "x"
++a.d: {"e":true}
+-.: "a"
++.: "b"
+`
+
+	got := FormatManifestDiff(diff)
+	if got != want {
+		t.Errorf("FormatManifestDiff returned:\n%s\nwant:\n%s", got, want)
+	}
+}
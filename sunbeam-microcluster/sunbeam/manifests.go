@@ -2,41 +2,133 @@ package sunbeam
 
 import (
 	"context"
+	"crypto/rand"
 	"database/sql"
+	"encoding/hex"
 	"fmt"
+	"net/http"
+	"time"
 
+	"github.com/canonical/lxd/shared/api"
 	"github.com/canonical/microcluster/state"
 
 	"github.com/canonical/snap-openstack/sunbeam-microcluster/api/types"
 	"github.com/canonical/snap-openstack/sunbeam-microcluster/database"
 )
 
-// ListManifests return all the manifests
-func ListManifests(s *state.State) (types.Manifests, error) {
+const (
+	// ManifestApplied is the status of a manifest applied successfully
+	ManifestApplied = "applied"
+	// ManifestFailed is the status of a manifest whose application failed
+	ManifestFailed = "failed"
+	// ManifestPending is the status of a manifest being applied
+	ManifestPending = "pending"
+)
+
+// validManifestStatuses are the application statuses a manifest can have
+var validManifestStatuses = map[string]bool{
+	ManifestApplied: true,
+	ManifestFailed:  true,
+	ManifestPending: true,
+}
+
+// validateManifestStatus checks status is a valid manifest application status
+func validateManifestStatus(status string) error {
+	if !validManifestStatuses[status] {
+		return api.StatusErrorf(http.StatusBadRequest, "Invalid manifest status %q, expected one of applied, failed or pending", status)
+	}
+
+	return nil
+}
+
+// MaxManifestPageSize is the maximum number of manifests listed per page
+const MaxManifestPageSize = 200
+
+// ManifestFilter selects the manifests returned by ListManifests
+type ManifestFilter struct {
+	// Status of the manifests, all statuses if empty
+	Status string
+	// Search is a substring of the manifest ids, all manifests if empty
+	Search string
+	// Since excludes the manifests applied before it, if not nil
+	Since *time.Time
+	// Until excludes the manifests applied after it, if not nil
+	Until *time.Time
+	// Page is the 1-based page of manifests returned, all manifests if not positive
+	Page int
+	// PageSize is the number of manifests per page, at most MaxManifestPageSize
+	PageSize int
+}
+
+// ListManifests returns the manifests matching filter sorted by manifest id,
+// along with the total number of manifests matching it across all pages.
+func ListManifests(ctx context.Context, s *state.State, filter ManifestFilter) (types.Manifests, int, error) {
+	dbFilter := database.ManifestItemPageFilter{
+		Search: filter.Search,
+		Since:  filter.Since,
+		Until:  filter.Until,
+	}
+
+	if filter.Status != "" {
+		err := validateManifestStatus(filter.Status)
+		if err != nil {
+			return nil, -1, err
+		}
+
+		dbFilter.Status = &filter.Status
+	}
+
+	var offset, limit int
+	if filter.Page > 0 {
+		if filter.PageSize <= 0 || filter.PageSize > MaxManifestPageSize {
+			return nil, -1, api.StatusErrorf(http.StatusBadRequest, "Invalid page size %d, expected between 1 and %d", filter.PageSize, MaxManifestPageSize)
+		}
+
+		offset = (filter.Page - 1) * filter.PageSize
+		limit = filter.PageSize
+	}
+
 	manifests := types.Manifests{}
+	var total int
 
 	// Get the manifests from the database.
-	err := s.Database.Transaction(s.Context, func(ctx context.Context, tx *sql.Tx) error {
-		records, err := database.GetManifestItems(ctx, tx)
+	err := s.Database.Transaction(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		records, n, err := database.GetManifestItemsPage(ctx, tx, dbFilter, offset, limit)
 		if err != nil {
 			return fmt.Errorf("Failed to fetch manifests: %w", err)
 		}
 
+		total = n
+
 		for _, manifest := range records {
-			manifests = append(manifests, types.Manifest{
+			appliedAt, err := formatAppliedDate(manifest.AppliedDate)
+			if err != nil {
+				return err
+			}
+
+			data, err := manifestItemData(manifest)
+			if err != nil {
+				return err
+			}
+
+			m := types.Manifest{
 				ManifestID:  manifest.ManifestID,
 				AppliedDate: manifest.AppliedDate,
-				Data:        manifest.Data,
-			})
+				AppliedAt:   appliedAt,
+				Data:        data,
+			}
+			setManifestStatus(&m, manifest)
+
+			manifests = append(manifests, m)
 		}
 
 		return nil
 	})
 	if err != nil {
-		return nil, err
+		return nil, -1, err
 	}
 
-	return manifests, nil
+	return manifests, total, nil
 }
 
 // GetManifest returns a Manifest with the given id
@@ -56,26 +148,133 @@ func GetManifest(s *state.State, manifestid string) (types.Manifest, error) {
 			return err
 		}
 
+		appliedAt, err := formatAppliedDate(record.AppliedDate)
+		if err != nil {
+			return err
+		}
+
+		data, err := manifestItemData(*record)
+		if err != nil {
+			return err
+		}
+
 		manifest.ManifestID = record.ManifestID
 		manifest.AppliedDate = record.AppliedDate
-		manifest.Data = record.Data
+		manifest.AppliedAt = appliedAt
+		manifest.Data = data
+		setManifestStatus(&manifest, *record)
 
-		return nil
+		manifest.RollbackData, err = database.GetManifestItemRollbackData(ctx, tx, record.ManifestID)
+		if err != nil {
+			return err
+		}
+
+		manifest.Signature, err = database.GetManifestItemSignature(ctx, tx, record.ManifestID)
+		return err
 	})
 
 	return manifest, err
 }
 
-// AddManifest adds a manifest to the database
-func AddManifest(s *state.State, manifestid string, data string) error {
-	// Add manifest to the database.
+// manifestItemData returns the data of the manifest record, decompressed if it is compressed
+func manifestItemData(record database.ManifestItem) (string, error) {
+	if !record.Compressed {
+		return record.Data, nil
+	}
+
+	return database.DecompressManifestData(record.Data)
+}
+
+// createManifestItem records a manifest with its data compressed
+func createManifestItem(ctx context.Context, tx *sql.Tx, manifestid string, data string) error {
+	compressedData, err := database.CompressManifestData(data)
+	if err != nil {
+		return err
+	}
+
+	_, err = database.CreateManifestItem(ctx, tx, database.ManifestItem{ManifestID: manifestid, Data: compressedData, Compressed: true})
+	if err != nil {
+		return fmt.Errorf("Failed to record manifest: %w", err)
+	}
+
+	return nil
+}
+
+// setManifestStatus sets the application status of the manifest from its record
+func setManifestStatus(manifest *types.Manifest, record database.ManifestItem) {
+	manifest.Status = record.Status
+	manifest.Error = record.Error.String
+}
+
+// GetSchemaVersion returns the current database schema version
+func GetSchemaVersion(s *state.State) (int, error) {
+	var version int
+
 	err := s.Database.Transaction(s.Context, func(ctx context.Context, tx *sql.Tx) error {
-		_, err := database.CreateManifestItem(ctx, tx, database.ManifestItem{ManifestID: manifestid, Data: data})
+		var err error
+		version, err = database.GetSchemaVersion(ctx, tx)
+		return err
+	})
+
+	return version, err
+}
+
+// ValidateManifest checks the manifest was not written against a newer database schema
+// than the current one. Manifests without schema version are always accepted.
+func ValidateManifest(s *state.State, manifest types.Manifest) error {
+	if manifest.SchemaVersion == 0 {
+		return nil
+	}
+
+	version, err := GetSchemaVersion(s)
+	if err != nil {
+		return err
+	}
+
+	if manifest.SchemaVersion > version {
+		return api.StatusErrorf(http.StatusUnprocessableEntity, "Manifest schema version %d is newer than database schema version %d", manifest.SchemaVersion, version)
+	}
+
+	return nil
+}
+
+// AddManifest adds a manifest to the database with the given application status,
+// applied if empty, the data reverting it and its verified signature, if any.
+// Manifests are recorded pending before they are applied.
+func AddManifest(s *state.State, manifestid string, data string, status string, rollbackData string, signature string) error {
+	if status == "" {
+		status = ManifestApplied
+	}
+
+	err := validateManifestStatus(status)
+	if err != nil {
+		return err
+	}
+
+	// Add manifest to the database.
+	err = s.Database.Transaction(s.Context, func(ctx context.Context, tx *sql.Tx) error {
+		err := createManifestItem(ctx, tx, manifestid, data)
 		if err != nil {
-			return fmt.Errorf("Failed to record manifest: %w", err)
+			return err
 		}
 
-		return nil
+		err = database.SetManifestItemStatus(ctx, tx, manifestid, database.ManifestItemStatus{Status: status})
+		if err != nil {
+			return err
+		}
+
+		if rollbackData != "" {
+			err = database.SetManifestItemRollbackData(ctx, tx, manifestid, rollbackData)
+			if err != nil {
+				return err
+			}
+		}
+
+		if signature == "" {
+			return nil
+		}
+
+		return database.SetManifestItemSignature(ctx, tx, manifestid, signature)
 	})
 	if err != nil {
 		return err
@@ -84,6 +283,114 @@ func AddManifest(s *state.State, manifestid string, data string) error {
 	return nil
 }
 
+// SetManifestStatus records the outcome of the application of a manifest,
+// errMsg being the error it failed with
+func SetManifestStatus(s *state.State, manifestid string, status string, errMsg string) error {
+	err := validateManifestStatus(status)
+	if err != nil {
+		return err
+	}
+
+	if errMsg != "" && status != ManifestFailed {
+		return api.StatusErrorf(http.StatusBadRequest, "Only failed manifests can have an error")
+	}
+
+	itemStatus := database.ManifestItemStatus{Status: status}
+	if errMsg != "" {
+		itemStatus.Error = &errMsg
+	}
+
+	return s.Database.Transaction(s.Context, func(ctx context.Context, tx *sql.Tx) error {
+		return database.SetManifestItemStatus(ctx, tx, manifestid, itemStatus)
+	})
+}
+
+// RetryManifest marks a failed manifest pending again, clearing its error, so that it is re-applied.
+// It returns a Conflict error if the manifest is not failed.
+func RetryManifest(s *state.State, manifestid string) (types.Manifest, error) {
+	err := s.Database.Transaction(s.Context, func(ctx context.Context, tx *sql.Tx) error {
+		retried, err := database.TransitionManifestItemStatus(ctx, tx, manifestid, ManifestFailed, ManifestPending)
+		if err != nil {
+			return err
+		}
+
+		if retried {
+			return nil
+		}
+
+		_, err = database.GetManifestItem(ctx, tx, manifestid)
+		if err != nil {
+			return err
+		}
+
+		return api.StatusErrorf(http.StatusConflict, "Manifest %q has not failed", manifestid)
+	})
+	if err != nil {
+		return types.Manifest{}, err
+	}
+
+	return GetManifest(s, manifestid)
+}
+
+// RollbackManifest records the rollback data of the manifest as a new pending manifest, which becomes
+// the latest one to be applied. The new manifest can itself be rolled back to the data of the manifest.
+// It returns an Unprocessable Entity error if the manifest has no rollback data.
+func RollbackManifest(ctx context.Context, s *state.State, manifestid string) (types.Manifest, error) {
+	rollbackID, err := generateManifestID()
+	if err != nil {
+		return types.Manifest{}, err
+	}
+
+	err = s.Database.Transaction(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		record, err := database.GetManifestItem(ctx, tx, manifestid)
+		if err != nil {
+			return err
+		}
+
+		rollbackData, err := database.GetManifestItemRollbackData(ctx, tx, manifestid)
+		if err != nil {
+			return err
+		}
+
+		if rollbackData == "" {
+			return api.StatusErrorf(http.StatusUnprocessableEntity, "Manifest %q has no rollback data", manifestid)
+		}
+
+		data, err := manifestItemData(*record)
+		if err != nil {
+			return err
+		}
+
+		err = createManifestItem(ctx, tx, rollbackID, rollbackData)
+		if err != nil {
+			return err
+		}
+
+		err = database.SetManifestItemStatus(ctx, tx, rollbackID, database.ManifestItemStatus{Status: ManifestPending})
+		if err != nil {
+			return err
+		}
+
+		return database.SetManifestItemRollbackData(ctx, tx, rollbackID, data)
+	})
+	if err != nil {
+		return types.Manifest{}, err
+	}
+
+	return GetManifest(s, rollbackID)
+}
+
+// generateManifestID returns a new random manifest id
+func generateManifestID() (string, error) {
+	id := make([]byte, 16)
+	_, err := rand.Read(id)
+	if err != nil {
+		return "", fmt.Errorf("Failed to generate manifest id: %w", err)
+	}
+
+	return hex.EncodeToString(id), nil
+}
+
 // DeleteManifest deletes a manifest from database
 func DeleteManifest(s *state.State, manifestid string) error {
 	// Delete manifest from the database.
@@ -101,3 +408,17 @@ func DeleteManifest(s *state.State, manifestid string) error {
 
 	return nil
 }
+
+// formatAppliedDate formats the manifest applied date from the database as RFC 3339
+func formatAppliedDate(appliedDate string) (string, error) {
+	if appliedDate == "" {
+		return "", nil
+	}
+
+	t, err := parseDBTimestamp(appliedDate)
+	if err != nil {
+		return "", fmt.Errorf("Failed to parse manifest applied date: %w", err)
+	}
+
+	return t.UTC().Format(time.RFC3339), nil
+}
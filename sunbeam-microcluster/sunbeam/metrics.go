@@ -0,0 +1,135 @@
+package sunbeam
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/canonical/microcluster/state"
+
+	"github.com/canonical/snap-openstack/sunbeam-microcluster/database"
+)
+
+// Metric is a Prometheus metric family
+type Metric struct {
+	Name    string
+	Help    string
+	Type    string
+	Samples []MetricSample
+}
+
+// MetricSample is a single value of a Metric, identified by its labels
+type MetricSample struct {
+	Labels map[string]string
+	Value  float64
+}
+
+// metricLabelEscaper escapes label values as required by the Prometheus text format
+var metricLabelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// CollectMetrics returns the operational statistics of the cluster as Prometheus gauges
+func CollectMetrics(ctx context.Context, s *state.State) ([]Metric, error) {
+	var configKeys, states, locks int
+	var manifests map[string]int
+	err := s.Database.Transaction(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		var err error
+		configKeys, err = database.CountConfigItems(ctx, tx, "")
+		if err != nil {
+			return err
+		}
+
+		states, err = database.CountConfigItems(ctx, tx, tfstatePrefix)
+		if err != nil {
+			return err
+		}
+
+		locks, err = database.CountConfigItems(ctx, tx, tflockPrefix)
+		if err != nil {
+			return err
+		}
+
+		manifests, err = database.CountManifestItemsByStatus(ctx, tx)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	nodes, err := ListNodes(s, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	roles := map[string]int{}
+	for _, node := range nodes {
+		for _, role := range node.Role {
+			roles[role]++
+		}
+	}
+
+	nodesTotal := Metric{Name: "sunbeam_nodes_total", Help: "Number of nodes with each role.", Type: "gauge"}
+	for role, count := range roles {
+		nodesTotal.Samples = append(nodesTotal.Samples, MetricSample{Labels: map[string]string{"role": role}, Value: float64(count)})
+	}
+
+	manifestsTotal := Metric{Name: "sunbeam_manifests_total", Help: "Number of manifests with each application status.", Type: "gauge"}
+	for status, count := range manifests {
+		manifestsTotal.Samples = append(manifestsTotal.Samples, MetricSample{Labels: map[string]string{"status": status}, Value: float64(count)})
+	}
+
+	return []Metric{
+		nodesTotal,
+		{Name: "sunbeam_terraform_states_total", Help: "Number of terraform states.", Type: "gauge", Samples: []MetricSample{{Value: float64(states)}}},
+		{Name: "sunbeam_terraform_locks_active", Help: "Number of held terraform locks.", Type: "gauge", Samples: []MetricSample{{Value: float64(locks)}}},
+		{Name: "sunbeam_config_keys_total", Help: "Number of config keys.", Type: "gauge", Samples: []MetricSample{{Value: float64(configKeys)}}},
+		manifestsTotal,
+	}, nil
+}
+
+// WriteMetrics writes the metrics in the Prometheus text exposition format
+func WriteMetrics(w io.Writer, metrics []Metric) error {
+	var b strings.Builder
+
+	for _, metric := range metrics {
+		fmt.Fprintf(&b, "# HELP %s %s\n", metric.Name, metric.Help)
+		fmt.Fprintf(&b, "# TYPE %s %s\n", metric.Name, metric.Type)
+
+		samples := make([]string, 0, len(metric.Samples))
+		for _, sample := range metric.Samples {
+			samples = append(samples, metric.Name+formatMetricLabels(sample.Labels)+" "+strconv.FormatFloat(sample.Value, 'g', -1, 64))
+		}
+
+		sort.Strings(samples)
+		for _, sample := range samples {
+			b.WriteString(sample + "\n")
+		}
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// formatMetricLabels returns the labels as {name="value",...} sorted by name, empty if there are none
+func formatMetricLabels(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = fmt.Sprintf(`%s="%s"`, name, metricLabelEscaper.Replace(labels[name]))
+	}
+
+	return "{" + strings.Join(pairs, ",") + "}"
+}
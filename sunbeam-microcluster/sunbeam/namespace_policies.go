@@ -0,0 +1,115 @@
+package sunbeam
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"strings"
+
+	"github.com/canonical/lxd/shared/api"
+	"github.com/canonical/microcluster/state"
+
+	"github.com/canonical/snap-openstack/sunbeam-microcluster/api/types"
+	"github.com/canonical/snap-openstack/sunbeam-microcluster/database"
+)
+
+// ListConfigNamespacePolicies returns all the config namespace policies
+func ListConfigNamespacePolicies(s *state.State) (types.ConfigNamespacePolicies, error) {
+	policies := types.ConfigNamespacePolicies{}
+
+	err := s.Database.Transaction(s.Context, func(ctx context.Context, tx *sql.Tx) error {
+		records, err := database.GetConfigNamespacePolicies(ctx, tx)
+		if err != nil {
+			return err
+		}
+
+		for _, record := range records {
+			policies = append(policies, namespacePolicyFromRecord(record))
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return policies, nil
+}
+
+// GetConfigNamespacePolicy returns the policy of the given config namespace
+func GetConfigNamespacePolicy(s *state.State, namespace string) (types.ConfigNamespacePolicy, error) {
+	var policy types.ConfigNamespacePolicy
+
+	err := s.Database.Transaction(s.Context, func(ctx context.Context, tx *sql.Tx) error {
+		record, err := database.GetConfigNamespacePolicy(ctx, tx, namespace)
+		if err != nil {
+			return err
+		}
+
+		policy = namespacePolicyFromRecord(*record)
+
+		return nil
+	})
+
+	return policy, err
+}
+
+// CreateConfigNamespacePolicy adds a new config namespace policy
+func CreateConfigNamespacePolicy(s *state.State, policy types.ConfigNamespacePolicy) error {
+	err := validateNamespacePolicy(policy)
+	if err != nil {
+		return err
+	}
+
+	return s.Database.Transaction(s.Context, func(ctx context.Context, tx *sql.Tx) error {
+		return database.CreateConfigNamespacePolicy(ctx, tx, namespacePolicyToRecord(policy))
+	})
+}
+
+// UpdateConfigNamespacePolicy updates an existing config namespace policy
+func UpdateConfigNamespacePolicy(s *state.State, policy types.ConfigNamespacePolicy) error {
+	err := validateNamespacePolicy(policy)
+	if err != nil {
+		return err
+	}
+
+	return s.Database.Transaction(s.Context, func(ctx context.Context, tx *sql.Tx) error {
+		return database.UpdateConfigNamespacePolicy(ctx, tx, namespacePolicyToRecord(policy))
+	})
+}
+
+// DeleteConfigNamespacePolicy deletes a config namespace policy
+func DeleteConfigNamespacePolicy(s *state.State, namespace string) error {
+	return s.Database.Transaction(s.Context, func(ctx context.Context, tx *sql.Tx) error {
+		return database.DeleteConfigNamespacePolicy(ctx, tx, namespace)
+	})
+}
+
+// validateNamespacePolicy checks the namespace is a single key segment and the TTL is positive
+func validateNamespacePolicy(policy types.ConfigNamespacePolicy) error {
+	if policy.Namespace == "" || strings.Contains(policy.Namespace, ".") {
+		return api.StatusErrorf(http.StatusBadRequest, "Invalid config namespace %q", policy.Namespace)
+	}
+
+	if policy.DefaultTTLSeconds != nil && *policy.DefaultTTLSeconds <= 0 {
+		return api.StatusErrorf(http.StatusBadRequest, "default_ttl_seconds must be positive")
+	}
+
+	return nil
+}
+
+func namespacePolicyFromRecord(record database.ConfigNamespacePolicy) types.ConfigNamespacePolicy {
+	return types.ConfigNamespacePolicy{
+		Namespace:         record.Namespace,
+		DefaultTTLSeconds: record.DefaultTTLSeconds,
+		AllowUserTTL:      record.AllowUserTTL,
+	}
+}
+
+func namespacePolicyToRecord(policy types.ConfigNamespacePolicy) database.ConfigNamespacePolicy {
+	return database.ConfigNamespacePolicy{
+		Namespace:         policy.Namespace,
+		DefaultTTLSeconds: policy.DefaultTTLSeconds,
+		AllowUserTTL:      policy.AllowUserTTL,
+	}
+}
@@ -0,0 +1,173 @@
+package sunbeam
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/canonical/lxd/shared/api"
+	"github.com/canonical/lxd/shared/logger"
+	"github.com/canonical/microcluster/state"
+
+	"github.com/canonical/snap-openstack/sunbeam-microcluster/api/types"
+	"github.com/canonical/snap-openstack/sunbeam-microcluster/database"
+)
+
+// Actions recorded in the node decommission audit log
+const (
+	nodeDecommissionActionDecommission = "decommission"
+	nodeDecommissionActionRestore      = "restore"
+)
+
+// DecommissionNode deletes the node with the given name along with its labels and capacity, records the
+// decommission in the node decommission audit log and removes the cluster member of the node, if any.
+// The database records are restored if the cluster member cannot be removed.
+// On a dry run nothing is deleted and the returned NodeDecommission lists what would be.
+func DecommissionNode(s *state.State, name string, dryRun bool) (types.NodeDecommission, error) {
+	var decommission types.NodeDecommission
+	var record *database.Node
+	var capacity map[string]string
+	err := s.Database.Transaction(s.Context, func(ctx context.Context, tx *sql.Tx) error {
+		var err error
+		decommission, record, capacity, err = decommissionNode(ctx, tx, s.Name(), name, dryRun)
+		return err
+	})
+	if err != nil {
+		if api.StatusErrorCheck(err, http.StatusNotFound) {
+			return decommission, err
+		}
+
+		return decommission, api.StatusErrorf(http.StatusInternalServerError, "Failed to decommission node %q: %v", name, err)
+	}
+
+	if dryRun || decommission.ClusterMember == "" {
+		return decommission, nil
+	}
+
+	err = removeClusterMember(s, decommission.ClusterMember)
+	if err != nil {
+		restoreErr := restoreNode(s, *record, decommission.Node, capacity)
+		if restoreErr != nil {
+			logger.Errorf("Failed to restore node %q after failed decommission: %v", name, restoreErr)
+			err = errors.Join(err, restoreErr)
+		}
+
+		return decommission, api.StatusErrorf(http.StatusInternalServerError, "Failed to remove cluster member %q: %v", decommission.ClusterMember, err)
+	}
+
+	return decommission, nil
+}
+
+// decommissionNode deletes the node with the given name along with its labels and capacity within tx,
+// recording the decommission by member. The deleted node record and capacity are returned to restore
+// them if its cluster member cannot be removed. On a dry run nothing is deleted.
+func decommissionNode(ctx context.Context, tx *sql.Tx, member string, name string, dryRun bool) (types.NodeDecommission, *database.Node, map[string]string, error) {
+	decommission := types.NodeDecommission{DryRun: dryRun}
+
+	record, err := database.GetNode(ctx, tx, name)
+	if err != nil {
+		return decommission, nil, nil, err
+	}
+
+	nodes, err := nodesFromRecords(ctx, tx, []database.Node{*record})
+	if err != nil {
+		return decommission, nil, nil, err
+	}
+
+	decommission.Node = nodes[0]
+
+	_, err = database.GetClusterMemberAddress(ctx, tx, record.Member)
+	if err == nil {
+		decommission.ClusterMember = record.Member
+	} else if !api.StatusErrorCheck(err, http.StatusNotFound) {
+		return decommission, nil, nil, err
+	}
+
+	if dryRun {
+		return decommission, record, nil, nil
+	}
+
+	err = database.DeleteNodeLabels(ctx, tx, name)
+	if err != nil {
+		return decommission, nil, nil, err
+	}
+
+	capacity, _, err := database.GetNodeCapacity(ctx, tx, name)
+	if err != nil {
+		return decommission, nil, nil, err
+	}
+
+	err = database.DeleteNodeCapacity(ctx, tx, name)
+	if err != nil {
+		return decommission, nil, nil, err
+	}
+
+	err = database.DeleteNode(ctx, tx, name)
+	if err != nil {
+		return decommission, nil, nil, fmt.Errorf("Failed to delete node: %w", err)
+	}
+
+	err = recordNodeDecommission(ctx, tx, member, nodeDecommissionActionDecommission, decommission)
+	if err != nil {
+		return decommission, nil, nil, err
+	}
+
+	return decommission, record, capacity, nil
+}
+
+// recordNodeDecommission adds action on the node of decommission by member to the node decommission audit log
+func recordNodeDecommission(ctx context.Context, tx *sql.Tx, member string, action string, decommission types.NodeDecommission) error {
+	value, err := json.Marshal(decommission.Node)
+	if err != nil {
+		return fmt.Errorf("Failed to marshal node: %w", err)
+	}
+
+	return database.CreateNodeDecommissionAuditEntry(ctx, tx, database.NodeDecommissionAuditEntry{
+		Name:          decommission.Node.Name,
+		Action:        action,
+		ClusterMember: decommission.ClusterMember,
+		Node:          string(value),
+		Member:        member,
+	})
+}
+
+// removeClusterMember removes the cluster member with the given name through the dqlite leader
+func removeClusterMember(s *state.State, name string) error {
+	leader, err := s.Leader()
+	if err != nil {
+		return fmt.Errorf("Failed to get leader client: %w", err)
+	}
+
+	return leader.DeleteClusterMember(s.Context, name, false)
+}
+
+// restoreNode recreates a node record, its labels and capacity deleted by a failed decommission
+func restoreNode(s *state.State, record database.Node, node types.Node, capacity map[string]string) error {
+	return s.Database.Transaction(s.Context, func(ctx context.Context, tx *sql.Tx) error {
+		_, err := database.CreateNode(ctx, tx, database.Node{
+			Member:    record.Member,
+			Name:      record.Name,
+			Role:      record.Role,
+			MachineID: record.MachineID,
+			SystemID:  record.SystemID,
+		})
+		if err != nil {
+			return fmt.Errorf("Failed to record node: %w", err)
+		}
+
+		err = database.SetNodeLabels(ctx, tx, record.Name, node.Labels)
+		if err != nil {
+			return err
+		}
+
+		err = database.SetNodeCapacity(ctx, tx, record.Name, capacity)
+		if err != nil {
+			return err
+		}
+
+		return recordNodeDecommission(ctx, tx, s.Name(), nodeDecommissionActionRestore, types.NodeDecommission{Node: node, ClusterMember: record.Member})
+	})
+}
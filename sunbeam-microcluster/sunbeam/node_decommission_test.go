@@ -0,0 +1,75 @@
+package sunbeam
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/canonical/lxd/shared/api"
+
+	"github.com/canonical/snap-openstack/sunbeam-microcluster/database"
+)
+
+func TestDecommissionNode(t *testing.T) {
+	tx := database.NewTestSchemaTx(t)
+	ctx := context.Background()
+
+	// The node is named after its host, the cluster member after the name it joined with.
+	_, err := tx.Exec(`INSERT INTO internal_cluster_members (id, name, address, certificate, schema_internal, schema_external, heartbeat, role)
+  VALUES (1, 'member-a', '10.0.0.1:7000', 'cert-a', 1, 1, '2024-01-01 00:00:00', 'voter')`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = tx.Exec(`INSERT INTO nodes (member_id, name, role, machine_id) VALUES (1, 'node-a.maas', '["compute"]', -1)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	auditEntries := func() int {
+		var count int
+		err := tx.QueryRow(`SELECT COUNT(*) FROM node_decommission_audit WHERE name = 'node-a.maas' AND action = 'decommission' AND cluster_member = 'member-a'`).Scan(&count)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return count
+	}
+
+	decommission, _, _, err := decommissionNode(ctx, tx, "member-b", "node-a.maas", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if decommission.ClusterMember != "member-a" || decommission.Node.Name != "node-a.maas" {
+		t.Errorf("Dry run would decommission node %q of cluster member %q, want node-a.maas of member-a", decommission.Node.Name, decommission.ClusterMember)
+	}
+
+	_, err = database.GetNode(ctx, tx, "node-a.maas")
+	if err != nil || auditEntries() != 0 {
+		t.Fatalf("Dry run deleted the node or recorded its decommission: %v", err)
+	}
+
+	decommission, record, _, err := decommissionNode(ctx, tx, "member-b", "node-a.maas", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if decommission.ClusterMember != "member-a" || record == nil || record.Member != "member-a" {
+		t.Errorf("Decommission removes cluster member %q, want member-a", decommission.ClusterMember)
+	}
+
+	_, err = database.GetNode(ctx, tx, "node-a.maas")
+	if !api.StatusErrorCheck(err, http.StatusNotFound) {
+		t.Errorf("Decommissioned node lookup returned %v, want a 404 error", err)
+	}
+
+	if auditEntries() != 1 {
+		t.Error("Decommission was not recorded in the node decommission audit log")
+	}
+
+	_, _, _, err = decommissionNode(ctx, tx, "member-b", "node-a.maas", false)
+	if !api.StatusErrorCheck(err, http.StatusNotFound) {
+		t.Errorf("Decommission of a missing node returned %v, want a 404 error", err)
+	}
+}
@@ -0,0 +1,105 @@
+package sunbeam
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/canonical/microcluster/state"
+
+	"github.com/canonical/snap-openstack/sunbeam-microcluster/api/types"
+	"github.com/canonical/snap-openstack/sunbeam-microcluster/database"
+)
+
+const (
+	// NodeHealthy is the status of a node passing all its checks
+	NodeHealthy = "healthy"
+	// NodeDegraded is the status of a reachable node failing some checks
+	NodeDegraded = "degraded"
+	// NodeUnreachable is the status of a node whose cluster member cannot be connected to
+	NodeUnreachable = "unreachable"
+)
+
+// nodeHealthDialTimeout is how long connecting to the cluster member of a node may take
+const nodeHealthDialTimeout = 3 * time.Second
+
+// nodeHealthStaleAfter is how long since it was last seen a node is considered stale
+const nodeHealthStaleAfter = 5 * time.Minute
+
+// CheckNodeHealth probes the node with the given name: its cluster member must be
+// reachable and the node must have been seen recently.
+func CheckNodeHealth(s *state.State, name string) (types.NodeHealth, error) {
+	var node types.Node
+	var member string
+	var address string
+	err := s.Database.Transaction(s.Context, func(ctx context.Context, tx *sql.Tx) error {
+		record, err := database.GetNode(ctx, tx, name)
+		if err != nil {
+			return err
+		}
+
+		nodes, err := nodesFromRecords(ctx, tx, []database.Node{*record})
+		if err != nil {
+			return err
+		}
+
+		node = nodes[0]
+		member = record.Member
+		address, err = database.GetClusterMemberAddress(ctx, tx, member)
+		return err
+	})
+	if err != nil {
+		return types.NodeHealth{}, err
+	}
+
+	health := types.NodeHealth{Name: name, Status: NodeHealthy, LastSeen: node.LastSeenAt}
+
+	reachable := types.NodeHealthCheck{Name: "reachable", Passed: true}
+	conn, err := net.DialTimeout("tcp", address, nodeHealthDialTimeout)
+	if err != nil {
+		reachable.Passed = false
+		reachable.Message = fmt.Sprintf("Cluster member %q at %s is unreachable: %v", member, address, err)
+	} else {
+		_ = conn.Close()
+	}
+
+	lastSeen, err := nodeLastSeenCheck(node, time.Now())
+	if err != nil {
+		return types.NodeHealth{}, err
+	}
+
+	health.Checks = []types.NodeHealthCheck{reachable, lastSeen}
+
+	if !reachable.Passed {
+		health.Status = NodeUnreachable
+	} else if !lastSeen.Passed {
+		health.Status = NodeDegraded
+	}
+
+	return health, nil
+}
+
+// nodeLastSeenCheck passes if node was seen at most nodeHealthStaleAfter before now.
+// A node is seen at each heartbeat its cluster member answers.
+func nodeLastSeenCheck(node types.Node, now time.Time) (types.NodeHealthCheck, error) {
+	lastSeen := types.NodeHealthCheck{Name: "last-seen", Passed: true}
+	if node.LastSeenAt == "" {
+		lastSeen.Passed = false
+		lastSeen.Message = "Node has never been seen"
+		return lastSeen, nil
+	}
+
+	seenAt, err := parseDBTimestamp(node.LastSeenAt)
+	if err != nil {
+		return lastSeen, fmt.Errorf("Failed to parse last seen time of node %q: %w", node.Name, err)
+	}
+
+	if now.Sub(seenAt) > nodeHealthStaleAfter {
+		lastSeen.Passed = false
+		lastSeen.Message = fmt.Sprintf("Node was last seen more than %s ago", nodeHealthStaleAfter)
+	}
+
+	return lastSeen, nil
+}
@@ -0,0 +1,85 @@
+package sunbeam
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/canonical/snap-openstack/sunbeam-microcluster/api/types"
+	"github.com/canonical/snap-openstack/sunbeam-microcluster/database"
+)
+
+func TestNodeLastSeenCheckMembers(t *testing.T) {
+	tx := database.NewTestSchemaTx(t)
+	ctx := context.Background()
+	now := time.Now().UTC()
+
+	// Only the leader runs the heartbeat hook, the nodes of the other members
+	// must be seen from the heartbeats they answered.
+	members := []struct {
+		name      string
+		heartbeat time.Time
+	}{
+		{"leader", now},
+		{"follower", now.Add(-time.Minute)},
+		{"unreachable", now.Add(-time.Hour)},
+		{"joining", time.Time{}},
+	}
+
+	for i, member := range members {
+		_, err := tx.Exec(`INSERT INTO internal_cluster_members (id, name, address, certificate, schema_internal, schema_external, heartbeat, role)
+  VALUES (?, ?, ?, ?, 1, 1, ?, 'voter')`, i+1, member.name, member.name, member.name, member.heartbeat)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = tx.Exec(`INSERT INTO nodes (member_id, name, role, machine_id) VALUES (?, ?, '["compute"]', 0)`, i+1, member.name+"-node")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := database.UpdateNodesLastSeen(ctx, tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := database.GetNodes(ctx, tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	nodes, err := nodesFromRecords(ctx, tx, records)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]bool{
+		"leader-node":      true,
+		"follower-node":    true,
+		"unreachable-node": false,
+		"joining-node":     false,
+	}
+
+	if len(nodes) != len(want) {
+		t.Fatalf("Got %d nodes, want %d", len(nodes), len(want))
+	}
+
+	for _, node := range nodes {
+		check, err := nodeLastSeenCheck(node, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if check.Passed != want[node.Name] {
+			t.Errorf("Last seen check of %q passed is %v, want %v: %s", node.Name, check.Passed, want[node.Name], check.Message)
+		}
+	}
+}
+
+func TestNodeLastSeenCheckInvalid(t *testing.T) {
+	_, err := nodeLastSeenCheck(types.Node{Name: "node", LastSeenAt: "yesterday"}, time.Now())
+	if err == nil {
+		t.Error("Invalid last seen time was accepted")
+	}
+}
@@ -5,7 +5,13 @@ import (
 	"database/sql"
 	"encoding/json"
 	"fmt"
+	"net/http"
+	"regexp"
 	"sort"
+	"strings"
+	"time"
+
+	"github.com/canonical/lxd/shared/api"
 
 	"github.com/canonical/microcluster/state"
 
@@ -13,9 +19,92 @@ import (
 	"github.com/canonical/snap-openstack/sunbeam-microcluster/database"
 )
 
-// ListNodes return all the nodes, filterable by role (Optional)
-func ListNodes(s *state.State, roles []string) (types.Nodes, error) {
-	nodes := types.Nodes{}
+// systemIDPrefixRegex matches the allowed system id prefixes
+var systemIDPrefixRegex = regexp.MustCompile(`^[a-zA-Z0-9-]{1,20}$`)
+
+// maasSystemIDRegex matches the system ids assigned by MAAS
+var maasSystemIDRegex = regexp.MustCompile(`^[a-z0-9]{6}$`)
+
+// MaxBulkNodeSystemIDs is the maximum number of nodes BulkUpdateNodeSystemIDs updates at once
+const MaxBulkNodeSystemIDs = 1000
+
+const (
+	// NodeSystemIDUpdated is the status of a node whose system id was updated
+	NodeSystemIDUpdated = "updated"
+	// NodeSystemIDNotFound is the status of an update of an unknown node
+	NodeSystemIDNotFound = "not-found"
+	// NodeSystemIDInvalid is the status of an update rejected because of its system id
+	NodeSystemIDInvalid = "invalid"
+	// NodeSystemIDConflict is the status of an update whose system id is assigned to another node
+	NodeSystemIDConflict = "conflict"
+)
+
+// NodeRoleConfigKey is the key of the join config holding the comma separated roles of the joining node
+const NodeRoleConfigKey = "role"
+
+// validNodeRoles are the roles a node can be assigned
+var validNodeRoles = map[string]bool{
+	"control": true,
+	"compute": true,
+	"storage": true,
+}
+
+// ParseNodeRoles returns the roles in the comma separated value,
+// checking there is at least one and all of them are valid.
+func ParseNodeRoles(value string) ([]string, error) {
+	if strings.TrimSpace(value) == "" {
+		return nil, fmt.Errorf("No node role given")
+	}
+
+	roles := []string{}
+	for _, role := range strings.Split(value, ",") {
+		role = strings.TrimSpace(role)
+		if !validNodeRoles[role] {
+			return nil, fmt.Errorf("Invalid node role %q", role)
+		}
+
+		roles = append(roles, role)
+	}
+
+	return roles, nil
+}
+
+// RegisterJoinedNode records the node of this cluster member with the given roles,
+// updating its roles if the node is already recorded.
+func RegisterJoinedNode(s *state.State, roles []string) error {
+	nodeRole, err := roleToStr(roles)
+	if err != nil {
+		return err
+	}
+
+	return s.Database.Transaction(s.Context, func(ctx context.Context, tx *sql.Tx) error {
+		node, err := database.GetNode(ctx, tx, s.Name())
+		if err != nil {
+			if !api.StatusErrorCheck(err, http.StatusNotFound) {
+				return fmt.Errorf("Failed to retrieve node details: %w", err)
+			}
+
+			_, err = database.CreateNode(ctx, tx, database.Node{Member: s.Name(), Name: s.Name(), Role: nodeRole, MachineID: -1})
+			if err != nil {
+				return fmt.Errorf("Failed to record node: %w", err)
+			}
+
+			return nil
+		}
+
+		node.Role = nodeRole
+		err = database.UpdateNode(ctx, tx, s.Name(), *node)
+		if err != nil {
+			return fmt.Errorf("Failed to update record node: %w", err)
+		}
+
+		return nil
+	})
+}
+
+// ListNodes return all the nodes, filterable by role and labels (Optional)
+func ListNodes(s *state.State, roles []string, labels map[string]string) (types.Nodes, error) {
+	var nodes types.Nodes
 
 	// Get the nodes from the database.
 	err := s.Database.Transaction(s.Context, func(ctx context.Context, tx *sql.Tx) error {
@@ -24,20 +113,53 @@ func ListNodes(s *state.State, roles []string) (types.Nodes, error) {
 			return fmt.Errorf("Failed to fetch nodes: %w", err)
 		}
 
-		for _, node := range records {
-			nodeRole, err := roleFromStr(node.Role)
+		if len(labels) > 0 {
+			names, err := database.GetNodeNamesWithLabels(ctx, tx, labels)
 			if err != nil {
 				return err
 			}
-			nodes = append(nodes, types.Node{
-				Name:      node.Name,
-				Role:      nodeRole,
-				MachineID: node.MachineID,
-				SystemID:  node.SystemID,
-			})
+
+			matching := make(map[string]bool, len(names))
+			for _, name := range names {
+				matching[name] = true
+			}
+
+			filtered := []database.Node{}
+			for _, record := range records {
+				if matching[record.Name] {
+					filtered = append(filtered, record)
+				}
+			}
+
+			records = filtered
 		}
 
-		return nil
+		nodes, err = nodesFromRecords(ctx, tx, records)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return nodes, nil
+}
+
+// GetNodesBySystemIDPrefix returns all the nodes whose system id starts with prefix
+func GetNodesBySystemIDPrefix(s *state.State, prefix string) (types.Nodes, error) {
+	if !systemIDPrefixRegex.MatchString(prefix) {
+		return nil, api.StatusErrorf(http.StatusBadRequest, "Invalid system id prefix %q: at most 20 alphanumeric characters or hyphens", prefix)
+	}
+
+	var nodes types.Nodes
+
+	err := s.Database.Transaction(s.Context, func(ctx context.Context, tx *sql.Tx) error {
+		records, err := database.GetNodesFromSystemIDPrefix(ctx, tx, prefix)
+		if err != nil {
+			return fmt.Errorf("Failed to fetch nodes: %w", err)
+		}
+
+		nodes, err = nodesFromRecords(ctx, tx, records)
+		return err
 	})
 	if err != nil {
 		return nil, err
@@ -55,14 +177,11 @@ func GetNode(s *state.State, name string) (types.Node, error) {
 			return err
 		}
 
-		nodeRole, err := roleFromStr(record.Role)
+		nodes, err := nodesFromRecords(ctx, tx, []database.Node{*record})
 		if err != nil {
 			return err
 		}
-		node.Name = record.Name
-		node.Role = nodeRole
-		node.MachineID = record.MachineID
-		node.SystemID = record.SystemID
+		node = nodes[0]
 
 		return nil
 	})
@@ -79,6 +198,9 @@ func AddNode(s *state.State, name string, role []string, machineid int, systemid
 	// Add node to the database.
 	err = s.Database.Transaction(s.Context, func(ctx context.Context, tx *sql.Tx) error {
 		_, err := database.CreateNode(ctx, tx, database.Node{Member: s.Name(), Name: name, Role: nodeRole, MachineID: machineid, SystemID: systemid})
+		if database.IsSystemIDConstraintError(err) {
+			return database.ErrSystemIDAlreadyAssigned
+		}
 		if err != nil {
 			return fmt.Errorf("Failed to record node: %w", err)
 		}
@@ -116,6 +238,9 @@ func UpdateNode(s *state.State, name string, role []string, machineid int, syste
 		}
 
 		err = database.UpdateNode(ctx, tx, name, database.Node{Member: s.Name(), Name: name, Role: nodeRole, MachineID: machineid, SystemID: systemid})
+		if database.IsSystemIDConstraintError(err) {
+			return database.ErrSystemIDAlreadyAssigned
+		}
 		if err != nil {
 			return fmt.Errorf("Failed to update record node: %w", err)
 		}
@@ -133,7 +258,17 @@ func UpdateNode(s *state.State, name string, role []string, machineid int, syste
 func DeleteNode(s *state.State, name string) error {
 	// Delete node from the database.
 	err := s.Database.Transaction(s.Context, func(ctx context.Context, tx *sql.Tx) error {
-		err := database.DeleteNode(ctx, tx, name)
+		err := database.DeleteNodeLabels(ctx, tx, name)
+		if err != nil {
+			return err
+		}
+
+		err = database.DeleteNodeCapacity(ctx, tx, name)
+		if err != nil {
+			return err
+		}
+
+		err = database.DeleteNode(ctx, tx, name)
 		if err != nil {
 			return fmt.Errorf("Failed to delete node: %w", err)
 		}
@@ -147,6 +282,312 @@ func DeleteNode(s *state.State, name string) error {
 	return nil
 }
 
+// ListStaleNodes returns the nodes not seen since the given time, including nodes never seen
+func ListStaleNodes(s *state.State, since time.Time) (types.Nodes, error) {
+	nodes, err := ListNodes(s, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	staleNodes := types.Nodes{}
+	for _, node := range nodes {
+		if node.LastSeenAt != "" {
+			lastSeen, err := parseDBTimestamp(node.LastSeenAt)
+			if err != nil {
+				return nil, fmt.Errorf("Failed to parse last seen time of node %q: %w", node.Name, err)
+			}
+
+			if !lastSeen.Before(since) {
+				continue
+			}
+		}
+
+		staleNodes = append(staleNodes, node)
+	}
+
+	return staleNodes, nil
+}
+
+// BulkUpdateNodeSystemIDs assigns the system ids of the given nodes in a single transaction and
+// returns the outcome of each update, in order. Updates with an invalid system id or of unknown
+// nodes are skipped, the others are applied.
+func BulkUpdateNodeSystemIDs(ctx context.Context, s *state.State, updates []types.NodeSystemIDUpdate) (types.NodeSystemIDResults, error) {
+	if len(updates) > MaxBulkNodeSystemIDs {
+		return nil, api.StatusErrorf(http.StatusBadRequest, "Too many nodes, at most %d can be updated at once", MaxBulkNodeSystemIDs)
+	}
+
+	results := make(types.NodeSystemIDResults, len(updates))
+	err := s.Database.Transaction(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		for i, update := range updates {
+			results[i] = types.NodeSystemIDResult{Name: update.Name}
+
+			if !maasSystemIDRegex.MatchString(update.SystemID) {
+				results[i].Status = NodeSystemIDInvalid
+				results[i].Error = fmt.Sprintf("Invalid system id %q: expected 6 lowercase alphanumeric characters", update.SystemID)
+				continue
+			}
+
+			record, err := database.GetNode(ctx, tx, update.Name)
+			if err != nil {
+				if !api.StatusErrorCheck(err, http.StatusNotFound) {
+					return fmt.Errorf("Failed to retrieve node details: %w", err)
+				}
+
+				results[i].Status = NodeSystemIDNotFound
+				continue
+			}
+
+			record.SystemID = update.SystemID
+			err = database.UpdateNode(ctx, tx, update.Name, *record)
+			if database.IsSystemIDConstraintError(err) {
+				results[i].Status = NodeSystemIDConflict
+				results[i].Error = database.ErrSystemIDAlreadyAssigned.Error()
+				continue
+			}
+
+			if err != nil {
+				return fmt.Errorf("Failed to update record node: %w", err)
+			}
+
+			results[i].Status = NodeSystemIDUpdated
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// GetNodeLabels returns the labels of the node with the given name
+func GetNodeLabels(s *state.State, name string) (map[string]string, error) {
+	var labels map[string]string
+
+	err := s.Database.Transaction(s.Context, func(ctx context.Context, tx *sql.Tx) error {
+		_, err := database.GetNode(ctx, tx, name)
+		if err != nil {
+			return err
+		}
+
+		labels, err = database.GetNodeLabels(ctx, tx, name)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return labels, nil
+}
+
+// SetNodeLabels replaces the labels of the node with the given name
+func SetNodeLabels(s *state.State, name string, labels map[string]string) error {
+	for key := range labels {
+		if key == "" || strings.ContainsAny(key, "=,") {
+			return api.StatusErrorf(http.StatusBadRequest, "Invalid label key %q", key)
+		}
+	}
+
+	return s.Database.Transaction(s.Context, func(ctx context.Context, tx *sql.Tx) error {
+		_, err := database.GetNode(ctx, tx, name)
+		if err != nil {
+			return err
+		}
+
+		return database.SetNodeLabels(ctx, tx, name, labels)
+	})
+}
+
+// DeleteNodeLabel deletes the label with the given key of the node with the given name
+func DeleteNodeLabel(s *state.State, name string, key string) error {
+	return s.Database.Transaction(s.Context, func(ctx context.Context, tx *sql.Tx) error {
+		_, err := database.GetNode(ctx, tx, name)
+		if err != nil {
+			return err
+		}
+
+		return database.DeleteNodeLabel(ctx, tx, name, key)
+	})
+}
+
+// GetNodeCapacity returns the capacity reported by the node with the given name
+func GetNodeCapacity(s *state.State, name string) (types.NodeCapacity, error) {
+	capacity := types.NodeCapacity{}
+
+	err := s.Database.Transaction(s.Context, func(ctx context.Context, tx *sql.Tx) error {
+		_, err := database.GetNode(ctx, tx, name)
+		if err != nil {
+			return err
+		}
+
+		values, lastUpdated, err := database.GetNodeCapacity(ctx, tx, name)
+		if err != nil {
+			return err
+		}
+
+		for key, value := range values {
+			capacity[key] = value
+		}
+
+		capacity[types.NodeCapacityLastUpdated] = lastUpdated
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return capacity, nil
+}
+
+// UpdateNodeCapacity records the capacity values reported by the node with the given name
+func UpdateNodeCapacity(s *state.State, name string, capacity map[string]string) error {
+	for key := range capacity {
+		if key == "" || key == types.NodeCapacityLastUpdated {
+			return api.StatusErrorf(http.StatusBadRequest, "Invalid capacity key %q", key)
+		}
+	}
+
+	return s.Database.Transaction(s.Context, func(ctx context.Context, tx *sql.Tx) error {
+		_, err := database.GetNode(ctx, tx, name)
+		if err != nil {
+			return err
+		}
+
+		return database.SetNodeCapacity(ctx, tx, name, capacity)
+	})
+}
+
+// ListNodesWithStaleCapacity returns the names of the nodes whose latest capacity report is older
+// than since, nodes which never reported their capacity excluded
+func ListNodesWithStaleCapacity(s *state.State, since time.Time) ([]string, error) {
+	var updatedAt map[string]string
+	err := s.Database.Transaction(s.Context, func(ctx context.Context, tx *sql.Tx) error {
+		var err error
+		updatedAt, err = database.GetNodesCapacityUpdatedAt(ctx, tx)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	names := []string{}
+	for name, updated := range updatedAt {
+		lastUpdated, err := parseDBTimestamp(updated)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse capacity update time of node %q: %w", name, err)
+		}
+
+		if lastUpdated.Before(since) {
+			names = append(names, name)
+		}
+	}
+
+	sort.Strings(names)
+
+	return names, nil
+}
+
+// SetNodeMaintenanceMode enables or disables the maintenance mode of the node with the given name
+func SetNodeMaintenanceMode(s *state.State, name string, enabled bool) error {
+	return s.Database.Transaction(s.Context, func(ctx context.Context, tx *sql.Tx) error {
+		return database.SetNodeMaintenanceMode(ctx, tx, name, enabled)
+	})
+}
+
+// ListNodesInMaintenance returns the nodes in maintenance mode
+func ListNodesInMaintenance(ctx context.Context, s *state.State) (types.Nodes, error) {
+	var nodes types.Nodes
+
+	err := s.Database.Transaction(ctx, func(ctx context.Context, tx *sql.Tx) error {
+		names, err := database.GetNodesInMaintenance(ctx, tx)
+		if err != nil {
+			return err
+		}
+
+		records := make([]database.Node, 0, len(names))
+		for _, name := range names {
+			record, err := database.GetNode(ctx, tx, name)
+			if err != nil {
+				return err
+			}
+
+			records = append(records, *record)
+		}
+
+		nodes, err = nodesFromRecords(ctx, tx, records)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return nodes, nil
+}
+
+// UpdateNodesLastSeen records the nodes of each cluster member as seen at the last heartbeat of the member
+func UpdateNodesLastSeen(s *state.State) error {
+	return s.Database.Transaction(s.Context, func(ctx context.Context, tx *sql.Tx) error {
+		return database.UpdateNodesLastSeen(ctx, tx)
+	})
+}
+
+// nodesFromRecords converts database node records to API nodes
+func nodesFromRecords(ctx context.Context, tx *sql.Tx, records []database.Node) (types.Nodes, error) {
+	nodes := types.Nodes{}
+
+	lastSeen, err := database.GetNodesLastSeen(ctx, tx)
+	if err != nil {
+		return nil, err
+	}
+
+	labels, err := database.GetAllNodeLabels(ctx, tx)
+	if err != nil {
+		return nil, err
+	}
+
+	inMaintenance, err := database.GetNodesInMaintenance(ctx, tx)
+	if err != nil {
+		return nil, err
+	}
+
+	maintenance := make(map[string]bool, len(inMaintenance))
+	for _, name := range inMaintenance {
+		maintenance[name] = true
+	}
+
+	for _, node := range records {
+		nodeRole, err := roleFromStr(node.Role)
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, types.Node{
+			Name:            node.Name,
+			Role:            nodeRole,
+			MachineID:       node.MachineID,
+			SystemID:        node.SystemID,
+			LastSeenAt:      lastSeen[node.Name],
+			Labels:          labels[node.Name],
+			MaintenanceMode: maintenance[node.Name],
+		})
+	}
+
+	return nodes, nil
+}
+
+// parseDBTimestamp parses a timestamp as returned by the database
+func parseDBTimestamp(value string) (time.Time, error) {
+	for _, layout := range []string{time.RFC3339Nano, time.DateTime} {
+		t, err := time.Parse(layout, value)
+		if err == nil {
+			return t, nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("Unknown timestamp format %q", value)
+}
+
 // roleToStr converts a role slice to a string sorted
 func roleToStr(role []string) (string, error) {
 	sort.Strings(role)
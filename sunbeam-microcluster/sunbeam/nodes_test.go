@@ -0,0 +1,44 @@
+package sunbeam
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseNodeRoles(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    []string
+		wantErr bool
+	}{
+		{value: "control", want: []string{"control"}},
+		{value: "control,compute,storage", want: []string{"control", "compute", "storage"}},
+		{value: " compute , storage ", want: []string{"compute", "storage"}},
+		{value: "", wantErr: true},
+		{value: "  ", wantErr: true},
+		{value: "control,", wantErr: true},
+		{value: "network", wantErr: true},
+		{value: "Control", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			roles, err := ParseNodeRoles(tt.value)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("ParseNodeRoles returned %v, want an error", roles)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(roles, tt.want) {
+				t.Errorf("ParseNodeRoles returned %v, want %v", roles, tt.want)
+			}
+		})
+	}
+}
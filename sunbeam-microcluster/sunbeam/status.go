@@ -0,0 +1,221 @@
+package sunbeam
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/canonical/microcluster/state"
+
+	"github.com/canonical/snap-openstack/sunbeam-microcluster/api/types"
+	"github.com/canonical/snap-openstack/sunbeam-microcluster/database"
+)
+
+// memberCountCacheTTL is how long the cluster member count is cached
+const memberCountCacheTTL = 10 * time.Second
+
+// memberCount is a cluster member count retrieved at a given time
+type memberCount struct {
+	count     int
+	fetchedAt time.Time
+}
+
+// cachedMemberCount holds the last retrieved cluster member count
+var cachedMemberCount atomic.Pointer[memberCount]
+
+// GetClusterMemberCount returns the number of cluster members, cached for 10 seconds
+func GetClusterMemberCount(s *state.State) (int, error) {
+	return cachedClusterMemberCount(time.Now(), func() (int, error) {
+		var count int
+		err := s.Database.Transaction(s.Context, func(ctx context.Context, tx *sql.Tx) error {
+			var err error
+			count, err = database.CountClusterMembers(ctx, tx)
+			return err
+		})
+
+		return count, err
+	})
+}
+
+// cachedClusterMemberCount returns the cached cluster member count if it was fetched less than
+// memberCountCacheTTL before now, otherwise the count returned by fetch, which is then cached
+func cachedClusterMemberCount(now time.Time, fetch func() (int, error)) (int, error) {
+	cached := cachedMemberCount.Load()
+	if cached != nil && now.Sub(cached.fetchedAt) < memberCountCacheTTL {
+		return cached.count, nil
+	}
+
+	count, err := fetch()
+	if err != nil {
+		return -1, err
+	}
+
+	cachedMemberCount.Store(&memberCount{count: count, fetchedAt: now})
+
+	return count, nil
+}
+
+const (
+	// StatusHealthy is the status of a check that passed
+	StatusHealthy = "healthy"
+	// StatusDegraded is the status of a check that needs attention or could not be run
+	StatusDegraded = "degraded"
+	// StatusCritical is the status of a check whose subsystem is down
+	StatusCritical = "critical"
+)
+
+// statusSeverities orders the check statuses, the overall status being the most severe
+var statusSeverities = map[string]int{
+	StatusHealthy:  0,
+	StatusDegraded: 1,
+	StatusCritical: 2,
+}
+
+// StatusChecksKey is the config key holding a JSON list of check results reported by other components
+const StatusChecksKey = "status.checks"
+
+// GetStatus returns the cluster status, rolled up from the cluster member, node and
+// terraform lock checks and the checks reported in StatusChecksKey. A check that cannot
+// be run is degraded rather than failing the status.
+func GetStatus(s *state.State) types.Status {
+	status := types.Status{Overall: StatusHealthy}
+
+	members := types.StatusCheck{Name: "cluster-members", Status: StatusHealthy}
+	count, err := GetClusterMemberCount(s)
+	if err != nil {
+		members.Status = StatusDegraded
+		members.Message = fmt.Sprintf("Failed to count cluster members: %v", err)
+	} else {
+		status.ClusterMemberCount = count
+		members.Message = fmt.Sprintf("%d cluster members", count)
+	}
+
+	status.Checks = append(status.Checks, members, checkNodesStatus(s), checkTerraformLocksStatus(s))
+	status.Checks = append(status.Checks, reportedStatusChecks(s)...)
+
+	for _, check := range status.Checks {
+		if statusSeverities[check.Status] > statusSeverities[status.Overall] {
+			status.Overall = check.Status
+		}
+	}
+
+	return status
+}
+
+// checkNodesStatus is degraded if a node not in maintenance has not been seen recently,
+// and critical if none of them has
+func checkNodesStatus(s *state.State) types.StatusCheck {
+	nodes, err := ListNodes(s, nil, nil)
+	if err != nil {
+		return types.StatusCheck{Name: "nodes", Status: StatusDegraded, Message: fmt.Sprintf("Failed to list nodes: %v", err)}
+	}
+
+	return nodesStatusCheck(nodes, time.Now())
+}
+
+// nodesStatusCheck returns the nodes check at now, a node being stale if it fails its last seen check
+func nodesStatusCheck(nodes types.Nodes, now time.Time) types.StatusCheck {
+	check := types.StatusCheck{Name: "nodes", Status: StatusHealthy}
+
+	var active int
+	var stale []string
+	for _, node := range nodes {
+		if node.MaintenanceMode {
+			continue
+		}
+
+		active++
+
+		lastSeen, err := nodeLastSeenCheck(node, now)
+		if err != nil || !lastSeen.Passed {
+			stale = append(stale, node.Name)
+		}
+	}
+
+	check.Message = fmt.Sprintf("%d nodes, %d in maintenance", len(nodes), len(nodes)-active)
+	if len(stale) == 0 {
+		return check
+	}
+
+	check.Status = StatusDegraded
+	if len(stale) == active {
+		check.Status = StatusCritical
+	}
+
+	check.Message = fmt.Sprintf("Nodes not seen in the last %s: %s", nodeHealthStaleAfter, strings.Join(stale, ", "))
+
+	return check
+}
+
+// checkTerraformLocksStatus is degraded if a terraform lock is held for more than
+// the threshold that degrades the health of the cluster
+func checkTerraformLocksStatus(s *state.State) types.StatusCheck {
+	threshold, err := terraformLockWarnThreshold(s)
+	if err != nil {
+		return types.StatusCheck{Name: "terraform-locks", Status: StatusDegraded, Message: fmt.Sprintf("Failed to get terraform lock warn threshold: %v", err)}
+	}
+
+	locks, err := GetAllTerraformLocks(s)
+	if err != nil {
+		return types.StatusCheck{Name: "terraform-locks", Status: StatusDegraded, Message: fmt.Sprintf("Failed to get terraform locks: %v", err)}
+	}
+
+	return terraformLocksStatusCheck(locks, threshold, time.Now())
+}
+
+// terraformLocksStatusCheck returns the terraform locks check at now, a lock being stale if held for more than threshold
+func terraformLocksStatusCheck(locks map[string]types.Lock, threshold time.Duration, now time.Time) types.StatusCheck {
+	check := types.StatusCheck{Name: "terraform-locks", Status: StatusHealthy}
+
+	var stale []string
+	for name, lock := range locks {
+		if now.Sub(lock.Created) > threshold {
+			stale = append(stale, name)
+		}
+	}
+
+	if len(stale) == 0 {
+		check.Message = fmt.Sprintf("%d terraform locks held", len(locks))
+		return check
+	}
+
+	sort.Strings(stale)
+	check.Status = StatusDegraded
+	check.Message = fmt.Sprintf("Terraform locks held for more than %s: %s", threshold, strings.Join(stale, ", "))
+
+	return check
+}
+
+// reportedStatusChecks returns the check results reported by other components in StatusChecksKey.
+// Results with an unknown status are degraded.
+func reportedStatusChecks(s *state.State) []types.StatusCheck {
+	value, exists, err := GetConfig(s, StatusChecksKey)
+	if err != nil {
+		return []types.StatusCheck{{Name: StatusChecksKey, Status: StatusDegraded, Message: fmt.Sprintf("Failed to get reported checks: %v", err)}}
+	}
+
+	if !exists {
+		return nil
+	}
+
+	var checks []types.StatusCheck
+	err = json.Unmarshal([]byte(value), &checks)
+	if err != nil {
+		return []types.StatusCheck{{Name: StatusChecksKey, Status: StatusDegraded, Message: fmt.Sprintf("Invalid reported checks: %v", err)}}
+	}
+
+	for i, check := range checks {
+		_, ok := statusSeverities[check.Status]
+		if !ok {
+			checks[i].Status = StatusDegraded
+			checks[i].Message = fmt.Sprintf("Unknown status %q reported: %s", check.Status, check.Message)
+		}
+	}
+
+	return checks
+}
@@ -0,0 +1,149 @@
+package sunbeam
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/canonical/snap-openstack/sunbeam-microcluster/api/types"
+	"github.com/canonical/snap-openstack/sunbeam-microcluster/database"
+)
+
+func TestNodesStatusCheck(t *testing.T) {
+	now := time.Now().UTC()
+	recent := now.Add(-time.Minute).Format(time.RFC3339Nano)
+	old := now.Add(-time.Hour).Format(time.RFC3339Nano)
+
+	tests := []struct {
+		name  string
+		nodes types.Nodes
+		want  string
+	}{
+		{
+			name:  "no nodes",
+			nodes: nil,
+			want:  StatusHealthy,
+		},
+		{
+			name:  "all seen",
+			nodes: types.Nodes{{Name: "a", LastSeenAt: recent}, {Name: "b", LastSeenAt: recent}},
+			want:  StatusHealthy,
+		},
+		{
+			name:  "one stale",
+			nodes: types.Nodes{{Name: "a", LastSeenAt: recent}, {Name: "b", LastSeenAt: old}},
+			want:  StatusDegraded,
+		},
+		{
+			name:  "one never seen",
+			nodes: types.Nodes{{Name: "a", LastSeenAt: recent}, {Name: "b"}},
+			want:  StatusDegraded,
+		},
+		{
+			name:  "one invalid",
+			nodes: types.Nodes{{Name: "a", LastSeenAt: recent}, {Name: "b", LastSeenAt: "yesterday"}},
+			want:  StatusDegraded,
+		},
+		{
+			name:  "all stale",
+			nodes: types.Nodes{{Name: "a", LastSeenAt: old}, {Name: "b"}},
+			want:  StatusCritical,
+		},
+		{
+			name:  "stale in maintenance",
+			nodes: types.Nodes{{Name: "a", LastSeenAt: recent}, {Name: "b", LastSeenAt: old, MaintenanceMode: true}},
+			want:  StatusHealthy,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			check := nodesStatusCheck(tt.nodes, now)
+			if check.Status != tt.want {
+				t.Errorf("Nodes check is %s, want %s: %s", check.Status, tt.want, check.Message)
+			}
+		})
+	}
+}
+
+func TestTerraformLocksStatusCheck(t *testing.T) {
+	now := time.Now()
+	locks := map[string]types.Lock{
+		"plan-a": {ID: "a", Created: now.Add(-10 * time.Minute)},
+		"plan-b": {ID: "b", Created: now.Add(-45 * time.Minute)},
+	}
+
+	tests := []struct {
+		name      string
+		threshold time.Duration
+		want      string
+		message   string
+	}{
+		{name: "none stale", threshold: time.Hour, want: StatusHealthy, message: "2 terraform locks held"},
+		{name: "one stale", threshold: 30 * time.Minute, want: StatusDegraded, message: "Terraform locks held for more than 30m0s: plan-b"},
+		{name: "all stale", threshold: 5 * time.Minute, want: StatusDegraded, message: "Terraform locks held for more than 5m0s: plan-a, plan-b"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			check := terraformLocksStatusCheck(locks, tt.threshold, now)
+			if check.Status != tt.want || check.Message != tt.message {
+				t.Errorf("Terraform locks check is %s %q, want %s %q", check.Status, check.Message, tt.want, tt.message)
+			}
+		})
+	}
+}
+
+func TestCachedClusterMemberCount(t *testing.T) {
+	tx := database.NewTestSchemaTx(t)
+	ctx := context.Background()
+
+	cachedMemberCount.Store(nil)
+	t.Cleanup(func() { cachedMemberCount.Store(nil) })
+
+	addMember := func(name string) {
+		_, err := tx.Exec(`INSERT INTO internal_cluster_members (name, address, certificate, schema_internal, schema_external, heartbeat, role)
+  VALUES (?, ?, ?, 1, 1, '2024-01-01 00:00:00', 'voter')`, name, name, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	fetches := 0
+	fetch := func() (int, error) {
+		fetches++
+		return database.CountClusterMembers(ctx, tx)
+	}
+
+	for _, name := range []string{"member-1", "member-2", "member-3"} {
+		addMember(name)
+	}
+
+	now := time.Now()
+
+	tests := []struct {
+		name        string
+		now         time.Time
+		want        int
+		wantFetches int
+	}{
+		{name: "first count", now: now, want: 3, wantFetches: 1},
+		{name: "within the TTL", now: now.Add(memberCountCacheTTL - time.Second), want: 3, wantFetches: 1},
+		{name: "after the TTL", now: now.Add(memberCountCacheTTL + time.Second), want: 4, wantFetches: 2},
+	}
+
+	for i, tt := range tests {
+		if i == 1 {
+			addMember("member-4")
+		}
+
+		count, err := cachedClusterMemberCount(tt.now, fetch)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if count != tt.want || fetches != tt.wantFetches {
+			t.Errorf("%s: got %d members after %d fetches, want %d after %d", tt.name, count, fetches, tt.want, tt.wantFetches)
+		}
+	}
+}
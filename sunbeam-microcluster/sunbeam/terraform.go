@@ -1,75 +1,453 @@
 package sunbeam
 
 import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
 	"encoding/json"
+	"fmt"
+	"io"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/canonical/lxd/shared/api"
+	"github.com/canonical/lxd/shared/logger"
 	"github.com/canonical/microcluster/state"
 
 	"github.com/canonical/snap-openstack/sunbeam-microcluster/api/types"
+	"github.com/canonical/snap-openstack/sunbeam-microcluster/database"
 )
 
 const tfstatePrefix = "tfstate-"
 const tflockPrefix = "tflock-"
 
-// GetTerraformStates returns the list of terraform states from the database
-func GetTerraformStates(s *state.State) ([]string, error) {
-	prefix := tfstatePrefix
-	states, err := GetConfigItemKeys(s, &prefix)
+// terraformKeyPrefixes are the prefixes of the config keys holding terraform states and locks,
+// which are not listed with the other config keys as states are large and hold secrets.
+var terraformKeyPrefixes = []string{tfstatePrefix, tflockPrefix}
+
+// TerraformStateMaxBytesKey is the config key holding the maximum size in bytes of a terraform state
+const TerraformStateMaxBytesKey = "config.terraform-state-max-bytes"
+
+// defaultTerraformStateMaxBytes is used when TerraformStateMaxBytesKey is not set
+const defaultTerraformStateMaxBytes = 10 * 1024 * 1024
+
+// TerraformStateVersionsKey is the config key holding the number of versions kept of each terraform state
+const TerraformStateVersionsKey = "config.terraform-state-versions"
+
+// defaultTerraformStateVersions is used when TerraformStateVersionsKey is not set
+const defaultTerraformStateVersions = 50
+
+// compressedLockPrefix marks a terraform lock stored zlib compressed and base64 encoded by earlier releases
+const compressedLockPrefix = "zlib:"
+
+// DefaultTerraformStateSort is the sort order used when listing terraform states
+const DefaultTerraformStateSort = "name_asc"
+
+// terraformStateSorts maps the supported sort orders to SQL ORDER BY expressions.
+// States not written since config.updated_at was added have no update time and sort as the oldest.
+var terraformStateSorts = map[string]string{
+	"name_asc":     "config.key ASC",
+	"name_desc":    "config.key DESC",
+	"size_asc":     "length(config.value) ASC, config.key ASC",
+	"size_desc":    "length(config.value) DESC, config.key ASC",
+	"updated_asc":  "config.updated_at ASC, config.key ASC",
+	"updated_desc": "config.updated_at DESC, config.key ASC",
+}
+
+// TerraformStateFilter selects the terraform states returned by ListTerraformStates
+type TerraformStateFilter struct {
+	// Workspace holding the states, empty for the default workspace
+	Workspace string
+	// Prefix the state names start with
+	Prefix string
+	// Sort is one of name_asc, name_desc, size_asc, size_desc, updated_asc or updated_desc, name_asc if empty
+	Sort string
+}
+
+// GetTerraformStates returns the list of terraform states of workspace from the database
+// sorted by sortBy, one of name_asc, name_desc, size_asc, size_desc, updated_asc or updated_desc.
+// An empty workspace is the default workspace.
+func GetTerraformStates(s *state.State, sortBy string, workspace string) ([]string, error) {
+	plans, _, err := ListTerraformStates(s, TerraformStateFilter{Workspace: workspace, Sort: sortBy}, 0, 0)
+	return plans, err
+}
+
+// ListTerraformStates returns the terraform states matching filter, skipping the first offset states
+// and returning at most limit states if limit is positive, along with the number of matching states
+func ListTerraformStates(s *state.State, filter TerraformStateFilter, offset int, limit int) ([]string, int, error) {
+	orderBy, err := terraformStateOrderBy(filter.Sort)
 	if err != nil {
-		return nil, err
+		return nil, -1, err
+	}
+
+	workspacePrefix := tfstatePrefix
+	if filter.Workspace != "" {
+		workspacePrefix += filter.Workspace + "/"
+	}
+
+	// States of other workspaces are not part of the default workspace
+	keyFilter := database.ConfigKeyFilter{
+		Prefix:       workspacePrefix + filter.Prefix,
+		ExcludeAfter: "/",
+		ExcludeFrom:  len(workspacePrefix),
+	}
+
+	var states []string
+	var total int
+	err = s.Database.Transaction(s.Context, func(ctx context.Context, tx *sql.Tx) error {
+		var err error
+		states, total, err = database.GetConfigItemKeysPage(ctx, tx, keyFilter, orderBy, offset, limit)
+		return err
+	})
+	if err != nil {
+		return nil, -1, err
 	}
 
 	plans := make([]string, len(states))
 	for i, state := range states {
-		plans[i] = strings.TrimPrefix(state, tfstatePrefix)
+		plans[i] = strings.TrimPrefix(state, workspacePrefix)
+	}
+
+	return plans, total, nil
+}
+
+// terraformStateOrderBy returns the ORDER BY expression of sortBy, DefaultTerraformStateSort if empty
+func terraformStateOrderBy(sortBy string) (string, error) {
+	if sortBy == "" {
+		sortBy = DefaultTerraformStateSort
+	}
+
+	orderBy, ok := terraformStateSorts[sortBy]
+	if !ok {
+		return "", api.StatusErrorf(http.StatusBadRequest, "Unknown sort %q, expected one of name_asc, name_desc, size_asc, size_desc, updated_asc, updated_desc", sortBy)
+	}
+
+	return orderBy, nil
+}
+
+// ValidateTerraformWorkspace checks that workspace can be used as a state name prefix
+func ValidateTerraformWorkspace(workspace string) error {
+	if strings.Contains(workspace, "/") {
+		return api.StatusErrorf(http.StatusBadRequest, "Invalid terraform workspace %q, must not contain /", workspace)
+	}
+
+	return nil
+}
+
+// TerraformWorkspaceStateName returns the name the state name is stored under in workspace.
+// An empty workspace is the default workspace, whose states are stored under their name.
+func TerraformWorkspaceStateName(workspace string, name string) (string, error) {
+	err := ValidateTerraformWorkspace(workspace)
+	if err != nil {
+		return "", err
+	}
+
+	if workspace == "" {
+		return name, nil
 	}
 
-	return plans, nil
+	return workspace + "/" + name, nil
+}
+
+// ListTerraformWorkspaces returns the terraform workspaces holding at least one state
+func ListTerraformWorkspaces(s *state.State) ([]string, error) {
+	var workspaces []string
+
+	err := s.Database.Transaction(s.Context, func(ctx context.Context, tx *sql.Tx) error {
+		var err error
+		workspaces, err = database.GetConfigKeyPrefixes(ctx, tx, tfstatePrefix, "/")
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return workspaces, nil
+}
+
+// TerraformStateMaxBytes returns the maximum size of a terraform state from config, or the default if unset or invalid
+func TerraformStateMaxBytes(s *state.State) (int64, error) {
+	value, exists, err := GetConfig(s, TerraformStateMaxBytesKey)
+	if err != nil {
+		return 0, err
+	}
+
+	if !exists {
+		return defaultTerraformStateMaxBytes, nil
+	}
+
+	maxBytes, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || maxBytes <= 0 {
+		logger.Warnf("Invalid %s %q, using default of %d", TerraformStateMaxBytesKey, value, defaultTerraformStateMaxBytes)
+		return defaultTerraformStateMaxBytes, nil
+	}
+
+	return maxBytes, nil
 }
 
 // GetTerraformState returns the terraform state from the database
 func GetTerraformState(s *state.State, name string) (string, error) {
-	tfstateKey := tfstatePrefix + name
-	state, err := GetConfig(s, tfstateKey)
+	state, _, err := GetTerraformStateWithChecksum(s, name)
 	return state, err
 }
 
-// UpdateTerraformState updates the terraform state record in the database
-func UpdateTerraformState(s *state.State, name string, lockID string, state string) (types.Lock, error) {
+// GetTerraformStateWithChecksum returns the terraform state from the database and its checksum.
+// An error is returned if the state does not match the checksum stored when it was written.
+func GetTerraformStateWithChecksum(s *state.State, name string) (string, string, error) {
+	var state string
+	var checksum string
+
+	tfstateKey := tfstatePrefix + name
+	err := s.Database.Transaction(s.Context, func(ctx context.Context, tx *sql.Tx) error {
+		record, err := database.GetConfigItem(ctx, tx, tfstateKey)
+		if err != nil {
+			if api.StatusErrorCheck(err, http.StatusNotFound) {
+				return api.StatusErrorf(http.StatusNotFound, "Terraform state not found")
+			}
+
+			return err
+		}
+
+		stored, hasChecksum, err := database.GetConfigItemChecksum(ctx, tx, tfstateKey)
+		if err != nil {
+			return err
+		}
+
+		state = record.Value
+		checksum = terraformStateChecksum(state)
+
+		// States written before checksums were introduced have none to verify against
+		if hasChecksum && stored != checksum {
+			return fmt.Errorf("Terraform state %q is corrupted, its checksum %s does not match the stored checksum %s", name, checksum, stored)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return "", "", err
+	}
+
+	return state, checksum, nil
+}
+
+// terraformStateChecksum returns the hex encoded SHA-256 digest of state
+func terraformStateChecksum(state string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(state)))
+}
+
+// UpdateTerraformState updates the terraform state record in the database.
+// If expectedChecksum is not empty, the state is only updated if the checksum
+// of the current state matches it.
+func UpdateTerraformState(s *state.State, name string, lockID string, expectedChecksum string, state string) (types.Lock, error) {
 	var dbLock types.Lock
 
 	tflockKey := tflockPrefix + name
-	lockInDb, err := GetConfig(s, tflockKey)
+	err := s.Database.Transaction(s.Context, func(ctx context.Context, tx *sql.Tx) error {
+		lockInDb, exists, err := terraformLockRecord(ctx, tx, tflockKey)
+		if err != nil {
+			return err
+		}
+
+		if !exists {
+			return api.StatusErrorf(http.StatusNotFound, "Terraform lock not found")
+		}
+
+		err = json.Unmarshal([]byte(lockInDb), &dbLock)
+		if err != nil {
+			return err
+		}
+
+		if lockID != dbLock.ID {
+			return api.StatusErrorf(http.StatusConflict, "Conflict in Lock ID")
+		}
+
+		if expectedChecksum != "" {
+			var current string
+			record, err := database.GetConfigItem(ctx, tx, tfstatePrefix+name)
+			if err == nil {
+				current = terraformStateChecksum(record.Value)
+			} else if !api.StatusErrorCheck(err, http.StatusNotFound) {
+				return err
+			}
+
+			if current != expectedChecksum {
+				return api.StatusErrorf(http.StatusPreconditionFailed, "Terraform state %q does not match the expected checksum", name)
+			}
+		}
+
+		return writeTerraformState(ctx, tx, name, state)
+	})
 	if err != nil {
 		return dbLock, err
 	}
 
-	err = json.Unmarshal([]byte(lockInDb), &dbLock)
+	return dbLock, nil
+}
+
+// writeTerraformState sets the current terraform state and records it as a new version.
+// The oldest versions are deleted so that only the number of versions set in config is kept.
+func writeTerraformState(ctx context.Context, tx *sql.Tx, name string, state string) error {
+	err := updateConfigItem(ctx, tx, tfstatePrefix+name, state, nil)
 	if err != nil {
-		return dbLock, err
+		return err
 	}
 
-	if lockID != dbLock.ID {
-		return dbLock, api.StatusErrorf(http.StatusConflict, "Conflict in Lock ID")
+	err = database.SetConfigItemChecksum(ctx, tx, tfstatePrefix+name, terraformStateChecksum(state))
+	if err != nil {
+		return err
 	}
 
-	tfstateKey := tfstatePrefix + name
-	err = UpdateConfig(s, tfstateKey, state)
+	version, err := database.CreateTerraformStateVersion(ctx, tx, name, state)
 	if err != nil {
-		return dbLock, err
+		return err
 	}
 
-	return dbLock, nil
+	kept, err := terraformStateVersions(ctx, tx)
+	if err != nil {
+		return err
+	}
+
+	return database.DeleteTerraformStateVersionsBefore(ctx, tx, name, version-kept+1)
+}
+
+// terraformStateVersions returns the number of versions kept of each terraform state from config,
+// or the default if unset or invalid
+func terraformStateVersions(ctx context.Context, tx *sql.Tx) (int, error) {
+	value, err := configValue(ctx, tx, TerraformStateVersionsKey)
+	if err != nil {
+		return 0, err
+	}
+
+	if value == nil {
+		return defaultTerraformStateVersions, nil
+	}
+
+	versions, err := strconv.Atoi(*value)
+	if err != nil || versions <= 0 {
+		logger.Warnf("Invalid %s %q, using default of %d", TerraformStateVersionsKey, *value, defaultTerraformStateVersions)
+		return defaultTerraformStateVersions, nil
+	}
+
+	return versions, nil
+}
+
+// GetTerraformStateVersions returns the versions of the terraform state, newest first
+func GetTerraformStateVersions(s *state.State, name string) ([]types.StateVersion, error) {
+	versions := []types.StateVersion{}
+
+	err := s.Database.Transaction(s.Context, func(ctx context.Context, tx *sql.Tx) error {
+		records, err := database.GetTerraformStateVersions(ctx, tx, name)
+		if err != nil {
+			return err
+		}
+
+		if len(records) == 0 {
+			exists, err := database.ConfigItemExists(ctx, tx, tfstatePrefix+name)
+			if err != nil {
+				return err
+			}
+
+			if !exists {
+				return api.StatusErrorf(http.StatusNotFound, "Terraform state not found")
+			}
+		}
+
+		for _, record := range records {
+			appliedAt, err := parseDBTimestamp(record.AppliedAt)
+			if err != nil {
+				return err
+			}
+
+			versions = append(versions, types.StateVersion{
+				Version:   record.Version,
+				AppliedAt: appliedAt.UTC().Format(time.RFC3339),
+			})
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return versions, nil
+}
+
+// RollbackTerraformState makes the given version the current terraform state, recorded as a new version.
+// The state cannot be rolled back while it is locked.
+func RollbackTerraformState(s *state.State, name string, version int) error {
+	return s.Database.Transaction(s.Context, func(ctx context.Context, tx *sql.Tx) error {
+		locked, err := database.ConfigItemExists(ctx, tx, tflockPrefix+name)
+		if err != nil {
+			return err
+		}
+
+		if locked {
+			return api.StatusErrorf(http.StatusConflict, "Terraform state %q is locked", name)
+		}
+
+		record, err := database.GetTerraformStateVersion(ctx, tx, name, version)
+		if err != nil {
+			if api.StatusErrorCheck(err, http.StatusNotFound) {
+				return api.StatusErrorf(http.StatusNotFound, "Version %d of terraform state %q not found", version, name)
+			}
+
+			return err
+		}
+
+		return writeTerraformState(ctx, tx, name, record.State)
+	})
+}
+
+// CopyTerraformState copies the terraform state source to the new state target
+func CopyTerraformState(s *state.State, source string, target string) error {
+	return s.Database.Transaction(s.Context, func(ctx context.Context, tx *sql.Tx) error {
+		return copyTerraformState(ctx, tx, source, target)
+	})
 }
 
-// DeleteTerraformState deletes the terraform state from the database
+// copyTerraformState writes the current terraform state source as the first version of the new state target
+func copyTerraformState(ctx context.Context, tx *sql.Tx, source string, target string) error {
+	record, err := database.GetConfigItem(ctx, tx, tfstatePrefix+source)
+	if err != nil {
+		if api.StatusErrorCheck(err, http.StatusNotFound) {
+			return api.StatusErrorf(http.StatusNotFound, "Terraform state %q not found", source)
+		}
+		return err
+	}
+
+	exists, err := database.ConfigItemExists(ctx, tx, tfstatePrefix+target)
+	if err != nil {
+		return err
+	}
+
+	if exists {
+		return api.StatusErrorf(http.StatusConflict, "Terraform state %q already exists", target)
+	}
+
+	err = writeTerraformState(ctx, tx, target, record.Value)
+	if err != nil {
+		return fmt.Errorf("Failed to copy terraform state: %w", err)
+	}
+
+	return nil
+}
+
+// DeleteTerraformState deletes the terraform state and its versions from the database
 func DeleteTerraformState(s *state.State, name string) error {
-	tfstateKey := tfstatePrefix + name
-	err := DeleteConfig(s, tfstateKey)
-	return err
+	return s.Database.Transaction(s.Context, func(ctx context.Context, tx *sql.Tx) error {
+		err := database.DeleteConfigItem(ctx, tx, tfstatePrefix+name)
+		if err != nil {
+			return err
+		}
+
+		return database.DeleteTerraformStateVersions(ctx, tx, name)
+	})
 }
 
 // GetTerraformLocks returns the list of terraform locks from the database
@@ -88,14 +466,57 @@ func GetTerraformLocks(s *state.State) ([]string, error) {
 	return trimmedLocks, nil
 }
 
+// GetAllTerraformLocks returns all the terraform locks from the database keyed by name
+func GetAllTerraformLocks(s *state.State) (map[string]types.Lock, error) {
+	locks := map[string]types.Lock{}
+
+	err := s.Database.Transaction(s.Context, func(ctx context.Context, tx *sql.Tx) error {
+		records, err := database.GetConfigItemsWithPrefix(ctx, tx, tflockPrefix)
+		if err != nil {
+			return err
+		}
+
+		for _, record := range records {
+			value, err := decodeTerraformLock(record.Value)
+			if err != nil {
+				return fmt.Errorf("Failed to decode terraform lock %q: %w", record.Key, err)
+			}
+
+			var lock types.Lock
+			err = json.Unmarshal([]byte(value), &lock)
+			if err != nil {
+				return fmt.Errorf("Failed to parse terraform lock %q: %w", record.Key, err)
+			}
+
+			locks[strings.TrimPrefix(record.Key, tflockPrefix)] = lock
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return locks, nil
+}
+
 // GetTerraformLock returns the terraform lock from the database
 func GetTerraformLock(s *state.State, name string) (string, error) {
 	tflockKey := tflockPrefix + name
-	lock, err := GetConfig(s, tflockKey)
-	return lock, err
+	lock, exists, err := getTerraformLockRecord(s, tflockKey)
+	if err != nil {
+		return "", err
+	}
+
+	if !exists {
+		return "", api.StatusErrorf(http.StatusNotFound, "Terraform lock not found")
+	}
+
+	return lock, nil
 }
 
-// UpdateTerraformLock updates the terraform lock record in the database
+// UpdateTerraformLock updates the terraform lock record in the database.
+// A lock whose TTL has passed is replaced as if it did not exist.
 func UpdateTerraformLock(s *state.State, name string, lock string) (types.Lock, error) {
 	var reqLock types.Lock
 	var dbLock types.Lock
@@ -105,27 +526,54 @@ func UpdateTerraformLock(s *state.State, name string, lock string) (types.Lock,
 		return dbLock, err
 	}
 
+	err = s.Database.Transaction(s.Context, func(ctx context.Context, tx *sql.Tx) error {
+		var err error
+		dbLock, err = updateTerraformLock(ctx, tx, s.Name(), name, reqLock, time.Now().UTC())
+		return err
+	})
+
+	return dbLock, err
+}
+
+// updateTerraformLock acquires the terraform lock name with reqLock on behalf of member at now,
+// reading and writing the lock in tx. The lock held in the database is returned, empty if none.
+func updateTerraformLock(ctx context.Context, tx *sql.Tx, member string, name string, reqLock types.Lock, now time.Time) (types.Lock, error) {
+	var dbLock types.Lock
+
 	tflockKey := tflockPrefix + name
-	lockInDb, err := GetConfig(s, tflockKey)
-	if err != nil {
-		if err, ok := err.(api.StatusError); ok {
-			// No Lock exists, add lock details in DB
-			if err.Status() == http.StatusNotFound {
-				j, err := json.Marshal(reqLock)
-				if err != nil {
-					return dbLock, err
-				}
-
-				err = UpdateConfig(s, tflockKey, string(j))
-				return dbLock, err
-			}
-		}
+	lockInDb, exists, err := terraformLockRecord(ctx, tx, tflockKey)
+	if err != nil {
 		return dbLock, err
 	}
 
-	err = json.Unmarshal([]byte(lockInDb), &dbLock)
-	if err != nil {
-		return dbLock, err
+	if exists {
+		err = json.Unmarshal([]byte(lockInDb), &dbLock)
+		if err != nil {
+			return dbLock, err
+		}
+
+		if terraformLockExpired(dbLock, now) {
+			logger.Infof("Replacing expired terraform lock %q held by %q", name, dbLock.Who)
+			exists = false
+			dbLock = types.Lock{}
+		}
+	}
+
+	// No Lock exists, add lock details in DB
+	if !exists {
+		reqLock.AcquiredAt = &now
+		reqLock.ExpiresAt = nil
+		if reqLock.TTL > 0 {
+			expiresAt := now.Add(time.Duration(reqLock.TTL) * time.Second)
+			reqLock.ExpiresAt = &expiresAt
+		}
+
+		j, err := json.Marshal(reqLock)
+		if err != nil {
+			return dbLock, err
+		}
+
+		return dbLock, setConfigItem(ctx, tx, member, tflockKey, string(j), nil)
 	}
 
 	// If the lock from DB and request are same, send http 423
@@ -137,39 +585,285 @@ func UpdateTerraformLock(s *state.State, name string, lock string) (types.Lock,
 	return dbLock, api.StatusErrorf(http.StatusConflict, "Conflict in Lock ID")
 }
 
-// DeleteTerraformLock deletes the terraform lock from the database
-func DeleteTerraformLock(s *state.State, name string, lock string) (types.Lock, error) {
-	var reqLock types.Lock
+// terraformLockExpired returns whether the TTL of lock has passed at now.
+// The TTL counts from the last heartbeat of the lock, or else from when it was acquired.
+func terraformLockExpired(lock types.Lock, now time.Time) bool {
+	if lock.TTL <= 0 {
+		return false
+	}
+
+	since := lock.LastHeartbeatAt
+	if since == nil {
+		since = lock.AcquiredAt
+	}
+
+	if since == nil {
+		return lock.ExpiresAt != nil && lock.ExpiresAt.Before(now)
+	}
+
+	return since.Add(time.Duration(lock.TTL) * time.Second).Before(now)
+}
+
+// HeartbeatTerraformLock renews the terraform lock held with lockID,
+// extending its TTL from now.
+func HeartbeatTerraformLock(s *state.State, name string, lockID string) (types.Lock, error) {
 	var dbLock types.Lock
 
-	err := json.Unmarshal([]byte(lock), &reqLock)
+	tflockKey := tflockPrefix + name
+	err := s.Database.Transaction(s.Context, func(ctx context.Context, tx *sql.Tx) error {
+		record, err := database.GetConfigItem(ctx, tx, tflockKey)
+		if err != nil {
+			if api.StatusErrorCheck(err, http.StatusNotFound) {
+				return api.StatusErrorf(http.StatusNotFound, "Terraform lock not found")
+			}
+
+			return err
+		}
+
+		value, err := decodeTerraformLock(record.Value)
+		if err != nil {
+			return fmt.Errorf("Failed to decode terraform lock: %w", err)
+		}
+
+		err = json.Unmarshal([]byte(value), &dbLock)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now().UTC()
+		if dbLock.ID != lockID || terraformLockExpired(dbLock, now) {
+			return api.StatusErrorf(http.StatusConflict, "Conflict in Lock ID")
+		}
+
+		dbLock.LastHeartbeatAt = &now
+		dbLock.HeartbeatCount++
+		if dbLock.TTL > 0 {
+			expiresAt := now.Add(time.Duration(dbLock.TTL) * time.Second)
+			dbLock.ExpiresAt = &expiresAt
+		}
+
+		j, err := json.Marshal(dbLock)
+		if err != nil {
+			return err
+		}
+
+		return updateConfigItem(ctx, tx, tflockKey, string(j), nil)
+	})
 	if err != nil {
 		return dbLock, err
 	}
 
-	tflockKey := tflockPrefix + name
-	lockInDb, err := GetConfig(s, tflockKey)
+	return dbLock, nil
+}
+
+// ReleaseExpiredTerraformLocks deletes the terraform locks whose TTL has passed
+// and returns the names of the released locks
+func ReleaseExpiredTerraformLocks(s *state.State) ([]string, error) {
+	var released []string
+
+	err := s.Database.Transaction(s.Context, func(ctx context.Context, tx *sql.Tx) error {
+		var err error
+		released, err = releaseExpiredTerraformLocks(ctx, tx, time.Now().UTC())
+		return err
+	})
 	if err != nil {
-		if err, ok := err.(api.StatusError); ok {
-			// No Lock exists to unlock, send 200: OK
-			if err.Status() == http.StatusNotFound {
-				return dbLock, nil
+		return nil, err
+	}
+
+	return released, nil
+}
+
+// releaseExpiredTerraformLocks deletes the terraform locks whose TTL has passed at now and returns their names.
+// The locks are checked and deleted in tx, so that a lock renewed or replaced concurrently is not released.
+func releaseExpiredTerraformLocks(ctx context.Context, tx *sql.Tx, now time.Time) ([]string, error) {
+	records, err := database.GetConfigItemsWithPrefix(ctx, tx, tflockPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	released := []string{}
+	for _, record := range records {
+		value, err := decodeTerraformLock(record.Value)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to decode terraform lock %q: %w", record.Key, err)
+		}
+
+		var lock types.Lock
+		err = json.Unmarshal([]byte(value), &lock)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse terraform lock %q: %w", record.Key, err)
+		}
+
+		if !terraformLockExpired(lock, now) {
+			continue
+		}
+
+		err = database.DeleteConfigItem(ctx, tx, record.Key)
+		if err != nil {
+			return nil, err
+		}
+
+		released = append(released, strings.TrimPrefix(record.Key, tflockPrefix))
+	}
+
+	return released, nil
+}
+
+// ForceDeleteTerraformLock deletes the terraform lock without checking its ID
+// and records who forced the unlock in the terraform lock audit log
+func ForceDeleteTerraformLock(s *state.State, name string, caller string) (types.Lock, error) {
+	var dbLock types.Lock
+
+	tflockKey := tflockPrefix + name
+	err := s.Database.Transaction(s.Context, func(ctx context.Context, tx *sql.Tx) error {
+		record, err := database.GetConfigItem(ctx, tx, tflockKey)
+		if err != nil {
+			if api.StatusErrorCheck(err, http.StatusNotFound) {
+				return api.StatusErrorf(http.StatusNotFound, "Terraform lock not found")
 			}
+
+			return err
 		}
+
+		value, err := decodeTerraformLock(record.Value)
+		if err != nil {
+			return fmt.Errorf("Failed to decode terraform lock: %w", err)
+		}
+
+		err = json.Unmarshal([]byte(value), &dbLock)
+		if err != nil {
+			return err
+		}
+
+		err = database.DeleteConfigItem(ctx, tx, tflockKey)
+		if err != nil {
+			return err
+		}
+
+		return database.CreateTerraformLockAuditEntry(ctx, tx, database.TerraformLockAuditEntry{
+			Name:    name,
+			Action:  "force-unlock",
+			LockID:  dbLock.ID,
+			LockWho: dbLock.Who,
+			Caller:  caller,
+			Member:  s.Name(),
+		})
+	})
+	if err != nil {
 		return dbLock, err
 	}
 
-	err = json.Unmarshal([]byte(lockInDb), &dbLock)
+	logger.Warnf("Terraform lock %q held by %q was force unlocked by %q", name, dbLock.Who, caller)
+
+	return dbLock, nil
+}
+
+// DeleteTerraformLock deletes the terraform lock from the database
+// An empty lock is treated as a lock with no ID.
+func DeleteTerraformLock(s *state.State, name string, lock string) (types.Lock, error) {
+	var dbLock types.Lock
+
+	err := s.Database.Transaction(s.Context, func(ctx context.Context, tx *sql.Tx) error {
+		var err error
+		dbLock, err = deleteTerraformLock(ctx, tx, name, lock)
+		return err
+	})
+
+	return dbLock, err
+}
+
+// deleteTerraformLock releases the terraform lock name held with lock, reading and deleting the lock in tx.
+// An empty lock is treated as a lock with no ID. The lock held in the database is returned, empty if none.
+func deleteTerraformLock(ctx context.Context, tx *sql.Tx, name string, lock string) (types.Lock, error) {
+	var reqLock types.Lock
+	var dbLock types.Lock
+
+	if lock != "" {
+		err := json.Unmarshal([]byte(lock), &reqLock)
+		if err != nil {
+			return dbLock, err
+		}
+	}
+
+	tflockKey := tflockPrefix + name
+	lockInDb, exists, err := terraformLockRecord(ctx, tx, tflockKey)
 	if err != nil {
 		return dbLock, err
 	}
 
-	// If the lock from DB and request are same, clear the lock from DB
-	if dbLock.ID == reqLock.ID && dbLock.Operation == reqLock.Operation && dbLock.Who == reqLock.Who {
-		err = DeleteConfig(s, tflockKey)
+	// No Lock exists to unlock, send 200: OK
+	if !exists {
+		return dbLock, nil
+	}
+
+	err = json.Unmarshal([]byte(lockInDb), &dbLock)
+	if err != nil {
 		return dbLock, err
 	}
 
 	// Request has different lock id than in database, send http 409
-	return dbLock, api.StatusErrorf(http.StatusConflict, "Conflict in Lock ID")
+	if dbLock.ID != reqLock.ID || dbLock.Operation != reqLock.Operation || dbLock.Who != reqLock.Who {
+		return dbLock, api.StatusErrorf(http.StatusConflict, "Conflict in Lock ID")
+	}
+
+	// The lock from DB and request are same, clear the lock from DB
+	return dbLock, database.DeleteConfigItem(ctx, tx, tflockKey)
+}
+
+// getTerraformLockRecord returns the decoded terraform lock stored under key and whether it exists
+func getTerraformLockRecord(s *state.State, key string) (string, bool, error) {
+	var lock string
+	var exists bool
+
+	err := s.Database.Transaction(s.Context, func(ctx context.Context, tx *sql.Tx) error {
+		var err error
+		lock, exists, err = terraformLockRecord(ctx, tx, key)
+		return err
+	})
+
+	return lock, exists, err
+}
+
+// terraformLockRecord returns the decoded terraform lock stored under key in tx and whether it exists
+func terraformLockRecord(ctx context.Context, tx *sql.Tx, key string) (string, bool, error) {
+	value, err := configValue(ctx, tx, key)
+	if err != nil || value == nil {
+		return "", false, err
+	}
+
+	lock, err := decodeTerraformLock(*value)
+	if err != nil {
+		return "", false, fmt.Errorf("Failed to decode terraform lock: %w", err)
+	}
+
+	return lock, true, nil
+}
+
+// decodeTerraformLock returns the JSON of the lock stored as value.
+// Locks are stored as is, the ones stored zlib compressed and base64 encoded
+// by earlier releases are decompressed.
+func decodeTerraformLock(value string) (string, error) {
+	encoded, ok := strings.CutPrefix(value, compressedLockPrefix)
+	if !ok {
+		return value, nil
+	}
+
+	compressed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", err
+	}
+
+	r, err := zlib.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return "", err
+	}
+
+	defer func() { _ = r.Close() }()
+
+	lock, err := io.ReadAll(r)
+	if err != nil {
+		return "", err
+	}
+
+	return string(lock), nil
 }
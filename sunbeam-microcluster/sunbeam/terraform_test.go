@@ -0,0 +1,427 @@
+package sunbeam
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/canonical/lxd/shared/api"
+
+	"github.com/canonical/snap-openstack/sunbeam-microcluster/api/types"
+	"github.com/canonical/snap-openstack/sunbeam-microcluster/database"
+)
+
+func TestTerraformStateOrderBy(t *testing.T) {
+	tx := database.NewTestSchemaTx(t)
+
+	// tfstate-c has not been written since the update time was recorded.
+	states := []struct {
+		key       string
+		value     string
+		updatedAt any
+	}{
+		{"tfstate-b", "xx", "2024-01-01 00:00:00"},
+		{"tfstate-a", "xxx", "2024-01-03 00:00:00"},
+		{"tfstate-c", "x", nil},
+		{"tfstate-d", "xx", "2024-01-02 00:00:00"},
+	}
+
+	for _, state := range states {
+		_, err := tx.Exec(`INSERT INTO config (key, value, updated_at) VALUES (?, ?, ?)`, state.key, state.value, state.updatedAt)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		sort string
+		want []string
+	}{
+		{"", []string{"tfstate-a", "tfstate-b", "tfstate-c", "tfstate-d"}},
+		{"name_asc", []string{"tfstate-a", "tfstate-b", "tfstate-c", "tfstate-d"}},
+		{"name_desc", []string{"tfstate-d", "tfstate-c", "tfstate-b", "tfstate-a"}},
+		{"size_asc", []string{"tfstate-c", "tfstate-b", "tfstate-d", "tfstate-a"}},
+		{"size_desc", []string{"tfstate-a", "tfstate-b", "tfstate-d", "tfstate-c"}},
+		{"updated_asc", []string{"tfstate-c", "tfstate-b", "tfstate-d", "tfstate-a"}},
+		{"updated_desc", []string{"tfstate-a", "tfstate-d", "tfstate-b", "tfstate-c"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.sort, func(t *testing.T) {
+			orderBy, err := terraformStateOrderBy(tt.sort)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			keys, _, err := database.GetConfigItemKeysPage(context.Background(), tx, database.ConfigKeyFilter{Prefix: tfstatePrefix}, orderBy, 0, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(keys, tt.want) {
+				t.Errorf("Sort %q returned %v, want %v", tt.sort, keys, tt.want)
+			}
+		})
+	}
+}
+
+func TestTerraformStateOrderByUnknown(t *testing.T) {
+	for _, sortBy := range []string{"name", "updated", "NAME_ASC", "name_asc; DROP TABLE config"} {
+		_, err := terraformStateOrderBy(sortBy)
+		if !api.StatusErrorCheck(err, http.StatusBadRequest) {
+			t.Errorf("Sort %q returned %v, want a 400 error", sortBy, err)
+		}
+	}
+}
+
+func TestCopyTerraformState(t *testing.T) {
+	tx := database.NewTestSchemaTx(t)
+	ctx := context.Background()
+
+	err := writeTerraformState(ctx, tx, "source", `{"serial":1}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = writeTerraformState(ctx, tx, "source", `{"serial":2}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = copyTerraformState(ctx, tx, "source", "target")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	record, err := database.GetConfigItem(ctx, tx, tfstatePrefix+"target")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if record.Value != `{"serial":2}` {
+		t.Errorf("Copied state is %s, want %s", record.Value, `{"serial":2}`)
+	}
+
+	versions, err := database.GetTerraformStateVersions(ctx, tx, "target")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(versions) != 1 || versions[0].Version != 1 {
+		t.Errorf("Copied state has versions %v, want only version 1", versions)
+	}
+
+	versions, err = database.GetTerraformStateVersions(ctx, tx, "source")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(versions) != 2 {
+		t.Errorf("Source state has %d versions after the copy, want 2", len(versions))
+	}
+}
+
+func TestCopyTerraformStateErrors(t *testing.T) {
+	tx := database.NewTestSchemaTx(t)
+	ctx := context.Background()
+
+	err := writeTerraformState(ctx, tx, "source", `{"serial":1}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = writeTerraformState(ctx, tx, "target", `{"serial":1}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = copyTerraformState(ctx, tx, "missing", "new")
+	if !api.StatusErrorCheck(err, http.StatusNotFound) {
+		t.Errorf("Copy of a missing state returned %v, want a 404 error", err)
+	}
+
+	err = copyTerraformState(ctx, tx, "source", "target")
+	if !api.StatusErrorCheck(err, http.StatusConflict) {
+		t.Errorf("Copy onto an existing state returned %v, want a 409 error", err)
+	}
+}
+
+func TestDecodeTerraformLockCompressed(t *testing.T) {
+	lock := `{"ID":"1","Operation":"OperationTypeApply","Who":"user@host"}`
+
+	var buf bytes.Buffer
+	w := zlib.NewWriter(&buf)
+	_, err := w.Write([]byte(lock))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoded, err := decodeTerraformLock(compressedLockPrefix + base64.StdEncoding.EncodeToString(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if decoded != lock {
+		t.Errorf("Decoded lock is %q, want %q", decoded, lock)
+	}
+}
+
+func TestDecodeTerraformLockUncompressed(t *testing.T) {
+	lock := `{"ID":"1","Operation":"OperationTypeApply","Who":"user@host"}`
+
+	decoded, err := decodeTerraformLock(lock)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if decoded != lock {
+		t.Errorf("Decoded lock is %q, want %q", decoded, lock)
+	}
+}
+
+func TestDecodeTerraformLockInvalid(t *testing.T) {
+	for _, value := range []string{
+		compressedLockPrefix + "not base64!",
+		compressedLockPrefix + base64.StdEncoding.EncodeToString([]byte("not zlib")),
+	} {
+		_, err := decodeTerraformLock(value)
+		if err == nil {
+			t.Errorf("Decoding %q succeeded, want an error", value)
+		}
+	}
+}
+
+// terraformStateVersionNumbers returns the version numbers of the terraform state name, newest first.
+func terraformStateVersionNumbers(t *testing.T, tx *sql.Tx, name string) []int {
+	t.Helper()
+
+	versions, err := database.GetTerraformStateVersions(context.Background(), tx, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	numbers := make([]int, 0, len(versions))
+	for _, version := range versions {
+		numbers = append(numbers, version.Version)
+	}
+
+	return numbers
+}
+
+func TestWriteTerraformStateRetention(t *testing.T) {
+	tests := []struct {
+		name     string
+		retained *string
+		want     []int
+	}{
+		{name: "default", retained: nil, want: []int{5, 4, 3, 2, 1}},
+		{name: "configured", retained: ptr("3"), want: []int{5, 4, 3}},
+		{name: "single version", retained: ptr("1"), want: []int{5}},
+		{name: "invalid", retained: ptr("0"), want: []int{5, 4, 3, 2, 1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tx := database.NewTestSchemaTx(t)
+			if tt.retained != nil {
+				setTestConfig(t, tx, TerraformStateVersionsKey, *tt.retained, nil)
+			}
+
+			for i := 1; i <= 5; i++ {
+				for _, name := range []string{"plan", "other"} {
+					err := writeTerraformState(context.Background(), tx, name, fmt.Sprintf(`{"serial":%d}`, i))
+					if err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+
+			for _, name := range []string{"plan", "other"} {
+				got := terraformStateVersionNumbers(t, tx, name)
+				if !reflect.DeepEqual(got, tt.want) {
+					t.Errorf("State %q has versions %v, want %v", name, got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+// testTerraformLock returns the terraform lock name stored in tx.
+func testTerraformLock(t *testing.T, tx *sql.Tx, name string) (types.Lock, bool) {
+	t.Helper()
+
+	var lock types.Lock
+	value, exists, err := terraformLockRecord(context.Background(), tx, tflockPrefix+name)
+	if err != nil || !exists {
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return lock, false
+	}
+
+	err = json.Unmarshal([]byte(value), &lock)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return lock, true
+}
+
+func TestUpdateTerraformLock(t *testing.T) {
+	tx := database.NewTestSchemaTx(t)
+	ctx := context.Background()
+	now := time.Now().UTC()
+
+	holder := types.Lock{ID: "1", Operation: "OperationTypeApply", Who: "holder@host", TTL: 60}
+	held, err := updateTerraformLock(ctx, tx, "member", "plan", holder, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if held.ID != "" {
+		t.Errorf("Acquiring a free lock returned the held lock %q", held.ID)
+	}
+
+	lock, exists := testTerraformLock(t, tx, "plan")
+	if !exists || lock.ID != "1" || lock.AcquiredAt == nil || lock.ExpiresAt == nil {
+		t.Fatalf("Stored lock is %+v, want lock 1 with its acquisition and expiry times", lock)
+	}
+
+	_, err = updateTerraformLock(ctx, tx, "member", "plan", holder, now)
+	if !api.StatusErrorCheck(err, http.StatusLocked) {
+		t.Errorf("Acquiring the same lock again returned %v, want a 423 error", err)
+	}
+
+	other := types.Lock{ID: "2", Operation: "OperationTypeApply", Who: "other@host"}
+	held, err = updateTerraformLock(ctx, tx, "member", "plan", other, now.Add(30*time.Second))
+	if !api.StatusErrorCheck(err, http.StatusConflict) {
+		t.Errorf("Acquiring a held lock returned %v, want a 409 error", err)
+	}
+
+	if held.ID != "1" {
+		t.Errorf("Conflict returned the held lock %q, want 1", held.ID)
+	}
+
+	held, err = updateTerraformLock(ctx, tx, "member", "plan", other, now.Add(2*time.Minute))
+	if err != nil {
+		t.Fatalf("Acquiring an expired lock failed: %v", err)
+	}
+
+	if held.ID != "" {
+		t.Errorf("Replacing an expired lock returned the held lock %q", held.ID)
+	}
+
+	lock, _ = testTerraformLock(t, tx, "plan")
+	if lock.ID != "2" {
+		t.Errorf("Stored lock is %q after replacing the expired lock, want 2", lock.ID)
+	}
+}
+
+func TestDeleteTerraformLock(t *testing.T) {
+	tx := database.NewTestSchemaTx(t)
+	ctx := context.Background()
+
+	held, err := deleteTerraformLock(ctx, tx, "plan", "")
+	if err != nil || held.ID != "" {
+		t.Errorf("Unlocking a free lock with an empty body returned %q, %v", held.ID, err)
+	}
+
+	holder := types.Lock{ID: "1", Operation: "OperationTypeApply", Who: "holder@host"}
+	_, err = updateTerraformLock(ctx, tx, "member", "plan", holder, time.Now().UTC())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	other, err := json.Marshal(types.Lock{ID: "2", Operation: "OperationTypeApply", Who: "holder@host"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, body := range map[string]string{"an empty body": "", "a mismatched ID": string(other)} {
+		held, err = deleteTerraformLock(ctx, tx, "plan", body)
+		if !api.StatusErrorCheck(err, http.StatusConflict) {
+			t.Errorf("Unlocking a held lock with %s returned %v, want a 409 error", name, err)
+		}
+
+		if held.ID != "1" {
+			t.Errorf("Conflict with %s returned the held lock %q, want 1", name, held.ID)
+		}
+
+		_, exists := testTerraformLock(t, tx, "plan")
+		if !exists {
+			t.Fatalf("Unlocking with %s released the held lock", name)
+		}
+	}
+
+	body, err := json.Marshal(holder)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = deleteTerraformLock(ctx, tx, "plan", string(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, exists := testTerraformLock(t, tx, "plan")
+	if exists {
+		t.Error("Unlocking with the held lock did not release it")
+	}
+
+	held, err = deleteTerraformLock(ctx, tx, "plan", "")
+	if err != nil || held.ID != "" {
+		t.Errorf("Unlocking a released lock with an empty body returned %q, %v", held.ID, err)
+	}
+}
+
+func TestReleaseExpiredTerraformLocks(t *testing.T) {
+	tx := database.NewTestSchemaTx(t)
+	ctx := context.Background()
+	now := time.Now().UTC()
+	acquiredAt := now.Add(-2 * time.Minute)
+
+	locks := map[string]types.Lock{
+		"expired":   {ID: "1", TTL: 60, AcquiredAt: &acquiredAt},
+		"renewed":   {ID: "2", TTL: 60, AcquiredAt: &acquiredAt, LastHeartbeatAt: &now},
+		"permanent": {ID: "3", AcquiredAt: &acquiredAt},
+	}
+
+	for name, lock := range locks {
+		j, err := json.Marshal(lock)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		setTestConfig(t, tx, tflockPrefix+name, string(j), nil)
+	}
+
+	released, err := releaseExpiredTerraformLocks(ctx, tx, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(released, []string{"expired"}) {
+		t.Errorf("Released locks %v, want only expired", released)
+	}
+
+	for name := range locks {
+		_, exists := testTerraformLock(t, tx, name)
+		if exists != (name != "expired") {
+			t.Errorf("Lock %q exists is %v after the release", name, exists)
+		}
+	}
+}
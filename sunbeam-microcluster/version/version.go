@@ -3,3 +3,9 @@ package version
 
 // Version is the current API version.
 const Version = "0.1"
+
+// GitCommit is the commit the daemon was built from, set at build time with -ldflags.
+var GitCommit = "unknown"
+
+// BuildDate is the date the daemon was built, set at build time with -ldflags.
+var BuildDate = "unknown"
@@ -25,6 +25,9 @@ from sunbeam.clusterd import service
 
 LOG = logging.getLogger(__name__)
 
+# Roles a node can join the cluster with, the cluster rejects any other.
+NODE_ROLES = ("control", "compute", "storage")
+
 
 class MicroClusterService(service.BaseService):
     """Client for default MicroCluster Service API."""
@@ -43,18 +46,24 @@ class MicroClusterService(service.BaseService):
         data = {"bootstrap": True, "address": address, "name": name}
         self._post("cluster/control", data=json.dumps(data))
 
-    def join(self, name: str, address: str, token: str) -> None:
+    def join(
+        self, name: str, address: str, token: str, role: list[str] | None = None
+    ) -> None:
         """Join node to the micro cluster.
 
         Verified the token with the list of saved tokens and
-        joins the node with the given name and address.
+        joins the node with the given name and address. The roles
+        are passed in the join config, the cluster registers the
+        node with them once it has joined.
 
         Raises NodeAlreadyExistsException if the node is already
         part of the cluster.
         Raises NodeJoinException if the token doesnot match or not
         part of the generated tokens list.
         """
-        data = {"join_token": token, "address": address, "name": name}
+        data: dict[str, Any] = {"join_token": token, "address": address, "name": name}
+        if role:
+            data["config"] = {"role": ",".join(role)}
         self._post("cluster/control", data=json.dumps(data))
 
     def get_cluster_members(self) -> list:
@@ -206,6 +215,10 @@ class ExtendedAPIService(service.BaseService):
         """Unlock plan."""
         self._put(f"/1.0/terraformunlock/{plan}", data=json.dumps(lock))
 
+    def force_unlock_terraform_plan(self, plan: str) -> None:
+        """Unlock plan without checking the lock ID."""
+        self._delete(f"/1.0/terraformlock/{plan}", params={"force": "true"})
+
     def add_manifest(self, data: str) -> str:
         """Add manifest to cluster database."""
         manifest_id = secrets.token_hex(16)
@@ -259,9 +272,22 @@ class ClusterService(MicroClusterService, ExtendedAPIService):
         return self.generate_token(name)
 
     def join_node(self, name: str, address: str, token: str, role: List[str]) -> None:
-        """Join node to cluster and register node information."""
-        self.join(name, address, token)
-        self.add_node_info(name, role)
+        """Join node to cluster, the node information is registered on join.
+
+        The roles are validated before joining, the cluster only checks
+        them once the node is a member.
+
+        Raises NodeJoinException if no role or an invalid role is given.
+        """
+        if not role:
+            raise service.NodeJoinException("No node role given")
+        invalid = [r for r in role if r not in NODE_ROLES]
+        if invalid:
+            raise service.NodeJoinException(
+                f"Invalid node roles {', '.join(invalid)}, "
+                f"valid roles are {', '.join(NODE_ROLES)}"
+            )
+        self.join(name, address, token, role)
 
     def remove_node(self, name) -> None:
         """Remove node from cluster and database.
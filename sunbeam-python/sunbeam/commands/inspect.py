@@ -151,7 +151,10 @@ def unlock_plan(ctx: click.Context, plan: str, force: bool):
                 abort=True,
             )
     try:
-        client.cluster.unlock_terraform_plan(plan, lock)
+        if force:
+            client.cluster.force_unlock_terraform_plan(plan)
+        else:
+            client.cluster.unlock_terraform_plan(plan, lock)
     except ConfigItemNotFoundException as e:
         raise click.ClickException(f"Lock for {plan!r} not found") from e
     console.print(f"Unlocked plan {plan!r}")
@@ -13,6 +13,7 @@
 # See the License for the specific language governing permissions and
 # limitations under the License.
 
+import json
 from unittest.mock import AsyncMock, MagicMock, Mock
 
 import pytest
@@ -298,6 +299,43 @@ class TestClusterService:
         with pytest.raises(service.NodeAlreadyExistsException):
             cs.join("node-2", "10.10.1.11:7000", "TESTTOKEN")
 
+    def test_join_node(self):
+        json_data = {
+            "type": "sync",
+            "status": "Success",
+            "status_code": 200,
+            "operation": "",
+            "error_code": 0,
+            "error": "",
+            "metadata": {},
+        }
+        mock_response = self._mock_response(
+            status=200,
+            json_data=json_data,
+        )
+
+        mock_session = MagicMock()
+        mock_session.request.return_value = mock_response
+
+        cs = ClusterService(mock_session, "http+unix://mock")
+        cs.join_node("node-2", "10.10.1.11:7000", "TESTTOKEN", ["control", "compute"])
+        data = json.loads(mock_session.request.call_args.kwargs["data"])
+        assert data == {
+            "join_token": "TESTTOKEN",
+            "address": "10.10.1.11:7000",
+            "name": "node-2",
+            "config": {"role": "control,compute"},
+        }
+
+    @pytest.mark.parametrize("role", [[], ["control", "network"]])
+    def test_join_node_with_invalid_role(self, role):
+        mock_session = MagicMock()
+
+        cs = ClusterService(mock_session, "http+unix://mock")
+        with pytest.raises(service.NodeJoinException):
+            cs.join_node("node-2", "10.10.1.11:7000", "TESTTOKEN", role)
+        mock_session.request.assert_not_called()
+
     def test_get_cluster_members(self):
         json_data = {
             "type": "sync",